@@ -0,0 +1,404 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/nft"
+	"gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+var ErrArchive = errors.New("archive")
+
+// export записывает блоки и NFT в архив и возвращает код завершения.
+// Используется как подкоманда: umid export [-from N] [-to N] [-gzip] -out file.
+func export(args []string) int {
+	conf := config.DefaultConfig()
+	conf.ParseEnvs()
+
+	var (
+		from, to uint
+		out      string
+		compress bool
+	)
+
+	flagSet := flag.NewFlagSet("export", flag.ExitOnError)
+	conf.RegisterFlags(flagSet)
+
+	flagSet.UintVar(&from, "from", 1, "First block height to export.")
+	flagSet.UintVar(&to, "to", 0, "Last block height to export (0 = last block).")
+	flagSet.StringVar(&out, "out", "", "Archive file name.")
+	flagSet.BoolVar(&compress, "gzip", false, "Compress archive with gzip.")
+
+	_ = flagSet.Parse(args)
+
+	if out == "" {
+		log.Println("export: -out is required")
+
+		return 1
+	}
+
+	if err := exportArchive(conf, uint32(from), uint32(to), out, compress); err != nil {
+		log.Printf("export: %v", err)
+
+		return 1
+	}
+
+	return 0
+}
+
+// importArchive загружает блоки и NFT из архива и возвращает код завершения.
+// Используется как подкоманда: umid import [-datadir path] file.
+func importArchive(args []string) int {
+	conf := config.DefaultConfig()
+	conf.ParseEnvs()
+
+	flagSet := flag.NewFlagSet("import", flag.ExitOnError)
+	conf.RegisterFlags(flagSet)
+
+	_ = flagSet.Parse(args)
+
+	if flagSet.NArg() != 1 {
+		log.Println("usage: umid import [flags] file")
+
+		return 1
+	}
+
+	if err := loadArchive(conf, flagSet.Arg(0)); err != nil {
+		log.Printf("import: %v", err)
+
+		return 1
+	}
+
+	return 0
+}
+
+// exportArchive только читает данные узла: блоки не подтверждаются заново, леджер не строится,
+// а файлы блокчейна не изменяются. NFT попадают в архив, только если выпущены в экспортируемых блоках.
+func exportArchive(conf *config.Config, from, to uint32, out string, compress bool) error {
+	blockchain, err := initBlockchain(conf)
+	if err != nil {
+		return err
+	}
+	defer blockchain.Close()
+
+	if err := blockchain.Load(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	nftStorage, err := openNftStorage(conf)
+	if err != nil {
+		return err
+	}
+	defer nftStorage.Close()
+
+	if to == 0 || int(to) > blockchain.Height() {
+		to = uint32(blockchain.Height())
+	}
+
+	if from == 0 || from > to {
+		return fmt.Errorf("%w: пустой диапазон блоков %d-%d", ErrArchive, from, to)
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer file.Close()
+
+	currentTime := time.Now()
+
+	log.Printf("exporting blocks %d-%d to %s...", from, to, out)
+
+	archive, err := storage.NewArchiveWriter(file, conf.Network, from, compress)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	minted := make([]umi.Hash, 0)
+
+	for height := from; height <= to; height++ {
+		block, err := blockchain.Block(height)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		if err := archive.WriteBlock(block); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		for i, j := 0, block.TransactionCount(); i < j; i++ {
+			if transaction := block.Transaction(i); transaction.Version() == umi.TxV18MintNftWitness {
+				minted = append(minted, transaction.Hash())
+			}
+		}
+	}
+
+	nfts := 0
+
+	for _, hash := range minted {
+		data, err := nftStorage.Data(hash)
+		if errors.Is(err, nft.ErrNotFound) {
+			log.Printf("export: данные NFT %x еще не загружены, пропускаем", hash[:])
+
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		if err := archive.WriteNft(data); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		nfts++
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	log.Printf("exported %d blocks and %d NFT tokens, time: %v.",
+		to-from+1, nfts, time.Since(currentTime))
+
+	return nil
+}
+
+// loadArchive подтверждает блоки из архива через Confirmer, как если бы они были получены
+// от пира. Блоки, которые уже есть в блокчейне, сверяются по хэшу и пропускаются.
+//
+//nolint:funlen // ...
+func loadArchive(conf *config.Config, name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer file.Close()
+
+	archive, err := storage.NewArchiveReader(file)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if archive.Network() != conf.Network {
+		return fmt.Errorf("%w: архив для сети %q, ожидаем %q", ErrArchive, archive.Network(), conf.Network)
+	}
+
+	blockchain, ledger1, confirmer, err := openChain(conf)
+	if err != nil {
+		return err
+	}
+	defer blockchain.Close()
+	defer ledger1.CloseStateRoots()
+	defer ledger1.CloseSupplyHistory()
+
+	nftStorage, err := openNftStorage(conf)
+	if err != nil {
+		return err
+	}
+	defer nftStorage.Close()
+
+	currentTime := time.Now()
+
+	log.Printf("importing %s...", name)
+
+	var blocks, nfts int
+
+	height := archive.From()
+
+	for {
+		kind, data, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		switch kind {
+		case storage.ArchiveBlock:
+			added, err := importBlock(conf, blockchain, confirmer, height, data)
+			if err != nil {
+				return err
+			}
+
+			if added {
+				blocks++
+			}
+
+			height++
+
+		case storage.ArchiveNft:
+			added, err := importNft(nftStorage, data)
+			if err != nil {
+				return err
+			}
+
+			if added {
+				nfts++
+			}
+		}
+	}
+
+	if conf.StorageType != "memory" && blocks > 0 {
+		if err := ledger1.WriteSnapshot(); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	log.Printf("imported %d blocks and %d NFT tokens, time: %v.", blocks, nfts, time.Since(currentTime))
+
+	return nil
+}
+
+func importBlock(conf *config.Config, blockchain storage.IBlockchain, confirmer *ledger.ConfirmerLegacy,
+	height uint32, block []byte) (bool, error) {
+	if int(height) <= blockchain.Height() {
+		stored, err := blockchain.Block(height)
+		if err != nil {
+			return false, fmt.Errorf("%w", err)
+		}
+
+		if !bytes.Equal(stored, block) {
+			return false, fmt.Errorf("%w: блок %d отличается от записанного в блокчейне", ErrArchive, height)
+		}
+
+		return false, nil
+	}
+
+	if int(height) != blockchain.Height()+1 {
+		return false, fmt.Errorf("%w: в блокчейне %d блоков, архив продолжается с блока %d",
+			ErrArchive, blockchain.Height(), height)
+	}
+
+	// Блок проходит тот же путь, что и полученный от пира, включая сверку с контрольными точками.
+	legacy := (umi.Block)(block).Legacy()
+
+	if err := storage.VerifyBlockLegacy(conf, legacy); err != nil {
+		return false, fmt.Errorf("блок %d: %w", height, err)
+	}
+
+	if err := confirmer.AppendBlockLegacy(legacy); err != nil {
+		return false, fmt.Errorf("блок %d: %w", height, err)
+	}
+
+	return true, nil
+}
+
+// importNft добавляет NFT в хранилище. Архив может начинаться не с первого блока, поэтому
+// уже загруженные токены ищутся по хэшу, а не по порядковому номеру.
+func importNft(nftStorage *nft.Storage, data []byte) (bool, error) {
+	_, err := nftStorage.Data(sha256.Sum256(data))
+	if err == nil {
+		return false, nil
+	}
+
+	if !errors.Is(err, nft.ErrNotFound) {
+		return false, fmt.Errorf("%w", err)
+	}
+
+	if err = nftStorage.AppendData(data); err != nil {
+		return false, fmt.Errorf("%w", err)
+	}
+
+	return true, nil
+}
+
+// openChain открывает блокчейн и загружает леджер так же, как при запуске узла.
+func openChain(conf *config.Config) (storage.IBlockchain, *ledger.Ledger, *ledger.ConfirmerLegacy, error) {
+	blockchain, err := initBlockchain(conf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ledger1 := ledger.NewLedger(conf)
+
+	checkpoints, err := storage.LoadCheckpoints(conf)
+	if err != nil {
+		blockchain.Close()
+
+		return nil, nil, nil, fmt.Errorf("%w", err)
+	}
+
+	ledger1.SetCheckpoints(checkpoints)
+
+	if err := ledger1.OpenStateRoots(); err != nil {
+		blockchain.Close()
+
+		return nil, nil, nil, fmt.Errorf("%w", err)
+	}
+
+	if err := ledger1.OpenSupplyHistory(); err != nil {
+		ledger1.CloseStateRoots()
+		blockchain.Close()
+
+		return nil, nil, nil, fmt.Errorf("%w", err)
+	}
+
+	confirmer := ledger.NewConfirmerLegacy(ledger1)
+	confirmer.SetBlockchain(blockchain)
+
+	log.Println("scanning blockchain...")
+
+	if err := scanBlockchain(conf, blockchain, ledger1, confirmer); err != nil {
+		ledger1.CloseSupplyHistory()
+		ledger1.CloseStateRoots()
+		blockchain.Close()
+
+		return nil, nil, nil, err
+	}
+
+	return blockchain, ledger1, confirmer, nil
+}
+
+func openNftStorage(conf *config.Config) (*nft.Storage, error) {
+	nftStorage := nft.NewStorage(conf)
+
+	if err := nftStorage.OpenOrCreate(); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if err := nftStorage.Scan(); err != nil {
+		nftStorage.Close()
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return nftStorage, nil
+}
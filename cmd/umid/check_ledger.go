@@ -0,0 +1,72 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/config"
+)
+
+// checkLedger загружает леджер и проверяет его инварианты, возвращает код завершения.
+// Используется как подкоманда: umid check-ledger [-check-invariants] [-datadir path].
+// С флагом -check-invariants дополнительно проверяется каждый блок, применяемый после снимка леджера.
+func checkLedger(args []string) int {
+	conf := config.DefaultConfig()
+	conf.ParseEnvs()
+
+	flagSet := flag.NewFlagSet("check-ledger", flag.ExitOnError)
+	conf.RegisterFlags(flagSet)
+
+	_ = flagSet.Parse(args)
+
+	currentTime := time.Now()
+
+	blockchain, ledger1, _, err := openChain(conf)
+	if err != nil {
+		log.Printf("check-ledger: %v", err)
+
+		return 1
+	}
+	defer blockchain.Close()
+	defer ledger1.CloseStateRoots()
+	defer ledger1.CloseSupplyHistory()
+
+	log.Printf("checking ledger at height %d...", ledger1.LastBlockHeight)
+
+	violations := ledger1.CheckInvariants()
+
+	for _, err := range violations {
+		log.Printf("%v", err)
+	}
+
+	if len(violations) > 0 {
+		log.Printf("found %d violations, time: %v.", len(violations), time.Since(currentTime))
+
+		return 1
+	}
+
+	log.Printf("no violations found, time: %v.", time.Since(currentTime))
+
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestCheckLedger(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	blockchain, err := initBlockchain(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := storage.GenesisBlock(conf.Network)
+
+	if err := blockchain.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	blockchain.Close()
+
+	ledger1 := ledger.NewLedger(conf)
+	confirmer := ledger.NewConfirmer(ledger1)
+
+	if err := confirmer.ProcessBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := confirmer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ledger1.WriteSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-datadir", conf.DataDir}
+
+	if code := checkLedger(args); code != 0 {
+		t.Fatalf("expected 0, got %d", code)
+	}
+
+	// Портим баланс структуры UMI в снимке и пересчитываем контрольную сумму, чтобы снимок загрузился.
+	structure, _ := ledger1.Structure(umi.PfxVerUmi)
+	corruptSnapshot(t, conf, structure.Balance)
+
+	if code := checkLedger(args); code != 1 {
+		t.Errorf("expected 1, got %d", code)
+	}
+}
+
+func corruptSnapshot(t *testing.T, conf *config.Config, balance uint64) {
+	t.Helper()
+
+	names, _ := filepath.Glob(filepath.Join(conf.DataDir, conf.Network, "snapshots", "ledger-*"))
+	if len(names) != 1 {
+		t.Fatalf("expected 1, got %d", len(names))
+	}
+
+	data, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := data[:len(data)-sha256.Size]
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, balance)
+
+	offset := bytes.Index(body, value)
+	if offset < 0 {
+		t.Fatal("баланс не найден в снимке")
+	}
+
+	binary.BigEndian.PutUint64(body[offset:], balance+1)
+	checksum := sha256.Sum256(body)
+
+	if err := os.WriteFile(names[0], append(body, checksum[:]...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
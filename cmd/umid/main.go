@@ -47,6 +47,19 @@ var ErrStorage = errors.New("storage")
 func main() {
 	log.SetFlags(log.LstdFlags /*| log.Lshortfile*/)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(verify(os.Args[2:]))
+		case "export":
+			os.Exit(export(os.Args[2:]))
+		case "import":
+			os.Exit(importArchive(os.Args[2:]))
+		case "check-ledger":
+			os.Exit(checkLedger(os.Args[2:]))
+		}
+	}
+
 	ctx := context.Background()
 
 	conf := config.DefaultConfig()
@@ -59,19 +72,63 @@ func main() {
 	defer blockchain.Close()
 
 	ledger1 := ledger.NewLedger(conf)
+
+	checkpoints, err := storage.LoadCheckpoints(conf)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ledger1.SetCheckpoints(checkpoints)
+
+	if err := ledger1.OpenStateRoots(); err != nil {
+		log.Fatal(err)
+	}
+	defer ledger1.CloseStateRoots()
+
+	if err := ledger1.OpenSupplyHistory(); err != nil {
+		log.Fatal(err)
+	}
+	defer ledger1.CloseSupplyHistory()
+
 	confirmer := ledger.NewConfirmerLegacy(ledger1)
 	confirmer.SetBlockchain(blockchain)
 
-	index := storage.NewIndex()
-	index.SubscribeTo(blockchain)
+	index := storage.NewIndex(conf)
+
+	if err := index.OpenOrCreate(); err != nil {
+		log.Fatal(err)
+	}
+	defer index.Close()
 
 	go index.Worker(ctx)
 
 	mempool := storage.NewMempool()
 	mempool.SetLedger(ledger1)
+	mempool.SetLimits(conf.MempoolMaxCount, conf.MempoolMaxBytes, conf.MempoolSenderQuota)
 
 	nftMempool := nft.NewMempool()
 	nftMempool.SetLedger(ledger1)
+	nftMempool.SetLimits(conf.NftMempoolMaxCount, conf.NftMempoolMaxBytes, conf.NftMempoolSenderQuota)
+
+	if conf.StorageType != "memory" {
+		mempoolJournal := storage.NewJournal(conf, "mempool")
+
+		if err := mempoolJournal.OpenOrCreate(); err != nil {
+			log.Fatal(err)
+		}
+		defer mempoolJournal.Close()
+
+		mempool.SetJournal(mempoolJournal)
+
+		nftMempoolJournal := storage.NewJournal(conf, "nftmempool")
+
+		if err := nftMempoolJournal.OpenOrCreate(); err != nil {
+			log.Fatal(err)
+		}
+		defer nftMempoolJournal.Close()
+
+		nftMempool.SetJournal(nftMempoolJournal)
+	}
 
 	nftStorage := nft.NewStorage(conf)
 
@@ -87,12 +144,28 @@ func main() {
 
 		log.Println("scanning blockchain...")
 
-		if err := blockchain.Scan(confirmer); err != nil {
+		if err := scanBlockchain(conf, blockchain, ledger1, confirmer); err != nil {
 			log.Fatal(err)
 		}
 
 		log.Printf("found %d blocks, time: %v.", blockchain.Height(), time.Since(currentTime))
 
+		if conf.StorageType != "memory" {
+			go ledger1.Worker(ctx)
+		}
+
+		currentTime = time.Now()
+
+		log.Println("indexing transactions...")
+
+		if err := index.Sync(blockchain); err != nil {
+			log.Fatal(err)
+		}
+
+		index.SubscribeTo(blockchain)
+
+		log.Printf("indexed %d blocks, time: %v.", index.Height(), time.Since(currentTime))
+
 		currentTime = time.Now()
 
 		log.Println("scanning nft...")
@@ -109,13 +182,30 @@ func main() {
 			}
 		}
 
+		if err := mempool.Restore(); err != nil {
+			log.Println(err)
+		}
+
+		if err := nftMempool.Restore(); err != nil {
+			log.Println(err)
+		}
+
+		log.Printf("restored %d mempool transactions.", len(mempool.Mempool()))
+
 		if _, ok := os.LookupEnv("UMI_MASTER_KEY"); ok {
+			// Блоки, подписанные недоверенным ключом, не пройдут проверку при следующем запуске.
+			if !conf.TrustedGenerator(generator.PublicKey()) {
+				log.Fatal("ключ UMI_MASTER_KEY не входит в список доверенных генераторов UMI_GENERATOR_KEYS")
+			}
+
 			go generator.NewGenerator(confirmer, mempool, nftMempool).
 				SetNftStorage(nftStorage).
+				SetLimits(conf.GeneratorMaxTransactions, conf.GeneratorMaxNftBytes).
 				Worker(ctx)
 		} else {
 			fetcher := legacy.NewFetcher(conf)
 			fetcher.SetConfirmer(confirmer)
+			fetcher.SetBlockchain(blockchain)
 			fetcher.SetNftStorage(nftStorage)
 
 			go fetcher.Worker(ctx)
@@ -173,9 +263,67 @@ func initBlockchain(conf *config.Config) (blockchain storage.IBlockchain, err er
 			return nil, fmt.Errorf("%w", err)
 		}
 
+	case "mmap":
+		blockchain = storage.NewBlockchainMmap(conf)
+		if err = blockchain.OpenOrCreate(); err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
 	default:
 		return nil, fmt.Errorf("%w: unknown storage type", ErrStorage)
 	}
 
 	return blockchain, nil
 }
+
+// scanBlockchain загружает последний снимок леджера и дочитывает блоки после него.
+// Если снимок не совпадает с блокчейном, леджер строится заново по всем блокам.
+func scanBlockchain(conf *config.Config, blockchain storage.IBlockchain, ledger1 *ledger.Ledger,
+	confirmer *ledger.ConfirmerLegacy) error {
+	if conf.StorageType != "memory" {
+		if height := ledger1.LoadSnapshot(); height > 0 {
+			log.Printf("loaded ledger snapshot at height %d.", height)
+		}
+	}
+
+	err := blockchain.Scan(confirmer)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, storage.ErrSnapshot) {
+		return fmt.Errorf("%w", err)
+	}
+
+	log.Printf("%v, rebuilding ledger...", err)
+
+	ledger1.Reset()
+
+	// Блоки проверяются так же, как при обычном чтении блокчейна: второй Scan начнет
+	// с высоты, до которой леджер перестроен здесь.
+	for height := uint32(1); int(height) <= blockchain.Height(); height++ {
+		block, err := blockchain.Block(height)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		err = storage.VerifyBlock(conf, block)
+		if err == nil {
+			err = confirmer.ProcessBlock(block)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: блок %d (%s): %v", storage.ErrBlockVerification, height, block.Hash(), err)
+		}
+
+		if err := confirmer.Commit(); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	if err := blockchain.Scan(confirmer); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestScanBlockchain_RebuildVerifiesBlocks(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	conf.GeneratorKeys = append(conf.GeneratorKeys, hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+
+	genesis := storage.GenesisBlock(conf.Network)
+	sender := genesis.Transaction(0).Recipient()
+
+	// Блок без подписи и корня Меркла не проходит проверку при чтении блокчейна.
+	newBlock := func(previous umi.Block, timestamp uint32, amount uint64) umi.Block {
+		transaction := make(umi.Transaction, umi.TxConfirmedLength)
+		copy(transaction, umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).
+			SetRecipient(sender).SetAmount(amount))
+
+		block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+		block.SetPreviousBlockHash(previous.Hash())
+		block.SetTimestamp(timestamp)
+
+		return append(block, transaction...)
+	}
+
+	// Подписанный доверенным ключом блок проходит проверку.
+	signBlock := func(block umi.Block) umi.Block {
+		legacy := block.Legacy()
+		block.SetMerkleRootHash(umi.MerkleRoot(legacy[umi.HdrLength:]))
+
+		copy(block[71:103], key.Public().(ed25519.PublicKey))
+		copy(block[103:167], ed25519.Sign(key, block[0:103]))
+
+		return block
+	}
+
+	block2 := newBlock(genesis, genesis.Timestamp()+1, 1)
+
+	blockchain, err := initBlockchain(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []umi.Block{genesis, block2, signBlock(newBlock(block2, genesis.Timestamp()+2, 2))} {
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blockchain.Close()
+
+	// Снимок расходится с блокчейном только на высоте 3. Блок 2 при первом чтении пропускается
+	// как уже учтенный в снимке и должен быть проверен при перестроении леджера.
+	ledger1 := ledger.NewLedger(conf)
+	confirmer := ledger.NewConfirmer(ledger1)
+
+	for _, block := range []umi.Block{genesis, block2, newBlock(block2, genesis.Timestamp()+3, 2)} {
+		if err := confirmer.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := confirmer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ledger1.WriteSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	blockchain, err = initBlockchain(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Close()
+
+	ledger2 := ledger.NewLedger(conf)
+
+	err = scanBlockchain(conf, blockchain, ledger2, ledger.NewConfirmerLegacy(ledger2))
+	if !errors.Is(err, storage.ErrBlockVerification) {
+		t.Errorf("ожидаем '%v', получили '%v'", storage.ErrBlockVerification, err)
+	}
+}
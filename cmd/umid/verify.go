@@ -0,0 +1,140 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/storage"
+)
+
+// verify проверяет блокчейн, записанный на диск, и возвращает код завершения.
+// Используется как подкоманда: umid verify [-replay] [-print-checkpoints N] [-datadir path].
+func verify(args []string) int {
+	conf := config.DefaultConfig()
+	conf.ParseEnvs()
+
+	var (
+		replay   bool
+		interval uint
+	)
+
+	flagSet := flag.NewFlagSet("verify", flag.ExitOnError)
+	conf.RegisterFlags(flagSet)
+
+	usage := "Replay blocks through a fresh ledger and compare confirmed transaction metadata."
+	flagSet.BoolVar(&replay, "replay", false, usage)
+
+	usage = "Print checkpoints of every N-th verified block and the last one in the checkpoints file format."
+	flagSet.UintVar(&interval, "print-checkpoints", 0, usage)
+
+	_ = flagSet.Parse(args)
+
+	if _, err := os.Stat(path.Join(conf.DataDir, conf.Network, "index")); err != nil {
+		log.Printf("verify: %v", err)
+
+		return 1
+	}
+
+	blockchain := storage.NewBlockchain(conf)
+	if err := blockchain.OpenOrCreate(); err != nil {
+		log.Printf("verify: %v", err)
+
+		return 1
+	}
+	defer blockchain.Close()
+
+	currentTime := time.Now()
+
+	log.Printf("verifying blockchain in %s...", path.Join(conf.DataDir, conf.Network))
+
+	var (
+		height uint32
+		err    error
+	)
+
+	if replay {
+		height, err = blockchain.Audit(ledger.NewConfirmerLegacy(ledger.NewLedger(conf)))
+	} else {
+		height, err = blockchain.Audit(nil)
+	}
+
+	if err != nil {
+		log.Printf("verified %d blocks, first bad block: %v", height, err)
+
+		return 1
+	}
+
+	log.Printf("verified %d blocks, time: %v.", height, time.Since(currentTime))
+
+	if interval > 0 {
+		if err := printCheckpoints(os.Stdout, blockchain, height, uint32(interval)); err != nil {
+			log.Printf("verify: %v", err)
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// printCheckpoints выводит контрольные точки проверенного блокчейна в формате файла
+// config.CheckpointsFile. Из этого вывода обновляются встроенные контрольные точки.
+func printCheckpoints(w io.Writer, blockchain storage.IBlockchain, height, interval uint32) error {
+	type checkpoint struct {
+		Height uint32 `json:"height"`
+		Hash   string `json:"hash"`
+	}
+
+	items := make([]checkpoint, 0)
+
+	for h := interval; h < height; h += interval {
+		block, err := blockchain.Block(h)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		items = append(items, checkpoint{Height: h, Hash: block.Hash().String()})
+	}
+
+	if height > 0 {
+		block, err := blockchain.Block(height)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		items = append(items, checkpoint{Height: height, Hash: block.Hash().String()})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(items)
+}
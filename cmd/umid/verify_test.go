@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/storage"
+)
+
+func TestPrintCheckpoints(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	genesis := storage.GenesisBlock(conf.Network)
+
+	blockchain := storage.NewBlockchainMemory(conf)
+
+	if err := blockchain.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := printCheckpoints(&buf, blockchain, 1, 1000); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	// Вывод читается как файл контрольных точек и совпадает со встроенной точкой.
+	conf.CheckpointsFile = path.Join(t.TempDir(), "checkpoints.json")
+
+	if err := os.WriteFile(conf.CheckpointsFile, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err := storage.LoadCheckpoints(conf)
+	if err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if len(checkpoints) != 1 || checkpoints[1] != genesis.Hash() {
+		t.Errorf("ожидаем контрольную точку GENESIS-блока, получили %s", buf.String())
+	}
+}
@@ -21,12 +21,21 @@
 package config
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 )
 
+// generatorKeys — публичные ключи генераторов блоков, которым доверяем по умолчанию.
+var generatorKeys = map[string][]string{
+	"mainnet": {"45885c9687d799a4d1f4d786d8639274d293ed024ad7f7a436715d6217c9f72b"},
+	"testnet": {"4652d6097f24434facbc182894fafa4fc5ade78db3e01705a6324d35d8d34a69"},
+}
+
 type Config struct {
 	IndexSize     int
 	ChunkSize     int
@@ -35,6 +44,28 @@ type Config struct {
 	DataDir       string
 	ListenAddress string
 	Peer          string
+	ReorgDepth    int
+	GeneratorKeys []string
+
+	SnapshotInterval int
+	CheckpointsFile  string
+
+	// CheckInvariants включает проверку инвариантов леджера после каждого блока (отладочный режим).
+	CheckInvariants bool
+
+	// Ограничения мемпулов: количество транзакций, суммарный размер в байтах и количество
+	// неподтвержденных транзакций одного отправителя. Нулевое значение снимает ограничение.
+	MempoolMaxCount       int
+	MempoolMaxBytes       int
+	MempoolSenderQuota    int
+	NftMempoolMaxCount    int
+	NftMempoolMaxBytes    int
+	NftMempoolSenderQuota int
+
+	// Ограничения блока, который собирает генератор: количество транзакций и суммарный размер NFT
+	// в байтах. Нулевое значение снимает ограничение.
+	GeneratorMaxTransactions int
+	GeneratorMaxNftBytes     int
 }
 
 func DefaultConfig() *Config {
@@ -51,6 +82,20 @@ func DefaultConfig() *Config {
 		DataDir:       path.Join(homeDir, "umi"),
 		ListenAddress: "127.0.0.1:8080",
 		Peer:          "https://mainnet.umi.top",
+		ReorgDepth:    1_000, // Максимальное количество блоков, которые можно откатить при смене цепочки.
+		GeneratorKeys: generatorKeys["mainnet"],
+
+		SnapshotInterval: 100_000, // Снимок леджера сохраняется каждые 100_000 блоков.
+
+		MempoolMaxCount:       100_000,
+		MempoolMaxBytes:       32 << 20, // 32MB
+		MempoolSenderQuota:    1_000,
+		NftMempoolMaxCount:    10_000,
+		NftMempoolMaxBytes:    256 << 20, // 256MB
+		NftMempoolSenderQuota: 100,
+
+		GeneratorMaxTransactions: 0,        // 65535
+		GeneratorMaxNftBytes:     16 << 20, // 16MB
 	}
 }
 
@@ -63,6 +108,7 @@ func (config *Config) ParseEnvs() {
 	if network, ok := os.LookupEnv("UMI_NETWORK"); ok {
 		config.Network = network
 		config.Peer = fmt.Sprintf("https://%s.umi.top", network)
+		config.GeneratorKeys = generatorKeys[network]
 	}
 
 	if dataDir, ok := os.LookupEnv("UMI_DATADIR"); ok {
@@ -80,19 +126,115 @@ func (config *Config) ParseEnvs() {
 	if storage, ok := os.LookupEnv("UMI_STORAGE"); ok {
 		config.StorageType = storage
 	}
+
+	if file, ok := os.LookupEnv("UMI_CHECKPOINTS"); ok {
+		config.CheckpointsFile = file
+	}
+
+	if value, ok := os.LookupEnv("UMI_CHECK_INVARIANTS"); ok {
+		config.CheckInvariants = value != "" && value != "0" && value != "false"
+	}
+
+	config.parseIntEnv("UMI_MEMPOOL_MAX_COUNT", &config.MempoolMaxCount)
+	config.parseIntEnv("UMI_MEMPOOL_MAX_BYTES", &config.MempoolMaxBytes)
+	config.parseIntEnv("UMI_MEMPOOL_SENDER_QUOTA", &config.MempoolSenderQuota)
+	config.parseIntEnv("UMI_NFT_MEMPOOL_MAX_COUNT", &config.NftMempoolMaxCount)
+	config.parseIntEnv("UMI_NFT_MEMPOOL_MAX_BYTES", &config.NftMempoolMaxBytes)
+	config.parseIntEnv("UMI_NFT_MEMPOOL_SENDER_QUOTA", &config.NftMempoolSenderQuota)
+	config.parseIntEnv("UMI_GENERATOR_MAX_TRANSACTIONS", &config.GeneratorMaxTransactions)
+	config.parseIntEnv("UMI_GENERATOR_MAX_NFT_BYTES", &config.GeneratorMaxNftBytes)
+
+	if keys, ok := os.LookupEnv("UMI_GENERATOR_KEYS"); ok {
+		config.GeneratorKeys = nil
+
+		if keys != "" {
+			config.GeneratorKeys = strings.Split(keys, ",")
+		}
+	}
+}
+
+// parseIntEnv читает целочисленную переменную окружения. Некорректное значение игнорируется.
+func (*Config) parseIntEnv(name string, value *int) {
+	if str, ok := os.LookupEnv(name); ok {
+		if i, err := strconv.Atoi(str); err == nil {
+			*value = i
+		}
+	}
+}
+
+// TrustedGenerator проверяет, что блок подписан одним из доверенных генераторов.
+// Если список ключей пуст (например, для собственной сети), проверка не выполняется.
+func (config *Config) TrustedGenerator(publicKey []byte) bool {
+	if len(config.GeneratorKeys) == 0 {
+		return true
+	}
+
+	key := hex.EncodeToString(publicKey)
+
+	for _, trusted := range config.GeneratorKeys {
+		if strings.EqualFold(strings.TrimSpace(trusted), key) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (config *Config) ParseFlags() {
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+}
+
+// RegisterFlags регистрирует общие флаги в наборе флагов, в том числе в наборе флагов подкоманды.
+func (config *Config) RegisterFlags(flagSet *flag.FlagSet) {
 	usage := "The data directory is the location where UMI's data " +
 		"files are stored. Overrides environment variable UMI_DATADIR."
-	flag.StringVar(&config.DataDir, "datadir", config.DataDir, usage)
+	flagSet.StringVar(&config.DataDir, "datadir", config.DataDir, usage)
 
 	usage = "Bind to given address and always listen on it. " +
 		"Use [host]:port notation for IPv6. Overrides environment variable UMI_BIND."
-	flag.StringVar(&config.ListenAddress, "bind", config.ListenAddress, usage)
+	flagSet.StringVar(&config.ListenAddress, "bind", config.ListenAddress, usage)
 
 	usage = "Connect only to specific peer. Overrides environment variable UMI_PEER."
-	flag.StringVar(&config.Peer, "peer", config.Peer, usage)
+	flagSet.StringVar(&config.Peer, "peer", config.Peer, usage)
 
-	flag.Parse()
+	usage = "Load block checkpoints from JSON file. Built-in checkpoints cover only the genesis block, " +
+		"so recent checkpoints must be supplied here. Overrides environment variable UMI_CHECKPOINTS."
+	flagSet.StringVar(&config.CheckpointsFile, "checkpoints", config.CheckpointsFile, usage)
+
+	usage = "Check ledger invariants after every block (slow, for debugging). " +
+		"Overrides environment variable UMI_CHECK_INVARIANTS."
+	flagSet.BoolVar(&config.CheckInvariants, "check-invariants", config.CheckInvariants, usage)
+
+	usage = "Maximum number of pending transactions, 0 means no limit. " +
+		"Overrides environment variable UMI_MEMPOOL_MAX_COUNT."
+	flagSet.IntVar(&config.MempoolMaxCount, "mempool-max-count", config.MempoolMaxCount, usage)
+
+	usage = "Maximum size of pending transactions in bytes, 0 means no limit. " +
+		"Overrides environment variable UMI_MEMPOOL_MAX_BYTES."
+	flagSet.IntVar(&config.MempoolMaxBytes, "mempool-max-bytes", config.MempoolMaxBytes, usage)
+
+	usage = "Maximum number of pending transactions per sender, 0 means no limit. " +
+		"Overrides environment variable UMI_MEMPOOL_SENDER_QUOTA."
+	flagSet.IntVar(&config.MempoolSenderQuota, "mempool-sender-quota", config.MempoolSenderQuota, usage)
+
+	usage = "Maximum number of pending NFT transactions, 0 means no limit. " +
+		"Overrides environment variable UMI_NFT_MEMPOOL_MAX_COUNT."
+	flagSet.IntVar(&config.NftMempoolMaxCount, "nft-mempool-max-count", config.NftMempoolMaxCount, usage)
+
+	usage = "Maximum size of pending NFT transactions in bytes, 0 means no limit. " +
+		"Overrides environment variable UMI_NFT_MEMPOOL_MAX_BYTES."
+	flagSet.IntVar(&config.NftMempoolMaxBytes, "nft-mempool-max-bytes", config.NftMempoolMaxBytes, usage)
+
+	usage = "Maximum number of pending NFT transactions per sender, 0 means no limit. " +
+		"Overrides environment variable UMI_NFT_MEMPOOL_SENDER_QUOTA."
+	flagSet.IntVar(&config.NftMempoolSenderQuota, "nft-mempool-sender-quota", config.NftMempoolSenderQuota, usage)
+
+	usage = "Maximum number of transactions in a generated block, 0 means 65535. " +
+		"Overrides environment variable UMI_GENERATOR_MAX_TRANSACTIONS."
+	flagSet.IntVar(&config.GeneratorMaxTransactions, "generator-max-transactions", config.GeneratorMaxTransactions, usage)
+
+	usage = "Maximum size of NFT data in a generated block in bytes, 0 means no limit. " +
+		"Overrides environment variable UMI_GENERATOR_MAX_NFT_BYTES."
+	flagSet.IntVar(&config.GeneratorMaxNftBytes, "generator-max-nft-bytes", config.GeneratorMaxNftBytes, usage)
 }
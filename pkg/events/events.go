@@ -32,6 +32,7 @@ import (
 
 type iBlockchain interface {
 	Subscribe(chan umi.Block)
+	SubscribeRollback(chan umi.Block)
 }
 
 type iMempool interface {
@@ -41,6 +42,7 @@ type iMempool interface {
 type Events struct {
 	sync.RWMutex
 	blocks        chan umi.Block
+	rollbacks     chan umi.Block
 	transactions  chan *umi.Transaction
 	subscriptions map[umi.Address]map[chan<- []byte]struct{}
 }
@@ -48,6 +50,7 @@ type Events struct {
 func NewEvents() *Events {
 	return &Events{
 		blocks:        make(chan umi.Block),
+		rollbacks:     make(chan umi.Block),
 		transactions:  make(chan *umi.Transaction, 1_000),
 		subscriptions: make(map[umi.Address]map[chan<- []byte]struct{}),
 	}
@@ -55,6 +58,7 @@ func NewEvents() *Events {
 
 func (events *Events) SubscribeTo(blockchain iBlockchain) {
 	blockchain.Subscribe(events.blocks)
+	blockchain.SubscribeRollback(events.rollbacks)
 }
 
 func (events *Events) SubscribeTo2(mempool iMempool) {
@@ -93,6 +97,9 @@ func (events *Events) Worker(ctx context.Context) {
 		case block := <-events.blocks:
 			events.processBlock(block)
 
+		case block := <-events.rollbacks:
+			events.processRollback(block)
+
 		case transaction := <-events.transactions:
 			events.processTransaction(transaction)
 
@@ -122,6 +129,26 @@ func (events *Events) processBlock(block umi.Block) {
 	}
 }
 
+func (events *Events) processRollback(block umi.Block) {
+	for i := block.TransactionCount() - 1; i >= 0; i-- {
+		transaction := block.Transaction(i)
+		data, _ := json.MarshalIndent(transaction, "data: ", "  ")
+		buf := new(bytes.Buffer)
+		_, _ = fmt.Fprintf(buf, "event: rollback\ndata: %s\n\n", data)
+		data = buf.Bytes()
+
+		events.notify(transaction.Sender(), data)
+
+		if transaction.HasRecipient() {
+			events.notify(transaction.Recipient(), data)
+		}
+
+		if transaction.HasFee() {
+			events.notify(transaction.FeeAddress(), data)
+		}
+	}
+}
+
 func (events *Events) processTransaction(transaction *umi.Transaction) {
 	data, _ := json.MarshalIndent(transaction, "data: ", "  ")
 	buf := new(bytes.Buffer)
@@ -34,8 +34,12 @@ import (
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
+// maxBlockTransactions — максимальное количество транзакций в блоке, которое вмещает его заголовок.
+const maxBlockTransactions = 65535
+
 type iMempool interface {
 	Mempool() (txs []*umi.Transaction)
+	Parents(hash umi.Hash) (parents []umi.Hash)
 }
 
 type iNftMempool interface {
@@ -43,17 +47,22 @@ type iNftMempool interface {
 }
 
 type Generator struct {
-	confirmer  *ledger.ConfirmerLegacy
-	mempool    iMempool
-	nftMempool iNftMempool
-	nftStorage *nft.Storage
+	confirmer       *ledger.ConfirmerLegacy
+	mempool         iMempool
+	nftMempool      iNftMempool
+	nftStorage      *nft.Storage
+	maxTransactions int
+	maxNftBytes     int
+	excluded        map[umi.Hash]string
 }
 
 func NewGenerator(confirmer *ledger.ConfirmerLegacy, mempool iMempool, nftMempool iNftMempool) *Generator {
 	return &Generator{
-		confirmer:  confirmer,
-		mempool:    mempool,
-		nftMempool: nftMempool,
+		confirmer:       confirmer,
+		mempool:         mempool,
+		nftMempool:      nftMempool,
+		maxTransactions: maxBlockTransactions,
+		excluded:        make(map[umi.Hash]string),
 	}
 }
 
@@ -63,6 +72,19 @@ func (generator *Generator) SetNftStorage(nftStorage *nft.Storage) *Generator {
 	return generator
 }
 
+// SetLimits задает максимальное количество транзакций в блоке и суммарный размер NFT в байтах.
+// Нулевое значение снимает ограничение, но количество транзакций не может превышать 65535.
+func (generator *Generator) SetLimits(maxTransactions, maxNftBytes int) *Generator {
+	if maxTransactions <= 0 || maxTransactions > maxBlockTransactions {
+		maxTransactions = maxBlockTransactions
+	}
+
+	generator.maxTransactions = maxTransactions
+	generator.maxNftBytes = maxNftBytes
+
+	return generator
+}
+
 func (generator *Generator) Worker(ctx context.Context) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -77,9 +99,12 @@ func (generator *Generator) Worker(ctx context.Context) {
 	}
 }
 
+// generateBlock собирает блок из мемпула. Транзакции идут в порядке метки времени, nonce и хэша,
+// зависимые транзакции — после тех, от которых зависят. Транзакция, не прошедшая обработку,
+// исключается из блока, остальные включаются.
 func (generator *Generator) generateBlock() {
 	timestamp := uint32(time.Now().Unix())
-	transactions := generator.mempool.Mempool()
+	transactions := orderTransactions(generator.mempool.Mempool(), generator.mempool.Parents)
 	generator.confirmer.ResetState()
 	generator.confirmer.BlockTimestamp = timestamp
 
@@ -88,39 +113,36 @@ func (generator *Generator) generateBlock() {
 	block.SetPreviousBlockHash(generator.confirmer.PrevBlockHash)
 	block.SetTimestamp(timestamp)
 
-	txCount := 0
+	processors := map[string]func(umi.Transaction, uint32) (bool, error){
+		umi.TxSend:                generator.processSend,
+		umi.TxCreateStructure:     generator.processCreateStructure,
+		umi.TxUpdateStructure:     generator.processUpdateStructure,
+		umi.TxChangeProfitAddress: generator.processChangeProfitAddress,
+		umi.TxChangeFeeAddress:    generator.processChangeFeeAddress,
+		umi.TxActivateTransit:     generator.processActivateTransit,
+		umi.TxDeactivateTransit:   generator.processDeactivateTransit,
+		umi.TxBurn:                generator.processBurn,
+		umi.TxIssue:               generator.processIssue,
+		umi.TxMintNftWitness:      generator.processMintNftWitness,
+		umi.TxTransferNft:         generator.processTransferNft,
+	}
+
+	excluded := make(map[umi.Hash]string)
+	txCount, deferred := 0, 0
+
+	for i, transactionRaw := range transactions {
+		if txCount == generator.maxTransactions {
+			deferred += len(transactions) - i
 
-	for _, transactionRaw := range transactions {
-		if txCount == 65535 {
 			break
 		}
 
 		transaction := make(umi.Transaction, umi.TxConfirmedLength)
 		copy(transaction[:umi.TxLength], *transactionRaw)
 
-		processors := map[string]func(umi.Transaction, uint32) (bool, error){
-			umi.TxSend:                generator.processSend,
-			umi.TxCreateStructure:     generator.processCreateStructure,
-			umi.TxUpdateStructure:     generator.processUpdateStructure,
-			umi.TxChangeProfitAddress: generator.processChangeProfitAddress,
-			umi.TxChangeFeeAddress:    generator.processChangeFeeAddress,
-			umi.TxActivateTransit:     generator.processActivateTransit,
-			umi.TxDeactivateTransit:   generator.processDeactivateTransit,
-			umi.TxBurn:                generator.processBurn,
-			umi.TxIssue:               generator.processIssue,
-		}
+		if reason, ok := generator.include(processors, transaction, timestamp); !ok {
+			excluded[transaction.Hash()] = reason
 
-		processor, ok := processors[transaction.Type()]
-		if !ok {
-			continue
-		}
-
-		ok, err := processor(transaction, timestamp)
-		if err != nil {
-			return
-		}
-
-		if !ok {
 			continue
 		}
 
@@ -130,44 +152,43 @@ func (generator *Generator) generateBlock() {
 
 	// NFT
 	nftTokens := make([][]byte, 0)
+	nftBytes := 0
 
-	if txCount < 65535 {
-		nftTransactions := generator.nftMempool.Mempool()
+	for _, nftTransactionRaw := range orderNftTransactions(generator.nftMempool.Mempool()) {
+		if txCount == generator.maxTransactions ||
+			(generator.maxNftBytes > 0 && nftBytes+len(nftTransactionRaw) > generator.maxNftBytes) {
+			deferred++
 
-		for _, nftTransactionRaw := range nftTransactions {
-			if txCount == 65535 {
-				break
-			}
+			continue
+		}
 
-			transaction := make(nft.Transaction, len(nftTransactionRaw))
-			copy(transaction[:], nftTransactionRaw)
+		transaction := make(nft.Transaction, len(nftTransactionRaw))
+		copy(transaction[:], nftTransactionRaw)
 
-			txWitness := make(umi.Transaction, umi.TxConfirmedLength)
-			txWitness.SetVersion(umi.TxV18MintNftWitness)
-			txWitness.SetSender(transaction.Sender())
-			txWitness.SetHash(transaction.Hash())
-			txWitness.SetAmount(uint64(len(transaction)))
-			txWitness.SetTimestamp(transaction.Timestamp())
-			txWitness.SetNonce(transaction.Nonce())
-			copy(txWitness[86:150], ed25519.Sign(secKey(), txWitness[0:86]))
+		txWitness := make(umi.Transaction, umi.TxConfirmedLength)
+		txWitness.SetVersion(umi.TxV18MintNftWitness)
+		txWitness.SetSender(transaction.Sender())
+		txWitness.SetHash(transaction.Hash())
+		txWitness.SetAmount(uint64(len(transaction)))
+		txWitness.SetTimestamp(transaction.Timestamp())
+		txWitness.SetNonce(transaction.Nonce())
+		copy(txWitness[86:150], ed25519.Sign(secKey(), txWitness[0:86]))
 
-			ok, err := generator.processMintNftWitness(txWitness, timestamp)
-			if err != nil {
-				return
-			}
+		if reason, ok := generator.include(processors, txWitness, timestamp); !ok {
+			excluded[txWitness.Hash()] = reason
 
-			if !ok {
-				log.Println("not OK")
-				continue
-			}
+			continue
+		}
 
-			block = append(block, txWitness...)
-			txCount++
+		block = append(block, txWitness[:umi.TxLength]...)
+		txCount++
+		nftBytes += len(transaction)
 
-			nftTokens = append(nftTokens, transaction)
-		}
+		nftTokens = append(nftTokens, transaction)
 	}
 
+	generator.logExcluded(excluded)
+
 	if txCount == 0 {
 		return
 	}
@@ -178,17 +199,57 @@ func (generator *Generator) generateBlock() {
 
 	if err := generator.confirmer.AppendBlockLegacy(block); err != nil {
 		log.Printf("AppendBlockLegacy error: %v", err)
-	} else {
-		if len(nftTokens) > 0 {
-			for _, data := range nftTokens {
-				if err := generator.nftStorage.AppendData(data); err != nil {
-					log.Printf("AppendData error: %v", err)
-				}
-			}
+
+		return
+	}
+
+	log.Printf("блок %s: транзакций %d, NFT %d (%d байт), исключено %d, отложено %d",
+		block.Hash().String(), txCount-len(nftTokens), len(nftTokens), nftBytes, len(excluded), deferred)
+
+	for _, data := range nftTokens {
+		if err := generator.nftStorage.AppendData(data); err != nil {
+			log.Printf("AppendData error: %v", err)
 		}
 	}
 }
 
+// include обрабатывает транзакцию конфирмером. Если транзакция не прошла проверку или обработку,
+// все ее изменения отменяются и возвращается причина исключения из блока.
+func (generator *Generator) include(processors map[string]func(umi.Transaction, uint32) (bool, error),
+	transaction umi.Transaction, timestamp uint32) (reason string, ok bool) {
+	processor, ok := processors[transaction.Type()]
+	if !ok {
+		return fmt.Sprintf("тип %s не поддерживается", transaction.Type()), false
+	}
+
+	ok, err := generator.confirmer.Isolate(func() (bool, error) {
+		generator.confirmer.TransactionHeight++
+
+		return processor(transaction, timestamp)
+	})
+
+	switch {
+	case err != nil:
+		return err.Error(), false
+	case !ok:
+		return "не прошла проверку", false
+	}
+
+	return "", true
+}
+
+// logExcluded пишет в лог исключенные из блока транзакции. Транзакция, которая остается в мемпуле
+// и исключается по той же причине, повторно не логируется.
+func (generator *Generator) logExcluded(excluded map[umi.Hash]string) {
+	for hash, reason := range excluded {
+		if generator.excluded[hash] != reason {
+			log.Printf("транзакция %s исключена из блока: %s", hash.String(), reason)
+		}
+	}
+
+	generator.excluded = excluded
+}
+
 func signBlock(block umi.Block) {
 	secKey := secKey()
 	pubKey := secKey[ed25519.PublicKeySize:ed25519.PrivateKeySize]
@@ -197,6 +258,16 @@ func signBlock(block umi.Block) {
 	copy(block[103:167], ed25519.Sign(secKey, block[0:103]))
 }
 
+// PublicKey возвращает публичный ключ, которым генератор подписывает блоки.
+func PublicKey() ed25519.PublicKey {
+	secKey := secKey()
+	if len(secKey) != ed25519.PrivateKeySize {
+		return nil
+	}
+
+	return ed25519.PublicKey(secKey[ed25519.PublicKeySize:ed25519.PrivateKeySize])
+}
+
 func secKey() ed25519.PrivateKey {
 	secKey, _ := base64.StdEncoding.DecodeString(os.Getenv("UMI_MASTER_KEY"))
 
@@ -493,3 +564,13 @@ func (generator *Generator) processMintNftWitness(transaction umi.Transaction, _
 
 	return true, nil
 }
+
+// processTransferNft не дублирует проверки леджера: если NFT не существует, отправитель не владелец
+// или получателя нет, ошибка леджера исключает транзакцию из блока.
+func (generator *Generator) processTransferNft(transaction umi.Transaction, _ uint32) (bool, error) {
+	if _, err := generator.confirmer.ProcessTransferNftLegacy(transaction); err != nil {
+		return false, fmt.Errorf("%w", err)
+	}
+
+	return true, nil
+}
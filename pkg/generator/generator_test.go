@@ -0,0 +1,204 @@
+package generator
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+type mockMempool []*umi.Transaction
+
+func (mock mockMempool) Mempool() []*umi.Transaction {
+	return mock
+}
+
+func (mock mockMempool) Parents(_ umi.Hash) []umi.Hash {
+	return nil
+}
+
+type mockNftMempool struct{}
+
+func (mockNftMempool) Mempool() [][]byte {
+	return nil
+}
+
+// newTestGenerator создает генератор поверх леджера с GENESIS-блоком и возвращает адрес,
+// получивший все монеты.
+func newTestGenerator(t *testing.T) (*Generator, *ledger.Ledger, umi.Address) {
+	t.Helper()
+
+	// Блок подписывается ключом из окружения, ключ у всех тестов одинаковый.
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	_ = os.Setenv("UMI_MASTER_KEY", base64.StdEncoding.EncodeToString(key))
+
+	conf := config.DefaultConfig()
+	genesis := storage.GenesisBlock(conf.Network)
+
+	ledger1 := ledger.NewLedger(conf)
+	confirmer := ledger.NewConfirmerLegacy(ledger1)
+	confirmer.SetBlockchain(storage.NewBlockchainMemory(conf))
+
+	if err := confirmer.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	generator := NewGenerator(confirmer, mockMempool{}, mockNftMempool{})
+
+	return generator, ledger1, genesis.Transaction(0).Recipient()
+}
+
+func newTestSend(sender umi.Address, index byte, amount uint64, age uint32) *umi.Transaction {
+	recipient := sender
+	recipient[33] = index
+
+	transaction := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).SetRecipient(recipient).
+		SetAmount(amount).SetTimestamp(uint32(time.Now().Unix()) - age)
+
+	return &transaction
+}
+
+func TestGenerator_GenerateBlockIsolation(t *testing.T) {
+	t.Parallel()
+
+	generator, ledger1, sender := newTestGenerator(t)
+	account, _ := ledger1.Account(sender)
+	balance := account.Balance
+
+	send1 := newTestSend(sender, 1, 10, 30)
+	overspend := newTestSend(sender, 2, balance, 20)
+	send2 := newTestSend(sender, 3, 20, 10)
+
+	generator.mempool = mockMempool{send2, overspend, send1}
+	generator.generateBlock()
+
+	if ledger1.LastBlockHeight != 2 {
+		t.Fatalf("expected %d, got %d", 2, ledger1.LastBlockHeight)
+	}
+
+	// Транзакция, не прошедшая проверку, исключается, остальные попадают в блок.
+	if _, ok := generator.excluded[overspend.Hash()]; !ok || len(generator.excluded) != 1 {
+		t.Errorf("ожидаем исключение только %x, получили %v", overspend.Hash(), generator.excluded)
+	}
+
+	if account, _ := ledger1.Account(sender); account.Balance != balance-30 {
+		t.Errorf("expected %d, got %d", balance-30, account.Balance)
+	}
+
+	if _, ok := ledger1.Account(overspend.Recipient()); ok {
+		t.Error("получатель исключенной транзакции не должен появиться в леджере")
+	}
+
+	for _, transaction := range []*umi.Transaction{send1, send2} {
+		if !ledger1.HasTransaction(transaction.Hash()) {
+			t.Errorf("транзакция %x должна быть в блоке", transaction.Hash())
+		}
+	}
+}
+
+func TestGenerator_IncludeRollback(t *testing.T) {
+	t.Parallel()
+
+	generator, ledger1, sender := newTestGenerator(t)
+	account, _ := ledger1.Account(sender)
+	balance := account.Balance
+
+	transaction := make(umi.Transaction, umi.TxConfirmedLength)
+	copy(transaction, *newTestSend(sender, 1, 10, 0))
+
+	timestamp := uint32(time.Now().Unix())
+	confirmer := generator.confirmer
+
+	confirmer.ResetState()
+	confirmer.BlockTimestamp = timestamp
+
+	txHeight := confirmer.TransactionHeight
+	errTest := errors.New("test")
+
+	// Обработчик успевает применить перевод и только потом возвращает ошибку.
+	processors := map[string]func(umi.Transaction, uint32) (bool, error){
+		umi.TxSend: func(transaction umi.Transaction, _ uint32) (bool, error) {
+			if _, err := confirmer.ProcessSendLegacy(transaction); err != nil {
+				return false, err
+			}
+
+			return false, errTest
+		},
+	}
+
+	if reason, ok := generator.include(processors, transaction, timestamp); ok || reason != errTest.Error() {
+		t.Fatalf("ожидаем '%v', получили '%s'", errTest, reason)
+	}
+
+	if account, _ := confirmer.Account(sender); account.Balance != balance {
+		t.Errorf("expected %d, got %d", balance, account.Balance)
+	}
+
+	if account, _ := confirmer.Account(transaction.Recipient()); account.Balance != 0 {
+		t.Errorf("expected %d, got %d", 0, account.Balance)
+	}
+
+	if confirmer.TransactionHeight != txHeight {
+		t.Errorf("expected %d, got %d", txHeight, confirmer.TransactionHeight)
+	}
+
+	processors[umi.TxSend] = generator.processSend
+
+	if reason, ok := generator.include(processors, transaction, timestamp); !ok {
+		t.Fatalf("ожидаем 'nil', получили '%s'", reason)
+	}
+
+	if account, _ := confirmer.Account(sender); account.Balance != balance-10 {
+		t.Errorf("expected %d, got %d", balance-10, account.Balance)
+	}
+}
+
+func TestGenerator_GenerateBlockLimit(t *testing.T) {
+	t.Parallel()
+
+	generator, ledger1, sender := newTestGenerator(t)
+
+	older := newTestSend(sender, 1, 10, 30)
+	newer := newTestSend(sender, 2, 10, 10)
+
+	generator.mempool = mockMempool{newer, older}
+	generator.SetLimits(1, 0)
+	generator.generateBlock()
+
+	// В блок попадает транзакция, идущая первой по порядку, вторая откладывается.
+	if !ledger1.HasTransaction(older.Hash()) || ledger1.HasTransaction(newer.Hash()) {
+		t.Error("в блоке должна быть только более старая транзакция")
+	}
+
+	// Подтвержденная транзакция уходит из мемпула.
+	generator.mempool = mockMempool{newer}
+	generator.generateBlock()
+
+	if !ledger1.HasTransaction(newer.Hash()) {
+		t.Error("отложенная транзакция должна попасть в следующий блок")
+	}
+}
+
+func TestGenerator_SetLimits(t *testing.T) {
+	t.Parallel()
+
+	generator := NewGenerator(nil, mockMempool{}, mockNftMempool{})
+
+	for _, test := range []struct{ limit, expected int }{
+		{0, maxBlockTransactions},
+		{-1, maxBlockTransactions},
+		{100, 100},
+		{maxBlockTransactions + 1, maxBlockTransactions},
+	} {
+		if generator.SetLimits(test.limit, 0); generator.maxTransactions != test.expected {
+			t.Errorf("limit %d: expected %d, got %d", test.limit, test.expected, generator.maxTransactions)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package generator
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+
+	"gitlab.com/umitop/umid/pkg/nft"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// txKey — ключ порядка транзакций в блоке: метка времени, nonce, хэш.
+type txKey struct {
+	timestamp uint32
+	nonce     uint32
+	hash      umi.Hash
+}
+
+func (key txKey) less(other txKey) bool {
+	if key.timestamp != other.timestamp {
+		return key.timestamp < other.timestamp
+	}
+
+	if key.nonce != other.nonce {
+		return key.nonce < other.nonce
+	}
+
+	return bytes.Compare(key.hash[:], other.hash[:]) < 0
+}
+
+type txQueue struct {
+	items []int
+	keys  []txKey
+}
+
+func (queue *txQueue) Len() int {
+	return len(queue.items)
+}
+
+func (queue *txQueue) Less(i, j int) bool {
+	return queue.keys[queue.items[i]].less(queue.keys[queue.items[j]])
+}
+
+func (queue *txQueue) Swap(i, j int) {
+	queue.items[i], queue.items[j] = queue.items[j], queue.items[i]
+}
+
+func (queue *txQueue) Push(x interface{}) {
+	queue.items = append(queue.items, x.(int))
+}
+
+func (queue *txQueue) Pop() interface{} {
+	last := len(queue.items) - 1
+	item := queue.items[last]
+	queue.items = queue.items[:last]
+
+	return item
+}
+
+// orderTransactions упорядочивает транзакции по метке времени, nonce и хэшу. Транзакция, которая
+// зависит от других неподтвержденных транзакций, идет после них независимо от своего ключа.
+func orderTransactions(transactions []*umi.Transaction, parents func(umi.Hash) []umi.Hash) []*umi.Transaction {
+	keys := make([]txKey, len(transactions))
+	positions := make(map[umi.Hash]int, len(transactions))
+
+	for i, transaction := range transactions {
+		keys[i] = txKey{timestamp: transaction.Timestamp(), nonce: transaction.Nonce(), hash: transaction.Hash()}
+		positions[keys[i].hash] = i
+	}
+
+	waiting := make([]int, len(transactions))
+	children := make([][]int, len(transactions))
+
+	for i := range transactions {
+		for _, parent := range parents(keys[i].hash) {
+			if j, ok := positions[parent]; ok {
+				waiting[i]++
+				children[j] = append(children[j], i)
+			}
+		}
+	}
+
+	queue := &txQueue{keys: keys}
+
+	for i := range transactions {
+		if waiting[i] == 0 {
+			queue.items = append(queue.items, i)
+		}
+	}
+
+	heap.Init(queue)
+
+	ordered := make([]*umi.Transaction, 0, len(transactions))
+
+	for queue.Len() > 0 {
+		i := heap.Pop(queue).(int)
+		ordered = append(ordered, transactions[i])
+
+		for _, child := range children[i] {
+			waiting[child]--
+
+			if waiting[child] == 0 {
+				heap.Push(queue, child)
+			}
+		}
+	}
+
+	return ordered
+}
+
+// orderNftTransactions упорядочивает NFT по метке времени, nonce и хэшу.
+func orderNftTransactions(transactions [][]byte) [][]byte {
+	keys := make([]txKey, len(transactions))
+	items := make([]int, len(transactions))
+
+	for i, transaction := range transactions {
+		tx := (nft.Transaction)(transaction)
+		keys[i] = txKey{timestamp: tx.Timestamp(), nonce: tx.Nonce(), hash: tx.Hash()}
+		items[i] = i
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return keys[items[i]].less(keys[items[j]])
+	})
+
+	ordered := make([][]byte, 0, len(transactions))
+
+	for _, i := range items {
+		ordered = append(ordered, transactions[i])
+	}
+
+	return ordered
+}
@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/nft"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestOrderTransactions(t *testing.T) {
+	t.Parallel()
+
+	parent := umi.NewTransaction().SetVersion(umi.TxV8Send).SetTimestamp(30)
+	child := umi.NewTransaction().SetVersion(umi.TxV8Send).SetTimestamp(10)
+	first := umi.NewTransaction().SetVersion(umi.TxV8Send).SetTimestamp(20)
+	second := umi.NewTransaction().SetVersion(umi.TxV8Send).SetTimestamp(20).SetNonce(1)
+
+	// Дочерняя транзакция старше родительской, но идет после нее.
+	parents := map[umi.Hash][]umi.Hash{child.Hash(): {parent.Hash()}}
+
+	ordered := orderTransactions([]*umi.Transaction{&child, &second, &parent, &first}, func(hash umi.Hash) []umi.Hash {
+		return parents[hash]
+	})
+
+	expected := []umi.Transaction{first, second, parent, child}
+
+	if len(ordered) != len(expected) {
+		t.Fatalf("expected %d, got %d", len(expected), len(ordered))
+	}
+
+	for i, transaction := range expected {
+		if ordered[i].Hash() != transaction.Hash() {
+			t.Errorf("позиция %d: ожидаем метку времени %d, получили %d", i, transaction.Timestamp(), ordered[i].Timestamp())
+		}
+	}
+}
+
+func TestOrderTransactions_SameKey(t *testing.T) {
+	t.Parallel()
+
+	tx1 := umi.NewTransaction().SetVersion(umi.TxV8Send).SetTimestamp(10).SetAmount(1)
+	tx2 := umi.NewTransaction().SetVersion(umi.TxV8Send).SetTimestamp(10).SetAmount(2)
+
+	noParents := func(umi.Hash) []umi.Hash { return nil }
+
+	// При равных метке времени и nonce порядок определяет хэш и не зависит от порядка в мемпуле.
+	ordered1 := orderTransactions([]*umi.Transaction{&tx1, &tx2}, noParents)
+	ordered2 := orderTransactions([]*umi.Transaction{&tx2, &tx1}, noParents)
+
+	for i := range ordered1 {
+		if ordered1[i].Hash() != ordered2[i].Hash() {
+			t.Errorf("позиция %d: порядок зависит от мемпула", i)
+		}
+	}
+}
+
+func TestOrderNftTransactions(t *testing.T) {
+	t.Parallel()
+
+	newNft := func(timestamp, nonce uint32) []byte {
+		tx := nft.NewTransaction()
+		tx.SetTimestamp(timestamp)
+		tx.SetNonce(nonce)
+
+		return *tx
+	}
+
+	late := newNft(20, 0)
+	early := newNft(10, 5)
+	earlyNext := newNft(10, 6)
+
+	ordered := orderNftTransactions([][]byte{late, earlyNext, early})
+
+	for i, expected := range [][]byte{early, earlyNext, late} {
+		if string(ordered[i]) != string(expected) {
+			t.Errorf("позиция %d: неверный порядок", i)
+		}
+	}
+}
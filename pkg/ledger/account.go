@@ -86,8 +86,11 @@ func (account *Account) SetType(accountType umi.AccountType) {
 func (account *Account) SetInterestRate(interest uint16, timestamp uint32) {
 	account.UpdateBalance(timestamp)
 	account.InterestRate = interest
+	account.updateGrowthRate()
+}
 
-	r := float64(1) + (float64(interest) / float64(100_00))
+func (account *Account) updateGrowthRate() {
+	r := float64(1) + (float64(account.InterestRate) / float64(100_00))
 	n := float64(1) / float64(2592000)
 
 	account.growthRate = math.Pow(r, n)
@@ -0,0 +1,68 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+var ErrCheckpoint = errors.New("checkpoint")
+
+// SetCheckpoints задает контрольные точки — высоты блоков и их хэши. Цепочка, в которой блок
+// на высоте контрольной точки имеет другой хэш, не принимается.
+func (ledger *Ledger) SetCheckpoints(checkpoints map[uint32]umi.Hash) {
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	ledger.checkpoints = checkpoints
+}
+
+// LastCheckpoint возвращает последнюю пройденную контрольную точку.
+func (ledger *Ledger) LastCheckpoint() (height uint32, hash umi.Hash, ok bool) {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	return ledger.lastCheckpoint()
+}
+
+func (ledger *Ledger) lastCheckpoint() (height uint32, hash umi.Hash, ok bool) {
+	for h, cpHash := range ledger.checkpoints {
+		if h <= ledger.LastBlockHeight && h >= height {
+			height, hash, ok = h, cpHash, true
+		}
+	}
+
+	return height, hash, ok
+}
+
+func (ledger *Ledger) checkCheckpoint(height uint32, hash umi.Hash) error {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	if cpHash, ok := ledger.checkpoints[height]; ok && cpHash != hash {
+		return fmt.Errorf("%w: блок %d (%s) не совпадает с контрольной точкой (%s)", ErrCheckpoint, height, hash, cpHash)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestLedger_Checkpoints(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit := newTestAddress(umi.PfxVerRoy, 11)
+
+	block := newTestBlock(ledger, firstJun2020+20, newTestTransaction(umi.TxV8Send, master, deposit, 1_000_00, 2))
+
+	ledger.SetCheckpoints(map[uint32]umi.Hash{3: {0xFF}})
+
+	if err := NewConfirmer(ledger).ProcessBlock(block); !errors.Is(err, ErrCheckpoint) {
+		t.Errorf("ожидаем '%v', получили '%v'", ErrCheckpoint, err)
+	}
+
+	if _, err := NewConfirmerLegacy(ledger).ProcessBlockLegacy(block.Legacy()); !errors.Is(err, ErrCheckpoint) {
+		t.Errorf("ожидаем '%v', получили '%v'", ErrCheckpoint, err)
+	}
+
+	ledger.SetCheckpoints(map[uint32]umi.Hash{3: block.Hash()})
+	commitTestBlock(t, ledger, firstJun2020+20, newTestTransaction(umi.TxV8Send, master, deposit, 1_000_00, 2))
+
+	if height, hash, ok := ledger.LastCheckpoint(); !ok || height != 3 || hash != block.Hash() {
+		t.Errorf("ожидаем контрольную точку 3, получили %d", height)
+	}
+
+	if err := ledger.Rewind(2); !errors.Is(err, ErrCheckpoint) {
+		t.Errorf("откат ниже контрольной точки, ожидаем '%v', получили '%v'", ErrCheckpoint, err)
+	}
+}
@@ -23,7 +23,7 @@ package ledger
 import (
 	"errors"
 	"fmt"
-	"math"
+	"log"
 	"sync"
 
 	"gitlab.com/umitop/umid/pkg/umi"
@@ -43,6 +43,7 @@ var (
 
 type iBlockchain interface {
 	AppendBlock(umi.Block) error
+	Truncate(uint32) error
 }
 
 type Confirmer struct {
@@ -54,8 +55,18 @@ type Confirmer struct {
 	accounts   map[umi.Address]*Account
 	structures map[umi.Prefix]*Structure
 	nfts       map[umi.Hash]umi.Address
+	nftHeights map[uint64]umi.Hash
 	txHashes   []umi.Hash
 
+	// Изменение суммы собственных балансов по структурам и ожидаемое изменение общего
+	// количества монет в обрабатываемом блоке. Используются при проверке инвариантов.
+	supply   map[umi.Prefix]int64
+	emission int64
+	// Выпущенные и сожженные в обрабатываемом блоке монеты по структурам.
+	totals map[umi.Prefix]supplyTotals
+	// Записи до изменения текущей транзакцией, если ее можно отменить (см. isolate).
+	undo *undoLog
+
 	// Абсолютная высота транзакции в блокчейне. Удобно использовать для синхронизации.
 	TransactionHeight uint64
 	// Время создания блока. По этой временной метке считаются балансы.
@@ -91,17 +102,52 @@ func (confirmer *Confirmer) AppendBlock(block []byte) error {
 	}
 
 	if err := confirmer.Commit(); err != nil {
+		// Блок уже записан, но леджер его не принял, поэтому блок удаляется из блокчейна.
+		if errTruncate := confirmer.blockchain.Truncate(confirmer.BlockHeight - 1); errTruncate != nil {
+			log.Printf("ledger: не удалось удалить блок %d: %v", confirmer.BlockHeight, errTruncate)
+		}
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// Rollback откатывает леджер и блокчейн до указанной высоты блока.
+func (confirmer *Confirmer) Rollback(height uint32) error {
+	confirmer.Lock()
+	defer confirmer.Unlock()
+
+	if err := confirmer.ledger.Rewind(height); err != nil {
+		return err
+	}
+
+	if err := confirmer.blockchain.Truncate(height); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
+	confirmer.ResetState()
+
 	return nil
 }
 
+// LastBlock возвращает высоту и хэш последнего блока, зафиксированного в леджере.
+func (confirmer *Confirmer) LastBlock() (height uint32, hash umi.Hash) {
+	confirmer.ledger.RLock()
+	defer confirmer.ledger.RUnlock()
+
+	return confirmer.ledger.LastBlockHeight, confirmer.ledger.LastBlockHash
+}
+
 func (confirmer *Confirmer) ResetState() {
 	confirmer.accounts = make(map[umi.Address]*Account)
 	confirmer.structures = make(map[umi.Prefix]*Structure)
 	confirmer.nfts = make(map[umi.Hash]umi.Address)
+	confirmer.nftHeights = make(map[uint64]umi.Hash)
 	confirmer.txHashes = make([]umi.Hash, 0)
+	confirmer.supply = make(map[umi.Prefix]int64)
+	confirmer.emission = 0
+	confirmer.totals = make(map[umi.Prefix]supplyTotals)
 
 	confirmer.ledger.RLock()
 	confirmer.PrevBlockHash = confirmer.ledger.LastBlockHash
@@ -125,6 +171,11 @@ func (confirmer *Confirmer) ProcessBlock(blockRaw []byte) error {
 	confirmer.BlockTimestamp = block.Timestamp()
 	confirmer.BlockHeight++
 
+	// Проверяется при любом способе добавления блока: синхронизация, генерация, импорт и чтение с диска.
+	if err := confirmer.ledger.checkCheckpoint(confirmer.BlockHeight, confirmer.BlockHash); err != nil {
+		return err
+	}
+
 	handlers := map[string]func(umi.Transaction) error{
 		umi.TxGenesis:             confirmer.processGenesis,
 		umi.TxSend:                confirmer.processSend,
@@ -137,6 +188,7 @@ func (confirmer *Confirmer) ProcessBlock(blockRaw []byte) error {
 		umi.TxBurn:                confirmer.processBurn,
 		umi.TxIssue:               confirmer.processIssue,
 		umi.TxMintNftWitness:      confirmer.processMintNftWitness,
+		umi.TxTransferNft:         confirmer.processTransferNft,
 	}
 
 	for txIndex, txCount := 0, block.TransactionCount(); txIndex < txCount; txIndex++ {
@@ -152,11 +204,13 @@ func (confirmer *Confirmer) ProcessBlock(blockRaw []byte) error {
 			return errUnsupportedTxType
 		}
 
+		confirmer.TransactionHeight++
+
 		if err := handler(transaction); err != nil {
 			return err
 		}
 
-		confirmer.TransactionHeight++
+		confirmer.emission += txEmission(transaction)
 		confirmer.txHashes = append(confirmer.txHashes, hash)
 	}
 
@@ -164,6 +218,9 @@ func (confirmer *Confirmer) ProcessBlock(blockRaw []byte) error {
 }
 
 func (confirmer *Confirmer) Account(address umi.Address) (account *Account, ok bool) {
+	// Вызывающий код изменяет аккаунт по указателю.
+	confirmer.saveAccount(address)
+
 	if account, ok = confirmer.accounts[address]; ok {
 		return account, true
 	}
@@ -195,6 +252,8 @@ func (confirmer *Confirmer) Account(address umi.Address) (account *Account, ok b
 }
 
 func (confirmer *Confirmer) Structure(prefix umi.Prefix) (structure *Structure, ok bool) {
+	confirmer.saveStructure(prefix)
+
 	if structure, ok = confirmer.structures[prefix]; ok {
 		return structure, true
 	}
@@ -304,6 +363,7 @@ func (confirmer *Confirmer) processCreateStructure(transaction umi.Transaction)
 	structure.ProfitPercent = transaction.ProfitPercent()
 	structure.FeePercent = transaction.FeePercent()
 
+	confirmer.saveStructure(prefix)
 	confirmer.structures[prefix] = structure
 
 	profitAccount, _ := confirmer.Account(structure.ProfitAddress)
@@ -457,7 +517,17 @@ func (confirmer *Confirmer) processBurn(transaction umi.Transaction) error {
 
 	// Уменьшаем баланс отправителя и увеличиваем его счетчик транзакций.
 	// Возвращаем ошибку в случае, если аккаунт не существует или баланс меньше чем сумма транзакции.
-	return confirmer.decreaseAccountBalance(sender, amount)
+	if err := confirmer.decreaseAccountBalance(sender, amount); err != nil {
+		return err
+	}
+
+	confirmer.saveTotals(sender.Prefix())
+
+	totals := confirmer.totals[sender.Prefix()]
+	totals.burned += amount
+	confirmer.totals[sender.Prefix()] = totals
+
+	return nil
 }
 
 func (confirmer *Confirmer) processIssue(transaction umi.Transaction) error {
@@ -475,20 +545,96 @@ func (confirmer *Confirmer) processIssue(transaction umi.Transaction) error {
 		return fmt.Errorf("%s %s: %w", sender.String(), prefix.String(), errInsufficientPrivileges)
 	}
 
-	return confirmer.increaseAccountBalance(recipient, amount)
+	if err := confirmer.increaseAccountBalance(recipient, amount); err != nil {
+		return err
+	}
+
+	confirmer.saveTotals(prefix)
+
+	totals := confirmer.totals[prefix]
+	totals.issued += amount
+	confirmer.totals[prefix] = totals
+
+	return nil
 }
 
 func (confirmer *Confirmer) processMintNftWitness(transaction umi.Transaction) error {
 	sender := transaction.Sender()
 	amount := transaction.Amount()
 
+	confirmer.saveNft(transaction.Hash())
+	confirmer.saveNftHeight(confirmer.TransactionHeight)
+
 	confirmer.nfts[transaction.Hash()] = transaction.Sender()
+	// По высоте транзакции выпуска на NFT ссылаются транзакции передачи.
+	confirmer.nftHeights[confirmer.TransactionHeight] = transaction.Hash()
 
 	// Уменьшаем баланс отправителя и увеличиваем его счетчик транзакций.
 	// Возвращаем ошибку в случае, если аккаунт не существует или баланс меньше чем сумма транзакции.
 	return confirmer.decreaseAccountBalance(sender, amount)
 }
 
+func (confirmer *Confirmer) processTransferNft(transaction umi.Transaction) error {
+	sender := transaction.Sender()
+	recipient := transaction.Recipient()
+
+	hash, ok := confirmer.NftByHeight(transaction.NftHeight())
+	if !ok {
+		return fmt.Errorf("nft %d: %w", transaction.NftHeight(), errNotFound)
+	}
+
+	if owner, _ := confirmer.NftOwner(hash); owner != sender {
+		return fmt.Errorf("%s nft %s: %w", sender.String(), hash.String(), errInsufficientPrivileges)
+	}
+
+	senderAccount, ok := confirmer.Account(sender)
+	if !ok {
+		return fmt.Errorf("account %s: %w", sender.String(), errNotFound)
+	}
+
+	recipientAccount, ok := confirmer.Account(recipient)
+	if !ok {
+		return fmt.Errorf("account %s: %w", recipient.String(), errNotFound)
+	}
+
+	// Монеты не переводятся, меняется только владелец NFT.
+	senderAccount.TransactionCount++
+	recipientAccount.TransactionCount++
+
+	confirmer.saveNft(hash)
+	confirmer.nfts[hash] = recipient
+
+	return nil
+}
+
+// NftByHeight возвращает хеш NFT, выпущенного транзакцией с указанной высотой.
+func (confirmer *Confirmer) NftByHeight(height uint64) (hash umi.Hash, ok bool) {
+	if hash, ok = confirmer.nftHeights[height]; ok {
+		return hash, ok
+	}
+
+	confirmer.ledger.RLock()
+	defer confirmer.ledger.RUnlock()
+
+	hash, ok = confirmer.ledger.nftHeights[height]
+
+	return hash, ok
+}
+
+// NftOwner возвращает текущего владельца NFT с учетом изменений в обрабатываемом блоке.
+func (confirmer *Confirmer) NftOwner(hash umi.Hash) (owner umi.Address, ok bool) {
+	if owner, ok = confirmer.nfts[hash]; ok {
+		return owner, ok
+	}
+
+	confirmer.ledger.RLock()
+	defer confirmer.ledger.RUnlock()
+
+	owner, ok = confirmer.ledger.nfts[hash]
+
+	return owner, ok
+}
+
 // increaseAccountBalance увеличивает баланс счета, связанного с адресом на указанную сумму
 // и увеличивает счетчик транзакций. Перед операцией баланс аккаунта считается по временной
 // метке обрабатываемого блока.
@@ -503,6 +649,9 @@ func (confirmer *Confirmer) increaseAccountBalance(address umi.Address, amount u
 	account.TransactionCount++
 	account.IncreaseBalance(amount, timestamp)
 
+	confirmer.saveSupply(address.Prefix())
+	confirmer.supply[address.Prefix()] += int64(amount)
+
 	switch account.Type {
 	case umi.Umi:
 		structure, _ := confirmer.Structure(address.Prefix())
@@ -547,6 +696,9 @@ func (confirmer *Confirmer) decreaseAccountBalance(address umi.Address, amount u
 		return fmt.Errorf("%s: %w", address.String(), errInsufficientFunds)
 	}
 
+	confirmer.saveSupply(address.Prefix())
+	confirmer.supply[address.Prefix()] -= int64(amount)
+
 	switch account.Type {
 	case umi.Umi:
 		structure, _ := confirmer.Structure(address.Prefix())
@@ -739,35 +891,18 @@ func (confirmer *Confirmer) calculateFee(
 	sender, recipient umi.Address, amount uint64) (feeAmount uint64, feeAddress umi.Address, ok bool) {
 	senderAccount, _ := confirmer.Account(sender)
 
-	// При переводах внутри структуры привилегированные адреса не платят комиссию.
-	switch senderAccount.Type {
-	case umi.Fee, umi.Profit, umi.Transit, umi.Dev:
-		return feeAmount, feeAddress, false
-	}
-
+	// Несуществующий аккаунт получателя создается с типом по умолчанию его структуры.
 	recipientAccount, ok := confirmer.Account(recipient)
 	if !ok {
 		return feeAmount, feeAddress, false
 	}
 
-	// Все остальные типы адресов платят только при переводах на депозиты.
-	if recipientAccount.Type != umi.Deposit {
-		return feeAmount, feeAddress, false
-	}
-
 	structure, ok := confirmer.Structure(recipient.Prefix())
 	if !ok {
 		return feeAmount, feeAddress, false
 	}
 
-	if structure.FeePercent == 0 {
-		return feeAmount, feeAddress, false
-	}
-
-	feeAmount = uint64(math.Ceil(float64(amount) * float64(structure.FeePercent) / float64(100_00)))
-	feeAddress = structure.FeeAddress
-
-	return feeAmount, feeAddress, true
+	return CalculateFee(senderAccount.Type, recipientAccount.Type, structure, amount)
 }
 
 func (confirmer *Confirmer) Commit() error {
@@ -778,10 +913,15 @@ func (confirmer *Confirmer) Commit() error {
 		return fmt.Errorf("%w: !! новый блок не ссылается на последний блок", errBlock)
 	}
 
+	// Запоминаем состояние до изменений, чтобы блок можно было откатить.
+	confirmer.ledger.beginJournal()
+
 	// Фиксируем изменения в аккаунтах
 	for address, account := range confirmer.accounts {
 		prefix := address.Prefix()
 
+		confirmer.ledger.saveAccount(address)
+
 		accounts, ok := confirmer.ledger.accounts[prefix]
 		if !ok {
 			accounts = make(map[umi.Address]*Account)
@@ -795,6 +935,8 @@ func (confirmer *Confirmer) Commit() error {
 	for prefix, structure := range confirmer.structures {
 		old, ok := confirmer.ledger.structures[prefix]
 
+		confirmer.ledger.saveStructure(prefix)
+
 		structure.AddressCount = len(confirmer.ledger.accounts[prefix])
 
 		confirmer.ledger.structures[prefix] = structure
@@ -806,14 +948,29 @@ func (confirmer *Confirmer) Commit() error {
 
 	// Фиксируем передачу прав на NFT
 	for hash, addr := range confirmer.nfts {
-		confirmer.ledger.nfts[hash] = addr
+		confirmer.ledger.saveNft(hash)
+		confirmer.ledger.setNftOwner(hash, addr)
 	}
 
+	// Запоминаем высоты транзакций выпуска NFT
+	nftHeights := make([]uint64, 0, len(confirmer.nftHeights))
+
+	for height, hash := range confirmer.nftHeights {
+		confirmer.ledger.nftHeights[height] = hash
+		nftHeights = append(nftHeights, height)
+	}
+
+	confirmer.ledger.journal.nftHeights = nftHeights
+
 	// Добавляем хеши транзакций
 	for _, hash := range confirmer.txHashes {
 		confirmer.ledger.transactions[hash] = struct{}{}
 	}
 
+	confirmer.ledger.journal.txHashes = confirmer.txHashes
+
+	confirmer.ledger.addTotals(confirmer.totals)
+
 	confirmer.ledger.LastBlockTimestamp = confirmer.BlockTimestamp
 	confirmer.ledger.LastBlockHeight = confirmer.BlockHeight
 	confirmer.ledger.LastBlockHash = confirmer.BlockHash
@@ -821,6 +978,21 @@ func (confirmer *Confirmer) Commit() error {
 
 	confirmer.checkStaking()
 
+	if confirmer.ledger.config.CheckInvariants {
+		if err := confirmer.checkInvariants(); err != nil {
+			confirmer.ledger.discardJournal()
+
+			return err
+		}
+	}
+
+	root := confirmer.ledger.updateStateTree(confirmer.ledger.journal)
+	confirmer.ledger.updateRichList(confirmer.ledger.journal)
+	confirmer.ledger.writeStateRoot(confirmer.ledger.LastBlockHeight, root)
+	confirmer.ledger.writeSupply(confirmer.ledger.journal)
+
+	confirmer.ledger.commitJournal()
+
 	return nil
 }
 
@@ -63,24 +63,16 @@ func (confirmer *ConfirmerLegacy) ProcessBlockLegacy(blockLegacyRaw []byte) (umi
 	confirmer.BlockTimestamp = blockLegacy.Timestamp()
 	confirmer.BlockHeight++
 
+	if err := confirmer.ledger.checkCheckpoint(confirmer.BlockHeight, confirmer.BlockHash); err != nil {
+		return nil, err
+	}
+
 	block := make(umi.Block, umi.HdrLength)
 
 	// Copy block header.
 	copy(block, blockLegacy[:umi.HdrLength])
 
-	handlers := map[string]func(umi.Transaction) (umi.Transaction, error){
-		umi.TxGenesis:             confirmer.processGenesisLegacy,
-		umi.TxSend:                confirmer.ProcessSendLegacy,
-		umi.TxCreateStructure:     confirmer.ProcessCreateStructureLegacy,
-		umi.TxUpdateStructure:     confirmer.ProcessUpdateStructureLegacy,
-		umi.TxChangeProfitAddress: confirmer.ProcessChangeProfitAddressLegacy,
-		umi.TxChangeFeeAddress:    confirmer.ProcessChangeFeeAddressLegacy,
-		umi.TxActivateTransit:     confirmer.ProcessActivateTransitLegacy,
-		umi.TxDeactivateTransit:   confirmer.ProcessDeactivateTransitLegacy,
-		umi.TxBurn:                confirmer.ProcessBurnLegacy,
-		umi.TxIssue:               confirmer.ProcessIssueLegacy,
-		umi.TxMintNftWitness:      confirmer.ProcessMintNftWitnessLegacy,
-	}
+	handlers := confirmer.legacyHandlers()
 
 	for txIndex, txCount := 0, block.TransactionCount(); txIndex < txCount; txIndex++ {
 		confirmer.TransactionHeight++
@@ -102,12 +94,34 @@ func (confirmer *ConfirmerLegacy) ProcessBlockLegacy(blockLegacyRaw []byte) (umi
 			return nil, err
 		}
 
+		confirmer.emission += txEmission(transaction)
+		// Хэши нужны, чтобы обработанный блок можно было зафиксировать без повторной обработки.
+		confirmer.txHashes = append(confirmer.txHashes, transaction.Hash())
+
 		block = append(block, transaction...)
 	}
 
 	return block, nil
 }
 
+// legacyHandlers возвращает обработчики транзакций, которые заполняют мета-данные подтвержденной транзакции.
+func (confirmer *ConfirmerLegacy) legacyHandlers() map[string]func(umi.Transaction) (umi.Transaction, error) {
+	return map[string]func(umi.Transaction) (umi.Transaction, error){
+		umi.TxGenesis:             confirmer.processGenesisLegacy,
+		umi.TxSend:                confirmer.ProcessSendLegacy,
+		umi.TxCreateStructure:     confirmer.ProcessCreateStructureLegacy,
+		umi.TxUpdateStructure:     confirmer.ProcessUpdateStructureLegacy,
+		umi.TxChangeProfitAddress: confirmer.ProcessChangeProfitAddressLegacy,
+		umi.TxChangeFeeAddress:    confirmer.ProcessChangeFeeAddressLegacy,
+		umi.TxActivateTransit:     confirmer.ProcessActivateTransitLegacy,
+		umi.TxDeactivateTransit:   confirmer.ProcessDeactivateTransitLegacy,
+		umi.TxBurn:                confirmer.ProcessBurnLegacy,
+		umi.TxIssue:               confirmer.ProcessIssueLegacy,
+		umi.TxMintNftWitness:      confirmer.ProcessMintNftWitnessLegacy,
+		umi.TxTransferNft:         confirmer.ProcessTransferNftLegacy,
+	}
+}
+
 func (confirmer *ConfirmerLegacy) processGenesisLegacy(transaction umi.Transaction) (umi.Transaction, error) {
 	if err := confirmer.processGenesis(transaction); err != nil {
 		return nil, err
@@ -232,6 +246,20 @@ func (confirmer *ConfirmerLegacy) ProcessMintNftWitnessLegacy(transaction umi.Tr
 	return transaction, nil
 }
 
+func (confirmer *ConfirmerLegacy) ProcessTransferNftLegacy(transaction umi.Transaction) (umi.Transaction, error) {
+	if err := confirmer.processTransferNft(transaction); err != nil {
+		return nil, err
+	}
+
+	hash, _ := confirmer.NftByHeight(transaction.NftHeight())
+
+	confirmer.setTxSender(transaction, transaction.Sender())
+	confirmer.setTxRecipient(transaction, transaction.Recipient())
+	transaction.SetNftHash(hash)
+
+	return transaction, nil
+}
+
 // setTxRecipient добавляет в подтвержденную транзакцию мета-данные отправителя.
 func (confirmer *Confirmer) setTxSender(transaction umi.Transaction, sender umi.Address) {
 	senderAccount, _ := confirmer.Account(sender)
@@ -0,0 +1,53 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"math"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// CalculateFee рассчитывает комиссию структуры получателя за перевод. Для аккаунта, которого
+// еще нет в леджере, передается тип по умолчанию его структуры (Structure.AccountType).
+// Правило общее для конфирмера и мемпула.
+func CalculateFee(senderType, recipientType umi.AccountType, structure *Structure,
+	amount uint64) (feeAmount uint64, feeAddress umi.Address, ok bool) {
+	// При переводах внутри структуры привилегированные адреса не платят комиссию.
+	switch senderType {
+	case umi.Fee, umi.Profit, umi.Transit, umi.Dev:
+		return feeAmount, feeAddress, false
+	}
+
+	// Все остальные типы адресов платят только при переводах на депозиты.
+	if recipientType != umi.Deposit {
+		return feeAmount, feeAddress, false
+	}
+
+	if structure.FeePercent == 0 {
+		return feeAmount, feeAddress, false
+	}
+
+	feeAmount = uint64(math.Ceil(float64(amount) * float64(structure.FeePercent) / float64(100_00)))
+	feeAddress = structure.FeeAddress
+
+	return feeAmount, feeAddress, true
+}
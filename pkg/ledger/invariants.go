@@ -0,0 +1,379 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// invariantPrecision — допустимое относительное расхождение сумм балансов (1/1_000_000).
+// Баланс структуры и балансы аккаунтов капитализируются независимо и округляются вниз,
+// поэтому при ненулевой ставке суммы могут расходиться на величину накопленного округления.
+const invariantPrecision = 1_000_000
+
+var ErrInvariant = errors.New("invariant")
+
+// CheckInvariants проверяет согласованность балансов структур и аккаунтов на последнем блоке
+// и возвращает список найденных нарушений.
+func (ledger *Ledger) CheckInvariants() []error {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	prefixes := make([]umi.Prefix, 0, len(ledger.structures))
+
+	for prefix := range ledger.structures {
+		prefixes = append(prefixes, prefix)
+	}
+
+	for prefix := range ledger.accounts {
+		if _, ok := ledger.structures[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return ledger.checkStructures(prefixes)
+}
+
+// checkInvariants проверяет структуры, измененные в зафиксированном блоке, и сохранение
+// количества монет. Все нарушения пишутся в лог, возвращается первое из них.
+// Вызывается только под блокировкой леджера, до commitJournal.
+func (confirmer *Confirmer) checkInvariants() error {
+	ledger := confirmer.ledger
+	changes := ledger.journal
+
+	violations := ledger.checkSupply(changes, confirmer.supply, confirmer.emission)
+	violations = append(violations, ledger.checkStructures(changedPrefixes(changes))...)
+
+	for _, err := range violations {
+		log.Printf("%v", err)
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w (всего нарушений: %d)", violations[0], len(violations))
+	}
+
+	return nil
+}
+
+// checkStructures проверяет балансы структур с указанными префиксами. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) checkStructures(prefixes []umi.Prefix) (violations []error) {
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i] < prefixes[j] })
+
+	for _, prefix := range prefixes {
+		violations = append(violations, ledger.checkStructure(prefix)...)
+	}
+
+	return violations
+}
+
+//nolint:funlen,gocognit // ...
+//revive:disable:function-length,cognitive-complexity
+func (ledger *Ledger) checkStructure(prefix umi.Prefix) (violations []error) {
+	height := ledger.LastBlockHeight
+	timestamp := ledger.LastBlockTimestamp
+
+	violation := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		violations = append(violations, fmt.Errorf("%w: блок %d, структура %s: %s", ErrInvariant, height, prefix.String(), msg))
+	}
+
+	structure, ok := ledger.structures[prefix]
+	if !ok {
+		violation("существует %d аккаунтов, но структура не найдена", len(ledger.accounts[prefix]))
+
+		return violations
+	}
+
+	accounts := ledger.accounts[prefix]
+
+	if structure.AddressCount != len(accounts) {
+		violation("addressCount %d, аккаунтов %d", structure.AddressCount, len(accounts))
+	}
+
+	var (
+		deposits uint64
+		count    uint64
+	)
+
+	for address, account := range accounts {
+		switch account.Type {
+		case umi.Umi, umi.Deposit, umi.Transit:
+			deposits += account.BalanceAt(timestamp)
+			count++
+
+		case umi.Profit:
+			if address != structure.ProfitAddress {
+				violation("аккаунт %s имеет тип profit, но не является profit-адресом", address.String())
+			}
+
+		case umi.Fee:
+			if address != structure.FeeAddress {
+				violation("аккаунт %s имеет тип fee, но не является fee-адресом", address.String())
+			}
+
+		case umi.Dev:
+			if address != structure.DevAddress {
+				violation("аккаунт %s имеет тип dev, но не является dev-адресом", address.String())
+			}
+		}
+	}
+
+	structureBalance := structure.BalanceAt(timestamp)
+
+	// Структура UMI не начисляет проценты, поэтому ее баланс должен совпадать точно.
+	tolerance := uint64(0)
+	if prefix != umi.PfxVerUmi {
+		tolerance = count + structureBalance/invariantPrecision
+	}
+
+	if diff(structureBalance, deposits) > tolerance {
+		violation("баланс структуры %d, сумма балансов аккаунтов %d", structureBalance, deposits)
+	}
+
+	if prefix == umi.PfxVerUmi {
+		return violations
+	}
+
+	profitAccount, ok := ledger.journalAccount(nil, structure.ProfitAddress)
+	if !ok || profitAccount.Type != umi.Profit {
+		violation("profit-адрес %s не имеет тип profit", structure.ProfitAddress.String())
+
+		return violations
+	}
+
+	if structure.FeeAddress != structure.ProfitAddress {
+		if feeAccount, ok := ledger.journalAccount(nil, structure.FeeAddress); !ok || feeAccount.Type != umi.Fee {
+			violation("fee-адрес %s не имеет тип fee", structure.FeeAddress.String())
+		}
+	}
+
+	devAccount, ok := ledger.journalAccount(nil, structure.DevAddress)
+	if !ok || devAccount.Type != umi.Dev {
+		violation("dev-адрес %s не имеет тип dev", structure.DevAddress.String())
+
+		return violations
+	}
+
+	// Баланс Profit включает в себя баланс структуры, а баланс Dev — баланс Profit.
+	profitBalance := profitAccount.BalanceAt(timestamp)
+	if profitBalance+tolerance < structureBalance {
+		violation("баланс profit %d меньше баланса структуры %d", profitBalance, structureBalance)
+	}
+
+	// Profit и Dev капитализируются по разным ставкам, поэтому допуск считаем от баланса Profit.
+	devBalance := devAccount.BalanceAt(timestamp)
+	if devBalance+tolerance+profitBalance/invariantPrecision < profitBalance {
+		violation("баланс dev %d меньше баланса profit %d", devBalance, profitBalance)
+	}
+
+	return violations
+}
+
+// checkSupply проверяет, что собственные балансы аккаунтов изменились в каждой структуре ровно
+// на сумму зачислений и списаний, а общее количество монет — на сумму выпуска за вычетом сожженных монет.
+// Балансы до и после блока считаются на метку времени блока, поэтому сравнение точное.
+func (ledger *Ledger) checkSupply(changes *journal, supply map[umi.Prefix]int64, emission int64) (violations []error) {
+	height := ledger.LastBlockHeight
+	timestamp := ledger.LastBlockTimestamp
+
+	var total int64
+
+	for _, amount := range supply {
+		total += amount
+	}
+
+	if total != emission {
+		violations = append(violations, fmt.Errorf("%w: блок %d: балансы изменились на %d, ожидалось %d",
+			ErrInvariant, height, total, emission))
+	}
+
+	affected := ledger.affectedAddresses(changes)
+	deltas := make(map[umi.Prefix]int64)
+
+	for _, address := range affected {
+		prefix := address.Prefix()
+
+		if account, ok := ledger.journalAccount(changes, address); ok {
+			deltas[prefix] -= ledger.ownBalance(changes, address, account, timestamp)
+		}
+
+		if account, ok := ledger.journalAccount(nil, address); ok {
+			balance := ledger.ownBalance(nil, address, account, timestamp)
+			if balance < 0 {
+				violations = append(violations, fmt.Errorf("%w: блок %d, структура %s: отрицательный баланс %s: %d",
+					ErrInvariant, height, prefix.String(), address.String(), balance))
+			}
+
+			deltas[prefix] += balance
+		}
+	}
+
+	for prefix := range supply {
+		if _, ok := deltas[prefix]; !ok {
+			deltas[prefix] = 0
+		}
+	}
+
+	prefixes := make([]umi.Prefix, 0, len(deltas))
+
+	for prefix := range deltas {
+		prefixes = append(prefixes, prefix)
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i] < prefixes[j] })
+
+	for _, prefix := range prefixes {
+		if deltas[prefix] != supply[prefix] {
+			violations = append(violations, fmt.Errorf("%w: блок %d, структура %s: балансы изменились на %d, ожидалось %d",
+				ErrInvariant, height, prefix.String(), deltas[prefix], supply[prefix]))
+		}
+	}
+
+	return violations
+}
+
+// ownBalance возвращает собственный баланс аккаунта: для Profit без баланса структуры,
+// для Dev без баланса Profit. Если changes не nil, используется состояние до блока.
+func (ledger *Ledger) ownBalance(changes *journal, address umi.Address, account *Account, timestamp uint32) int64 {
+	balance := int64(account.BalanceAt(timestamp))
+
+	switch account.Type {
+	case umi.Profit:
+		if structure, ok := ledger.journalStructure(changes, address.Prefix()); ok {
+			balance -= int64(structure.BalanceAt(timestamp))
+		}
+
+	case umi.Dev:
+		if structure, ok := ledger.journalStructure(changes, address.Prefix()); ok {
+			if profitAccount, ok := ledger.journalAccount(changes, structure.ProfitAddress); ok {
+				balance -= int64(profitAccount.BalanceAt(timestamp))
+			}
+		}
+	}
+
+	return balance
+}
+
+// journalAccount возвращает аккаунт в состоянии до блока, если changes не nil, иначе текущий.
+func (ledger *Ledger) journalAccount(changes *journal, address umi.Address) (*Account, bool) {
+	if changes != nil {
+		if account, ok := changes.accounts[address]; ok {
+			return account, account != nil
+		}
+	}
+
+	account, ok := ledger.accounts[address.Prefix()][address]
+
+	return account, ok
+}
+
+// journalStructure возвращает структуру в состоянии до блока, если changes не nil, иначе текущую.
+func (ledger *Ledger) journalStructure(changes *journal, prefix umi.Prefix) (*Structure, bool) {
+	if changes != nil {
+		if structure, ok := changes.structures[prefix]; ok {
+			return structure, structure != nil
+		}
+	}
+
+	structure, ok := ledger.structures[prefix]
+
+	return structure, ok
+}
+
+// affectedAddresses возвращает адреса, собственный баланс которых мог измениться в блоке:
+// измененные аккаунты, а также Profit и Dev измененных структур до и после блока.
+func (ledger *Ledger) affectedAddresses(changes *journal) []umi.Address {
+	seen := make(map[umi.Address]struct{}, len(changes.accounts))
+	addresses := make([]umi.Address, 0, len(changes.accounts))
+
+	add := func(address umi.Address) {
+		if _, ok := seen[address]; ok {
+			return
+		}
+
+		seen[address] = struct{}{}
+		addresses = append(addresses, address)
+	}
+
+	for address := range changes.accounts {
+		add(address)
+	}
+
+	for _, prefix := range changedPrefixes(changes) {
+		for _, state := range []*journal{changes, nil} {
+			if structure, ok := ledger.journalStructure(state, prefix); ok && prefix != umi.PfxVerUmi {
+				add(structure.ProfitAddress)
+				add(structure.DevAddress)
+			}
+		}
+	}
+
+	return addresses
+}
+
+// changedPrefixes возвращает префиксы структур и аккаунтов, измененных в блоке.
+func changedPrefixes(changes *journal) []umi.Prefix {
+	seen := make(map[umi.Prefix]struct{})
+	prefixes := make([]umi.Prefix, 0)
+
+	add := func(prefix umi.Prefix) {
+		if _, ok := seen[prefix]; !ok {
+			seen[prefix] = struct{}{}
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	for prefix := range changes.structures {
+		add(prefix)
+	}
+
+	for address := range changes.accounts {
+		add(address.Prefix())
+	}
+
+	return prefixes
+}
+
+// txEmission возвращает изменение общего количества монет после применения транзакции.
+func txEmission(transaction umi.Transaction) int64 {
+	switch transaction.Type() {
+	case umi.TxGenesis, umi.TxIssue:
+		return int64(transaction.Amount())
+	case umi.TxSend:
+		return 0
+	default:
+		// Остальные транзакции списывают сумму с отправителя без зачисления.
+		return -int64(transaction.Amount())
+	}
+}
+
+func diff(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+
+	return b - a
+}
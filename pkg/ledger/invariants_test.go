@@ -0,0 +1,55 @@
+package ledger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestLedger_CheckInvariants(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.CheckInvariants = true
+
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit := newTestAddress(umi.PfxVerRoy, 11)
+
+	commitTestBlock(t, ledger, firstJun2020+20, newTestTransaction(umi.TxV8Send, master, deposit, 1_000_00, 2))
+
+	if violations := ledger.CheckInvariants(); len(violations) != 0 {
+		t.Fatalf("ожидаем 0 нарушений, получили %v", violations)
+	}
+
+	// Портим сумму балансов структуры: она перестает совпадать с суммой балансов аккаунтов.
+	ledger.structures[umi.PfxVerRoy].Balance += 1_000_00
+
+	if violations := ledger.CheckInvariants(); len(violations) == 0 {
+		t.Fatal("must return error")
+	}
+
+	before := copyTestState(ledger)
+	block := newTestBlock(ledger, firstJun2020+30, newTestTransaction(umi.TxV8Send, master, deposit, 1_000_00, 3))
+	confirmer := NewConfirmer(ledger)
+
+	if err := confirmer.ProcessBlock(block); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if err := confirmer.Commit(); !errors.Is(err, ErrInvariant) {
+		t.Fatalf("ожидаем '%v', получили '%v'", ErrInvariant, err)
+	}
+
+	if after := copyTestState(ledger); !reflect.DeepEqual(before, after) {
+		t.Error("блок с нарушением инвариантов не должен изменять леджер")
+	}
+
+	// Без проверки блок принимается.
+	conf.CheckInvariants = false
+
+	commitTestBlock(t, ledger, firstJun2020+30, newTestTransaction(umi.TxV8Send, master, deposit, 1_000_00, 3))
+}
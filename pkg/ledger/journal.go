@@ -0,0 +1,258 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+var ErrRewind = errors.New("rewind")
+
+// journal хранит состояние леджера до применения блока. Используется для отката блоков
+// при смене цепочки.
+type journal struct {
+	accounts   map[umi.Address]*Account
+	structures map[umi.Prefix]*Structure
+	nfts       map[umi.Hash]umi.Address
+	totals     map[umi.Prefix]supplyTotals
+	txHashes   []umi.Hash
+	nftHeights []uint64
+
+	lastBlockTimestamp    uint32
+	lastBlockHeight       uint32
+	lastBlockHash         umi.Hash
+	lastTransactionHeight uint64
+}
+
+// beginJournal начинает запись изменений. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) beginJournal() {
+	ledger.journal = &journal{
+		accounts:   make(map[umi.Address]*Account),
+		structures: make(map[umi.Prefix]*Structure),
+		nfts:       make(map[umi.Hash]umi.Address),
+		totals:     make(map[umi.Prefix]supplyTotals),
+
+		lastBlockTimestamp:    ledger.LastBlockTimestamp,
+		lastBlockHeight:       ledger.LastBlockHeight,
+		lastBlockHash:         ledger.LastBlockHash,
+		lastTransactionHeight: ledger.LastTransactionHeight,
+	}
+}
+
+// discardJournal отменяет изменения текущего блока, которые не удалось зафиксировать.
+func (ledger *Ledger) discardJournal() {
+	ledger.undo(ledger.journal)
+	ledger.truncateRateChanges(ledger.LastBlockHeight)
+	ledger.journal = nil
+}
+
+// commitJournal сохраняет записанные изменения в историю, ограниченную глубиной отката.
+func (ledger *Ledger) commitJournal() {
+	ledger.history = append(ledger.history, ledger.journal)
+	ledger.journal = nil
+
+	if depth := ledger.config.ReorgDepth; len(ledger.history) > depth {
+		copy(ledger.history, ledger.history[len(ledger.history)-depth:])
+
+		for i := depth; i < len(ledger.history); i++ {
+			ledger.history[i] = nil
+		}
+
+		ledger.history = ledger.history[:depth]
+	}
+}
+
+// saveAccount запоминает состояние аккаунта до первого изменения в текущем блоке.
+func (ledger *Ledger) saveAccount(address umi.Address) {
+	if ledger.journal == nil {
+		return
+	}
+
+	if _, ok := ledger.journal.accounts[address]; ok {
+		return
+	}
+
+	var saved *Account
+
+	if account, ok := ledger.accounts[address.Prefix()][address]; ok {
+		c := *account
+		saved = &c
+	}
+
+	ledger.journal.accounts[address] = saved
+}
+
+// saveStructure запоминает состояние структуры до первого изменения в текущем блоке.
+func (ledger *Ledger) saveStructure(prefix umi.Prefix) {
+	if ledger.journal == nil {
+		return
+	}
+
+	if _, ok := ledger.journal.structures[prefix]; ok {
+		return
+	}
+
+	var saved *Structure
+
+	if structure, ok := ledger.structures[prefix]; ok {
+		c := *structure
+		saved = &c
+	}
+
+	ledger.journal.structures[prefix] = saved
+}
+
+func (ledger *Ledger) saveNft(hash umi.Hash) {
+	if ledger.journal == nil {
+		return
+	}
+
+	if _, ok := ledger.journal.nfts[hash]; ok {
+		return
+	}
+
+	ledger.journal.nfts[hash] = ledger.nfts[hash]
+}
+
+// saveTotals запоминает выпущенные и сожженные структурой монеты до первого изменения в текущем блоке.
+func (ledger *Ledger) saveTotals(prefix umi.Prefix) {
+	if ledger.journal == nil {
+		return
+	}
+
+	if _, ok := ledger.journal.totals[prefix]; ok {
+		return
+	}
+
+	ledger.journal.totals[prefix] = ledger.totals[prefix]
+}
+
+// Rewind откатывает состояние леджера до указанной высоты блока.
+func (ledger *Ledger) Rewind(height uint32) error {
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	if height >= ledger.LastBlockHeight {
+		return nil
+	}
+
+	if cpHeight, _, ok := ledger.lastCheckpoint(); ok && height < cpHeight {
+		return fmt.Errorf("%w: невозможно откатить блоки ниже контрольной точки %d", ErrCheckpoint, cpHeight)
+	}
+
+	depth := int(ledger.LastBlockHeight - height)
+
+	if depth > len(ledger.history) {
+		return fmt.Errorf("%w: невозможно откатить %d блоков, доступно %d", ErrRewind, depth, len(ledger.history))
+	}
+
+	ledger.eraseStateRoots(height+1, ledger.LastBlockHeight)
+
+	if err := ledger.truncateSupply(height); err != nil {
+		log.Printf("ledger: не удалось обрезать историю эмиссии: %v", err)
+	}
+
+	for ; depth > 0; depth-- {
+		last := len(ledger.history) - 1
+
+		ledger.undo(ledger.history[last])
+		ledger.updateStateTree(ledger.history[last])
+		ledger.updateRichList(ledger.history[last])
+
+		ledger.history[last] = nil
+		ledger.history = ledger.history[:last]
+	}
+
+	ledger.truncateRateChanges(height)
+
+	return nil
+}
+
+func (ledger *Ledger) undo(changes *journal) {
+	for address, account := range changes.accounts {
+		prefix := address.Prefix()
+
+		if account == nil {
+			delete(ledger.accounts[prefix], address)
+
+			if len(ledger.accounts[prefix]) == 0 {
+				delete(ledger.accounts, prefix)
+			}
+
+			continue
+		}
+
+		accounts, ok := ledger.accounts[prefix]
+		if !ok {
+			accounts = make(map[umi.Address]*Account)
+			ledger.accounts[prefix] = accounts
+		}
+
+		accounts[address] = account
+	}
+
+	for prefix, structure := range changes.structures {
+		if structure == nil {
+			delete(ledger.structures, prefix)
+
+			continue
+		}
+
+		ledger.structures[prefix] = structure
+	}
+
+	for hash, owner := range changes.nfts {
+		if owner == (umi.Address{}) {
+			ledger.deleteNft(hash)
+
+			continue
+		}
+
+		ledger.setNftOwner(hash, owner)
+	}
+
+	for prefix, totals := range changes.totals {
+		if totals == (supplyTotals{}) {
+			delete(ledger.totals, prefix)
+
+			continue
+		}
+
+		ledger.totals[prefix] = totals
+	}
+
+	for _, hash := range changes.txHashes {
+		delete(ledger.transactions, hash)
+	}
+
+	for _, height := range changes.nftHeights {
+		delete(ledger.nftHeights, height)
+	}
+
+	ledger.LastBlockTimestamp = changes.lastBlockTimestamp
+	ledger.LastBlockHeight = changes.lastBlockHeight
+	ledger.LastBlockHash = changes.lastBlockHash
+	ledger.LastTransactionHeight = changes.lastTransactionHeight
+}
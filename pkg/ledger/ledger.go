@@ -21,6 +21,7 @@
 package ledger
 
 import (
+	"os"
 	"sync"
 
 	"gitlab.com/umitop/umid/pkg/config"
@@ -39,6 +40,23 @@ type Ledger struct {
 	structures   map[umi.Prefix]*Structure
 	transactions map[umi.Hash]struct{}
 	nfts         map[umi.Hash]umi.Address
+	nftHeights   map[uint64]umi.Hash
+	nftOwners    map[umi.Address][]umi.Hash
+	totals       map[umi.Prefix]supplyTotals
+	rateChanges  map[umi.Prefix][]RateChange
+
+	journal *journal
+	history []*journal
+
+	stateTree  *stateTree
+	stateRoots *os.File
+	richList   *richList
+
+	supplyData  *os.File
+	supplyIndex *os.File
+
+	snapshotHeight uint32
+	checkpoints    map[uint32]umi.Hash
 
 	LastBlockTimestamp    uint32
 	LastBlockHeight       uint32
@@ -48,13 +66,24 @@ type Ledger struct {
 
 func NewLedger(conf *config.Config) *Ledger {
 	ledger := &Ledger{
-		config:       conf,
-		accounts:     make(map[umi.Prefix]map[umi.Address]*Account),
-		structures:   make(map[umi.Prefix]*Structure),
-		transactions: make(map[umi.Hash]struct{}),
-		nfts:         make(map[umi.Hash]umi.Address),
+		config: conf,
 	}
 
+	ledger.reset()
+
+	return ledger
+}
+
+func (ledger *Ledger) reset() {
+	ledger.accounts = make(map[umi.Prefix]map[umi.Address]*Account)
+	ledger.structures = make(map[umi.Prefix]*Structure)
+	ledger.transactions = make(map[umi.Hash]struct{})
+	ledger.nfts = make(map[umi.Hash]umi.Address)
+	ledger.nftHeights = make(map[uint64]umi.Hash)
+	ledger.totals = make(map[umi.Prefix]supplyTotals)
+	ledger.rateChanges = make(map[umi.Prefix][]RateChange)
+	ledger.history = nil
+
 	// Структуру UMI существует по умолчанию
 	ledger.structures[umi.PfxVerUmi] = &Structure{
 		accountType: umi.Umi,
@@ -63,7 +92,14 @@ func NewLedger(conf *config.Config) *Ledger {
 		CreatedAt:   firstJun2020,
 	}
 
-	return ledger
+	ledger.LastBlockTimestamp = 0
+	ledger.LastBlockHeight = 0
+	ledger.LastBlockHash = umi.Hash{}
+	ledger.LastTransactionHeight = 0
+
+	ledger.rebuildStateTree()
+	ledger.rebuildRichList()
+	ledger.rebuildNftOwners()
 }
 
 func (ledger *Ledger) Account(address umi.Address) (account *Account, ok bool) {
@@ -119,17 +155,32 @@ func (ledger *Ledger) HasTransaction(hash umi.Hash) bool {
 	return ok
 }
 
+// NftsByAddr возвращает хеши NFT, которыми владеет адрес, в порядке возрастания.
 func (ledger *Ledger) NftsByAddr(addr umi.Address) []umi.Hash {
 	ledger.RLock()
 	defer ledger.RUnlock()
 
-	nfts := make([]umi.Hash, 0)
-
-	for hsh, adr := range ledger.nfts {
-		if adr == addr {
-			nfts = append(nfts, hsh)
-		}
-	}
+	nfts := make([]umi.Hash, len(ledger.nftOwners[addr]))
+	copy(nfts, ledger.nftOwners[addr])
 
 	return nfts
 }
+
+// NftByHeight возвращает хеш NFT, выпущенного транзакцией с указанной высотой.
+func (ledger *Ledger) NftByHeight(height uint64) (hash umi.Hash, ok bool) {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	hash, ok = ledger.nftHeights[height]
+
+	return hash, ok
+}
+
+func (ledger *Ledger) NftOwner(hash umi.Hash) (owner umi.Address, ok bool) {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	owner, ok = ledger.nfts[hash]
+
+	return owner, ok
+}
@@ -0,0 +1,209 @@
+package ledger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func newTestAddress(prefix umi.Prefix, index byte) (address umi.Address) {
+	address.SetPrefix(prefix)
+	address[2] = index
+
+	return address
+}
+
+func newTestTransaction(version uint8, sender, recipient umi.Address, amount uint64, nonce uint32) umi.Transaction {
+	transaction := umi.NewTransaction()
+	transaction.SetVersion(version)
+	transaction.SetSender(sender)
+	transaction.SetRecipient(recipient)
+	transaction.SetAmount(amount)
+	transaction.SetNonce(nonce)
+
+	return transaction
+}
+
+func newTestStructure(master umi.Address, prefix umi.Prefix, nonce uint32) umi.Transaction {
+	transaction := newTestTransaction(umi.TxV9CreateStructure, master, master, 50_000_00, nonce)
+	transaction.SetPrefix(prefix)
+	transaction.SetProfitPercent(5_00)
+	transaction.SetFeePercent(1_00)
+
+	return transaction
+}
+
+// newTestBlock создает блок с транзакциями, следующий за последним блоком леджера.
+func newTestBlock(ledger *Ledger, timestamp uint32, transactions ...umi.Transaction) umi.Block {
+	version := uint8(1)
+	if ledger.LastBlockHeight == 0 {
+		version = 0
+	}
+
+	block := umi.NewBlock().SetVersion(version).SetTransactionCount(len(transactions))
+	block.SetPreviousBlockHash(ledger.LastBlockHash)
+	block.SetTimestamp(timestamp)
+
+	for _, transaction := range transactions {
+		confirmed := make([]byte, umi.TxConfirmedLength)
+		copy(confirmed, transaction)
+
+		block = append(block, confirmed...)
+	}
+
+	return block
+}
+
+// commitTestBlock подтверждает блок с транзакциями поверх последнего блока леджера.
+func commitTestBlock(t *testing.T, ledger *Ledger, timestamp uint32, transactions ...umi.Transaction) umi.Block {
+	t.Helper()
+
+	block := newTestBlock(ledger, timestamp, transactions...)
+	confirmer := NewConfirmer(ledger)
+
+	if err := confirmer.ProcessBlock(block); err != nil {
+		t.Fatalf("блок %d: %v", ledger.LastBlockHeight+1, err)
+	}
+
+	if err := confirmer.Commit(); err != nil {
+		t.Fatalf("блок %d: %v", ledger.LastBlockHeight+1, err)
+	}
+
+	return block
+}
+
+// newTestLedger создает леджер с GENESIS-блоком и структурой ROY.
+func newTestLedger(t *testing.T, conf *config.Config) *Ledger {
+	t.Helper()
+
+	ledger := NewLedger(conf)
+	genesis := newTestAddress(umi.PfxVerGenesis, 1)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+
+	commitTestBlock(t, ledger, firstJun2020, newTestTransaction(umi.TxV0Genesis, genesis, master, 10_000_000_00, 0))
+	commitTestBlock(t, ledger, firstJun2020+10, newTestStructure(master, umi.PfxVerRoy, 1))
+
+	return ledger
+}
+
+type testLedgerState struct {
+	accounts     map[umi.Address]Account
+	structures   map[umi.Prefix]Structure
+	transactions map[umi.Hash]struct{}
+	nfts         map[umi.Hash]umi.Address
+	nftHeights   map[uint64]umi.Hash
+	totals       map[umi.Prefix]supplyTotals
+	rateChanges  map[umi.Prefix][]RateChange
+
+	lastBlockHeight       uint32
+	lastBlockHash         umi.Hash
+	lastTransactionHeight uint64
+}
+
+func copyTestState(ledger *Ledger) testLedgerState {
+	state := testLedgerState{
+		accounts:     make(map[umi.Address]Account),
+		structures:   make(map[umi.Prefix]Structure),
+		transactions: make(map[umi.Hash]struct{}),
+		nfts:         make(map[umi.Hash]umi.Address),
+		nftHeights:   make(map[uint64]umi.Hash),
+		totals:       make(map[umi.Prefix]supplyTotals),
+		rateChanges:  make(map[umi.Prefix][]RateChange),
+
+		lastBlockHeight:       ledger.LastBlockHeight,
+		lastBlockHash:         ledger.LastBlockHash,
+		lastTransactionHeight: ledger.LastTransactionHeight,
+	}
+
+	for _, accounts := range ledger.accounts {
+		for address, account := range accounts {
+			state.accounts[address] = *account
+		}
+	}
+
+	for prefix, structure := range ledger.structures {
+		state.structures[prefix] = *structure
+	}
+
+	for hash := range ledger.transactions {
+		state.transactions[hash] = struct{}{}
+	}
+
+	for hash, owner := range ledger.nfts {
+		state.nfts[hash] = owner
+	}
+
+	for height, hash := range ledger.nftHeights {
+		state.nftHeights[height] = hash
+	}
+
+	for prefix, totals := range ledger.totals {
+		state.totals[prefix] = totals
+	}
+
+	for prefix, changes := range ledger.rateChanges {
+		state.rateChanges[prefix] = append([]RateChange(nil), changes...)
+	}
+
+	return state
+}
+
+func TestLedger_Rewind(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.ReorgDepth = 3
+
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit1 := newTestAddress(umi.PfxVerRoy, 11)
+	deposit2 := newTestAddress(umi.PfxVerRoy, 12)
+
+	before := copyTestState(ledger)
+
+	block3 := []umi.Transaction{
+		newTestTransaction(umi.TxV8Send, master, deposit1, 3_000_000_00, 2),
+		newTestTransaction(umi.TxV8Send, master, deposit2, 1_000_00, 3),
+	}
+
+	commitTestBlock(t, ledger, firstJun2020+20, block3...)
+	middle := copyTestState(ledger)
+
+	burn := newTestTransaction(umi.TxV15Burn, deposit1, deposit1, 100, 5)
+	commitTestBlock(t, ledger, firstJun2020+86400*30,
+		newTestTransaction(umi.TxV8Send, deposit1, deposit2, 1_000_00, 4), burn)
+
+	if err := ledger.Rewind(3); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if after := copyTestState(ledger); !reflect.DeepEqual(middle, after) {
+		t.Errorf("состояние после отката блока 4 отличается от исходного")
+	}
+
+	if err := ledger.Rewind(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if after := copyTestState(ledger); !reflect.DeepEqual(before, after) {
+		t.Errorf("состояние после отката блока 3 отличается от исходного")
+	}
+
+	if _, ok := ledger.Account(deposit1); ok {
+		t.Error("аккаунт создан в откаченном блоке, must be deleted")
+	}
+
+	// Откаченные транзакции можно подтвердить повторно.
+	commitTestBlock(t, ledger, firstJun2020+20, block3...)
+
+	if after := copyTestState(ledger); !reflect.DeepEqual(middle, after) {
+		t.Errorf("состояние после повторного подтверждения блока 3 отличается")
+	}
+
+	if err := ledger.Rewind(0); !errors.Is(err, ErrRewind) {
+		t.Errorf("глубина отката больше истории, ожидаем '%v', получили '%v'", ErrRewind, err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"bytes"
+	"sort"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// setNftOwner меняет владельца NFT и переносит его в индексе владельцев.
+// Вызывается только под блокировкой леджера.
+func (ledger *Ledger) setNftOwner(hash umi.Hash, owner umi.Address) {
+	if prev, ok := ledger.nfts[hash]; ok {
+		if prev == owner {
+			return
+		}
+
+		ledger.removeNftOwner(hash, prev)
+	}
+
+	ledger.nfts[hash] = owner
+	ledger.addNftOwner(hash, owner)
+}
+
+// deleteNft удаляет NFT вместе с записью в индексе владельцев.
+func (ledger *Ledger) deleteNft(hash umi.Hash) {
+	if prev, ok := ledger.nfts[hash]; ok {
+		ledger.removeNftOwner(hash, prev)
+	}
+
+	delete(ledger.nfts, hash)
+}
+
+// rebuildNftOwners строит индекс владельцев NFT заново.
+func (ledger *Ledger) rebuildNftOwners() {
+	ledger.nftOwners = make(map[umi.Address][]umi.Hash)
+
+	for hash, owner := range ledger.nfts {
+		ledger.nftOwners[owner] = append(ledger.nftOwners[owner], hash)
+	}
+
+	for _, hashes := range ledger.nftOwners {
+		sort.Slice(hashes, func(i, j int) bool {
+			return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+		})
+	}
+}
+
+// addNftOwner добавляет хеш в упорядоченный список NFT владельца.
+func (ledger *Ledger) addNftOwner(hash umi.Hash, owner umi.Address) {
+	hashes := ledger.nftOwners[owner]
+	i := searchHash(hashes, hash)
+
+	hashes = append(hashes, umi.Hash{})
+	copy(hashes[i+1:], hashes[i:])
+	hashes[i] = hash
+
+	ledger.nftOwners[owner] = hashes
+}
+
+func (ledger *Ledger) removeNftOwner(hash umi.Hash, owner umi.Address) {
+	hashes := ledger.nftOwners[owner]
+	i := searchHash(hashes, hash)
+
+	if i == len(hashes) || hashes[i] != hash {
+		return
+	}
+
+	if len(hashes) == 1 {
+		delete(ledger.nftOwners, owner)
+
+		return
+	}
+
+	ledger.nftOwners[owner] = append(hashes[:i], hashes[i+1:]...)
+}
+
+func searchHash(hashes []umi.Hash, hash umi.Hash) int {
+	return sort.Search(len(hashes), func(i int) bool {
+		return bytes.Compare(hashes[i][:], hash[:]) >= 0
+	})
+}
@@ -0,0 +1,91 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestConfirmer_TransferNft(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.ReorgDepth = 3
+
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	owner := newTestAddress(umi.PfxVerNft, 11)
+	recipient := newTestAddress(umi.PfxVerNft, 12)
+
+	commitTestBlock(t, ledger, firstJun2020+20, newTestStructure(master, umi.PfxVerNft, 2))
+	commitTestBlock(t, ledger, firstJun2020+30, newTestTransaction(umi.TxV8Send, master, owner, 1_000_00, 3))
+
+	mint := newTestTransaction(umi.TxV18MintNftWitness, owner, owner, 1_00, 4)
+	commitTestBlock(t, ledger, firstJun2020+40, mint)
+
+	height := ledger.LastTransactionHeight
+
+	if hash, ok := ledger.NftByHeight(height); !ok || hash != mint.Hash() {
+		t.Fatalf("expected %x, got %x", mint.Hash(), hash)
+	}
+
+	transfer := func(sender umi.Address, nftHeight uint64, nonce uint32) umi.Transaction {
+		transaction := newTestTransaction(umi.TxV19TransferNft, sender, recipient, 0, nonce)
+		transaction.SetNftHeight(nftHeight)
+
+		return transaction
+	}
+
+	tests := []struct {
+		transaction umi.Transaction
+		err         error
+	}{
+		{transfer(recipient, height, 5), errInsufficientPrivileges},
+		{transfer(owner, height+1, 6), errNotFound},
+	}
+
+	for i, test := range tests {
+		confirmer := NewConfirmer(ledger)
+
+		if err := confirmer.ProcessBlock(newTestBlock(ledger, firstJun2020+50, test.transaction)); !errors.Is(err, test.err) {
+			t.Errorf("%d: ожидаем '%v', получили '%v'", i, test.err, err)
+		}
+	}
+
+	confirmer := NewConfirmerLegacy(ledger)
+	confirmer.ResetState()
+
+	confirmed := make(umi.Transaction, umi.TxConfirmedLength)
+	copy(confirmed, transfer(owner, height, 7))
+
+	confirmed, err := confirmer.ProcessTransferNftLegacy(confirmed)
+	if err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if confirmed.NftHash() != mint.Hash() {
+		t.Errorf("expected %x, got %x", mint.Hash(), confirmed.NftHash())
+	}
+
+	commitTestBlock(t, ledger, firstJun2020+50, transfer(owner, height, 7))
+
+	if got, _ := ledger.NftOwner(mint.Hash()); got != recipient {
+		t.Errorf("expected %s, got %s", recipient.String(), got.String())
+	}
+
+	// Прежний владелец больше не может передать NFT.
+	if err := NewConfirmer(ledger).ProcessBlock(newTestBlock(ledger, firstJun2020+60,
+		transfer(owner, height, 8))); !errors.Is(err, errInsufficientPrivileges) {
+		t.Errorf("ожидаем '%v', получили '%v'", errInsufficientPrivileges, err)
+	}
+
+	if err := ledger.Rewind(ledger.LastBlockHeight - 1); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if got, _ := ledger.NftOwner(mint.Hash()); got != owner {
+		t.Errorf("после отката expected %s, got %s", owner.String(), got.String())
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"sort"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// RateChange — пересчет процентных ставок всех адресов структуры, не связанный с транзакциями
+// этих адресов: смена уровня структуры или изменение процента профита или комиссии.
+type RateChange struct {
+	Height            uint32
+	Timestamp         uint32
+	LevelInterestRate uint16
+	ProfitPercent     uint16
+}
+
+// InterestRate возвращает ставку адреса указанного типа после пересчета.
+func (change RateChange) InterestRate(accountType umi.AccountType) uint16 {
+	structure := Structure{
+		LevelInterestRate: change.LevelInterestRate,
+		ProfitPercent:     change.ProfitPercent,
+	}
+
+	return structure.InterestRate(accountType)
+}
+
+// RateChanges возвращает пересчеты ставок структуры в блоках from..to включительно в порядке высоты.
+func (ledger *Ledger) RateChanges(prefix umi.Prefix, from, to uint32) []RateChange {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	changes := ledger.rateChanges[prefix]
+	low := sort.Search(len(changes), func(i int) bool { return changes[i].Height >= from })
+	high := sort.Search(len(changes), func(i int) bool { return changes[i].Height > to })
+
+	if low >= high {
+		return nil
+	}
+
+	return append([]RateChange(nil), changes[low:high]...)
+}
+
+// addRateChange запоминает пересчет ставок. В одном блоке ставки структуры могут пересчитываться
+// несколько раз, сохраняется последний пересчет. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) addRateChange(prefix umi.Prefix, change RateChange) {
+	changes := ledger.rateChanges[prefix]
+
+	if last := len(changes) - 1; last >= 0 && changes[last].Height == change.Height {
+		changes[last] = change
+
+		return
+	}
+
+	ledger.rateChanges[prefix] = append(changes, change)
+}
+
+// truncateRateChanges удаляет пересчеты ставок выше указанной высоты. Вызывается только под
+// блокировкой леджера.
+func (ledger *Ledger) truncateRateChanges(height uint32) {
+	for prefix, changes := range ledger.rateChanges {
+		n := sort.Search(len(changes), func(i int) bool { return changes[i].Height > height })
+
+		if n == 0 {
+			delete(ledger.rateChanges, prefix)
+
+			continue
+		}
+
+		ledger.rateChanges[prefix] = changes[:n]
+	}
+}
@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestLedger_RateChanges(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.ReorgDepth = 3
+
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit := newTestAddress(umi.PfxVerRoy, 11)
+
+	if changes := ledger.RateChanges(umi.PfxVerRoy, 0, 2); len(changes) != 0 {
+		t.Fatalf("expected 0, got %d", len(changes))
+	}
+
+	// Блок 3 поднимает структуру на уровень 1, блок 4 — на уровень 3.
+	commitTestBlock(t, ledger, firstJun2020+20, newTestTransaction(umi.TxV8Send, master, deposit, 60_000_00, 2))
+	commitTestBlock(t, ledger, firstJun2020+30, newTestTransaction(umi.TxV8Send, master, deposit, 500_000_00, 3))
+
+	changes := ledger.RateChanges(umi.PfxVerRoy, 0, 4)
+
+	if len(changes) != 2 {
+		t.Fatalf("expected 2, got %d", len(changes))
+	}
+
+	if changes[0].Height != 3 || changes[0].LevelInterestRate != 10_00 || changes[0].Timestamp != firstJun2020+20 {
+		t.Errorf("неверный пересчет ставок в блоке 3: %+v", changes[0])
+	}
+
+	if changes[1].Height != 4 || changes[1].LevelInterestRate != 20_00 {
+		t.Errorf("неверный пересчет ставок в блоке 4: %+v", changes[1])
+	}
+
+	account, _ := ledger.Account(deposit)
+	if rate := changes[1].InterestRate(umi.Deposit); rate != account.InterestRate {
+		t.Errorf("expected %d, got %d", account.InterestRate, rate)
+	}
+
+	if changes := ledger.RateChanges(umi.PfxVerRoy, 4, 4); len(changes) != 1 || changes[0].Height != 4 {
+		t.Errorf("ожидаем пересчет в блоке 4, получили %+v", changes)
+	}
+
+	if err := ledger.Rewind(3); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if changes := ledger.RateChanges(umi.PfxVerRoy, 0, 4); len(changes) != 1 || changes[0].Height != 3 {
+		t.Errorf("ожидаем пересчет в блоке 3, получили %+v", changes)
+	}
+
+	if err := ledger.Rewind(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if changes := ledger.RateChanges(umi.PfxVerRoy, 0, 4); len(changes) != 0 {
+		t.Errorf("expected 0, got %d", len(changes))
+	}
+}
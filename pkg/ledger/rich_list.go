@@ -0,0 +1,274 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"container/heap"
+	"math"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// Аккаунты одной структуры и одного типа получают проценты по одной ставке, поэтому их порядок
+// по балансу со временем не меняется. Внутри группы аккаунты упорядочены по логарифму баланса,
+// приведенного к нулевой метке времени, а при выдаче группы сливаются по балансу на нужный момент.
+
+// RichListItem — аккаунт в списке, упорядоченном по балансу.
+type RichListItem struct {
+	Address umi.Address
+	Account Account
+}
+
+type richGroup struct {
+	prefix      umi.Prefix
+	accountType umi.AccountType
+}
+
+type richEntry struct {
+	group richGroup
+	key   float64
+}
+
+type richList struct {
+	groups  map[richGroup]*skipList
+	entries map[umi.Address]richEntry
+}
+
+func newRichList() *richList {
+	return &richList{
+		groups:  make(map[richGroup]*skipList),
+		entries: make(map[umi.Address]richEntry),
+	}
+}
+
+// richKey возвращает ключ сортировки: логарифм баланса на нулевую метку времени.
+func richKey(account *Account) float64 {
+	if account.Balance == 0 {
+		return math.Inf(-1)
+	}
+
+	key := math.Log(float64(account.Balance))
+
+	if account.InterestRate != 0 {
+		key -= float64(account.UpdatedAt) * math.Log1p(float64(account.InterestRate)/float64(100_00)) / float64(2592000)
+	}
+
+	return key
+}
+
+// set добавляет, перемещает или удаляет (если account == nil) адрес в списке.
+func (list *richList) set(address umi.Address, account *Account) {
+	if entry, ok := list.entries[address]; ok {
+		group := list.groups[entry.group]
+		group.remove(entry.key, address)
+
+		if group.length == 0 {
+			delete(list.groups, entry.group)
+		}
+
+		delete(list.entries, address)
+	}
+
+	if account == nil {
+		return
+	}
+
+	entry := richEntry{
+		group: richGroup{prefix: address.Prefix(), accountType: account.Type},
+		key:   richKey(account),
+	}
+
+	group, ok := list.groups[entry.group]
+	if !ok {
+		group = newSkipList()
+		list.groups[entry.group] = group
+	}
+
+	group.insert(entry.key, address)
+	list.entries[address] = entry
+}
+
+// rebuildRichList строит список заново. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) rebuildRichList() {
+	ledger.richList = newRichList()
+
+	for _, accounts := range ledger.accounts {
+		for address, account := range accounts {
+			ledger.richList.set(address, account)
+		}
+	}
+}
+
+// updateRichList переставляет аккаунты, затронутые блоком. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) updateRichList(changes *journal) {
+	for address := range changes.accounts {
+		account, _ := ledger.journalAccount(nil, address)
+		ledger.richList.set(address, account)
+	}
+}
+
+// selectGroups возвращает группы структур prefixes (все, если список пуст) и типов types (все, если пуст).
+func (list *richList) selectGroups(prefixes []umi.Prefix, types []umi.AccountType) []*skipList {
+	groups := make([]*skipList, 0)
+
+	for group, skip := range list.groups {
+		if matchPrefix(prefixes, group.prefix) && matchType(types, group.accountType) {
+			groups = append(groups, skip)
+		}
+	}
+
+	return groups
+}
+
+// RichListCount возвращает количество аккаунтов структур prefixes и типов types.
+// Пустой список означает отсутствие фильтра.
+func (ledger *Ledger) RichListCount(prefixes []umi.Prefix, types []umi.AccountType) (count int) {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	for _, group := range ledger.richList.selectGroups(prefixes, types) {
+		count += group.length
+	}
+
+	return count
+}
+
+// RichList возвращает аккаунты с позиции first до last (не включая), упорядоченные по убыванию
+// баланса на момент timestamp. Пустой список prefixes или types означает отсутствие фильтра.
+func (ledger *Ledger) RichList(prefixes []umi.Prefix, types []umi.AccountType, timestamp uint32,
+	first, last int) []*RichListItem {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	groups := ledger.richList.selectGroups(prefixes, types)
+	items := make([]*RichListItem, 0)
+
+	if first >= last {
+		return items
+	}
+
+	// Внутри одной группы можно сразу перейти к нужной позиции.
+	if len(groups) == 1 {
+		for node := groups[0].at(first); node != nil && len(items) < last-first; node = node.next[0] {
+			items = append(items, ledger.richListItem(node.address))
+		}
+
+		return items
+	}
+
+	merge := &richMerge{ledger: ledger, timestamp: timestamp}
+
+	for _, group := range groups {
+		if node := group.at(0); node != nil {
+			merge.push(node)
+		}
+	}
+
+	for rank := 0; rank < last && merge.Len() > 0; rank++ {
+		node := heap.Pop(merge).(*richCursor).node //nolint:forcetypeassert // ...
+
+		if rank >= first {
+			items = append(items, ledger.richListItem(node.address))
+		}
+
+		if node.next[0] != nil {
+			merge.push(node.next[0])
+		}
+	}
+
+	return items
+}
+
+func (ledger *Ledger) richListItem(address umi.Address) *RichListItem {
+	account := ledger.accounts[address.Prefix()][address]
+
+	return &RichListItem{
+		Address: address,
+		Account: *account,
+	}
+}
+
+type richCursor struct {
+	node    *skipNode
+	balance uint64
+}
+
+// richMerge — куча текущих узлов групп, упорядоченная по убыванию баланса на момент timestamp.
+type richMerge struct {
+	ledger    *Ledger
+	timestamp uint32
+	cursors   []*richCursor
+}
+
+func (merge *richMerge) push(node *skipNode) {
+	account := merge.ledger.accounts[node.address.Prefix()][node.address]
+
+	heap.Push(merge, &richCursor{node: node, balance: account.BalanceAt(merge.timestamp)})
+}
+
+func (merge *richMerge) Len() int {
+	return len(merge.cursors)
+}
+
+func (merge *richMerge) Less(i, j int) bool {
+	a, b := merge.cursors[i], merge.cursors[j]
+
+	if a.balance != b.balance {
+		return a.balance > b.balance
+	}
+
+	return a.node.before(b.node.key, b.node.address)
+}
+
+func (merge *richMerge) Swap(i, j int) {
+	merge.cursors[i], merge.cursors[j] = merge.cursors[j], merge.cursors[i]
+}
+
+func (merge *richMerge) Push(x interface{}) {
+	merge.cursors = append(merge.cursors, x.(*richCursor)) //nolint:forcetypeassert // ...
+}
+
+func (merge *richMerge) Pop() interface{} {
+	last := len(merge.cursors) - 1
+	cursor := merge.cursors[last]
+	merge.cursors[last] = nil
+	merge.cursors = merge.cursors[:last]
+
+	return cursor
+}
+
+func matchPrefix(prefixes []umi.Prefix, prefix umi.Prefix) bool {
+	return len(prefixes) == 0 || containsPrefix(prefixes, prefix)
+}
+
+func matchType(types []umi.AccountType, accountType umi.AccountType) bool {
+	if len(types) == 0 {
+		return true
+	}
+
+	for _, t := range types {
+		if t == accountType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,153 @@
+package ledger
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestSkipList(t *testing.T) {
+	t.Parallel()
+
+	list := newSkipList()
+	keys := make(map[umi.Address]float64)
+
+	for i := 0; i < 1000; i++ {
+		address := newTestAddress(umi.PfxVerRoy, byte(i))
+		address[3] = byte(i >> 8)
+		keys[address] = float64((i * 7919) % 101)
+
+		list.insert(keys[address], address)
+	}
+
+	for address, key := range keys {
+		if address[2]%3 == 0 {
+			if !list.remove(key, address) {
+				t.Fatalf("адрес %v не найден", address)
+			}
+
+			delete(keys, address)
+		}
+	}
+
+	if list.remove(1_000, newTestAddress(umi.PfxVerRoy, 0)) {
+		t.Error("адреса нет в списке, must return false")
+	}
+
+	if list.length != len(keys) {
+		t.Fatalf("expected %d, got %d", len(keys), list.length)
+	}
+
+	rank := 0
+
+	for node := list.head.next[0]; node != nil; node = node.next[0] {
+		if list.at(rank) != node {
+			t.Fatalf("позиция %d не совпадает с обходом списка", rank)
+		}
+
+		if next := node.next[0]; next != nil && !node.before(next.key, next.address) {
+			t.Fatalf("позиция %d: нарушен порядок", rank)
+		}
+
+		rank++
+	}
+
+	if rank != len(keys) || list.at(rank) != nil {
+		t.Errorf("expected %d, got %d", len(keys), rank)
+	}
+}
+
+func TestLedger_RichList(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.ReorgDepth = 3
+
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposits := make([]umi.Address, 0)
+	transactions := make([]umi.Transaction, 0)
+
+	for i := 0; i < 20; i++ {
+		deposit := newTestAddress(umi.PfxVerRoy, byte(11+i))
+		deposits = append(deposits, deposit)
+		transactions = append(transactions,
+			newTestTransaction(umi.TxV8Send, master, deposit, uint64(i%7+1)*1_000_00, uint32(2+i)))
+	}
+
+	commitTestBlock(t, ledger, firstJun2020+20, transactions...)
+	before := checkRichList(t, ledger, firstJun2020+86400)
+
+	// Переводы меняют порядок адресов.
+	commitTestBlock(t, ledger, firstJun2020+86400*10,
+		newTestTransaction(umi.TxV8Send, deposits[0], deposits[1], 500_00, 22),
+		newTestTransaction(umi.TxV8Send, deposits[6], deposits[0], 6_000_00, 23),
+		newTestTransaction(umi.TxV8Send, master, deposits[19], 10_000_00, 24))
+
+	if after := checkRichList(t, ledger, firstJun2020+86400*20); reflect.DeepEqual(before, after) {
+		t.Error("порядок адресов должен измениться")
+	}
+
+	if err := ledger.Rewind(3); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if after := checkRichList(t, ledger, firstJun2020+86400); !reflect.DeepEqual(before, after) {
+		t.Errorf("после отката ожидаем %v, получили %v", before, after)
+	}
+
+	if err := ledger.Rewind(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if count := ledger.RichListCount([]umi.Prefix{umi.PfxVerRoy}, []umi.AccountType{umi.Deposit}); count != 0 {
+		t.Errorf("expected 0, got %d", count)
+	}
+
+	checkRichList(t, ledger, firstJun2020+86400)
+}
+
+// checkRichList сверяет список с аккаунтами леджера, отсортированными по балансу, и возвращает адреса
+// в порядке списка.
+func checkRichList(t *testing.T, ledger *Ledger, timestamp uint32) []umi.Address {
+	t.Helper()
+
+	count := ledger.RichListCount(nil, nil)
+	items := ledger.RichList(nil, nil, timestamp, 0, count)
+	addresses := make([]umi.Address, 0, len(items))
+	balances := make([]uint64, 0)
+
+	for _, accounts := range ledger.accounts {
+		for _, account := range accounts {
+			balances = append(balances, account.BalanceAt(timestamp))
+		}
+	}
+
+	sort.Slice(balances, func(i, j int) bool { return balances[i] > balances[j] })
+
+	if len(items) != len(balances) {
+		t.Fatalf("expected %d, got %d", len(balances), len(items))
+	}
+
+	for i, item := range items {
+		if balance := item.Account.BalanceAt(timestamp); balance != balances[i] {
+			t.Errorf("позиция %d: expected %d, got %d", i, balances[i], balance)
+		}
+
+		addresses = append(addresses, item.Address)
+	}
+
+	// Внутри одной группы выдача начинается сразу с нужной позиции.
+	group := ledger.RichList([]umi.Prefix{umi.PfxVerRoy}, []umi.AccountType{umi.Deposit}, timestamp, 1, 4)
+
+	for i := 1; i < len(group); i++ {
+		if group[i-1].Account.BalanceAt(timestamp) < group[i].Account.BalanceAt(timestamp) {
+			t.Errorf("группа: нарушен порядок на позиции %d", i)
+		}
+	}
+
+	return addresses
+}
@@ -0,0 +1,336 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// undoLog хранит состояние записей конфирмера до их первого изменения в транзакции, чтобы отменить
+// транзакцию, которая не прошла проверку, не копируя все накопленные в блоке изменения.
+// nil означает, что записи у конфирмера не было.
+type undoLog struct {
+	accounts   map[umi.Address]*Account
+	structures map[umi.Prefix]*Structure
+	nfts       map[umi.Hash]*umi.Address
+	nftHeights map[uint64]*umi.Hash
+	supply     map[umi.Prefix]*int64
+	totals     map[umi.Prefix]*supplyTotals
+
+	txHashes          int
+	emission          int64
+	transactionHeight uint64
+}
+
+func (confirmer *Confirmer) beginUndo() {
+	confirmer.undo = &undoLog{
+		accounts:   make(map[umi.Address]*Account),
+		structures: make(map[umi.Prefix]*Structure),
+		nfts:       make(map[umi.Hash]*umi.Address),
+		nftHeights: make(map[uint64]*umi.Hash),
+		supply:     make(map[umi.Prefix]*int64),
+		totals:     make(map[umi.Prefix]*supplyTotals),
+
+		txHashes:          len(confirmer.txHashes),
+		emission:          confirmer.emission,
+		transactionHeight: confirmer.TransactionHeight,
+	}
+}
+
+// saveAccount запоминает аккаунт перед изменением, если транзакция выполняется с возможностью отмены.
+func (confirmer *Confirmer) saveAccount(address umi.Address) {
+	if confirmer.undo == nil {
+		return
+	}
+
+	if _, ok := confirmer.undo.accounts[address]; ok {
+		return
+	}
+
+	var saved *Account
+
+	if account, ok := confirmer.accounts[address]; ok {
+		c := *account
+		saved = &c
+	}
+
+	confirmer.undo.accounts[address] = saved
+}
+
+func (confirmer *Confirmer) saveStructure(prefix umi.Prefix) {
+	if confirmer.undo == nil {
+		return
+	}
+
+	if _, ok := confirmer.undo.structures[prefix]; ok {
+		return
+	}
+
+	var saved *Structure
+
+	if structure, ok := confirmer.structures[prefix]; ok {
+		c := *structure
+		saved = &c
+	}
+
+	confirmer.undo.structures[prefix] = saved
+}
+
+func (confirmer *Confirmer) saveNft(hash umi.Hash) {
+	if confirmer.undo == nil {
+		return
+	}
+
+	if _, ok := confirmer.undo.nfts[hash]; ok {
+		return
+	}
+
+	var saved *umi.Address
+
+	if owner, ok := confirmer.nfts[hash]; ok {
+		saved = &owner
+	}
+
+	confirmer.undo.nfts[hash] = saved
+}
+
+func (confirmer *Confirmer) saveNftHeight(height uint64) {
+	if confirmer.undo == nil {
+		return
+	}
+
+	if _, ok := confirmer.undo.nftHeights[height]; ok {
+		return
+	}
+
+	var saved *umi.Hash
+
+	if hash, ok := confirmer.nftHeights[height]; ok {
+		saved = &hash
+	}
+
+	confirmer.undo.nftHeights[height] = saved
+}
+
+func (confirmer *Confirmer) saveSupply(prefix umi.Prefix) {
+	if confirmer.undo == nil {
+		return
+	}
+
+	if _, ok := confirmer.undo.supply[prefix]; ok {
+		return
+	}
+
+	var saved *int64
+
+	if amount, ok := confirmer.supply[prefix]; ok {
+		saved = &amount
+	}
+
+	confirmer.undo.supply[prefix] = saved
+}
+
+func (confirmer *Confirmer) saveTotals(prefix umi.Prefix) {
+	if confirmer.undo == nil {
+		return
+	}
+
+	if _, ok := confirmer.undo.totals[prefix]; ok {
+		return
+	}
+
+	var saved *supplyTotals
+
+	if totals, ok := confirmer.totals[prefix]; ok {
+		saved = &totals
+	}
+
+	confirmer.undo.totals[prefix] = saved
+}
+
+// rollbackUndo восстанавливает записи, измененные после beginUndo. Аккаунты и структуры
+// восстанавливаются на месте, поэтому ранее выданные указатели остаются действительными.
+func (confirmer *Confirmer) rollbackUndo() {
+	undo := confirmer.undo
+
+	for address, saved := range undo.accounts {
+		account, ok := confirmer.accounts[address]
+
+		switch {
+		case saved == nil:
+			delete(confirmer.accounts, address)
+		case ok:
+			*account = *saved
+		default:
+			confirmer.accounts[address] = saved
+		}
+	}
+
+	for prefix, saved := range undo.structures {
+		structure, ok := confirmer.structures[prefix]
+
+		switch {
+		case saved == nil:
+			delete(confirmer.structures, prefix)
+		case ok:
+			*structure = *saved
+		default:
+			confirmer.structures[prefix] = saved
+		}
+	}
+
+	for hash, saved := range undo.nfts {
+		if saved == nil {
+			delete(confirmer.nfts, hash)
+
+			continue
+		}
+
+		confirmer.nfts[hash] = *saved
+	}
+
+	for height, saved := range undo.nftHeights {
+		if saved == nil {
+			delete(confirmer.nftHeights, height)
+
+			continue
+		}
+
+		confirmer.nftHeights[height] = *saved
+	}
+
+	for prefix, saved := range undo.supply {
+		if saved == nil {
+			delete(confirmer.supply, prefix)
+
+			continue
+		}
+
+		confirmer.supply[prefix] = *saved
+	}
+
+	for prefix, saved := range undo.totals {
+		if saved == nil {
+			delete(confirmer.totals, prefix)
+
+			continue
+		}
+
+		confirmer.totals[prefix] = *saved
+	}
+
+	confirmer.txHashes = confirmer.txHashes[:undo.txHashes]
+	confirmer.emission = undo.emission
+	confirmer.TransactionHeight = undo.transactionHeight
+}
+
+// isolate вызывает process и отменяет все изменения конфирмера, сделанные в нем, если process
+// вернул false или ошибку. Отменяются только записи, которые process изменил.
+func (confirmer *Confirmer) isolate(process func() (bool, error)) (bool, error) {
+	confirmer.beginUndo()
+	defer func() { confirmer.undo = nil }()
+
+	ok, err := process()
+	if err != nil || !ok {
+		confirmer.rollbackUndo()
+	}
+
+	return ok, err
+}
+
+// Isolate вызывает process и отменяет все изменения конфирмера, сделанные в нем, если process
+// вернул false или ошибку. Генератор так исключает из блока транзакцию, не прошедшую обработку.
+func (confirmer *ConfirmerLegacy) Isolate(process func() (bool, error)) (bool, error) {
+	return confirmer.isolate(process)
+}
+
+// Simulate применяет транзакцию к текущему состоянию леджера, не фиксируя изменения.
+// Перед ней применяются неподтвержденные транзакции pending, те из них, которые не проходят
+// проверку, пропускаются. Возвращает транзакцию с мета-данными, которые она получила бы
+// в блоке с меткой времени timestamp, или ошибку леджера.
+func (confirmer *ConfirmerLegacy) Simulate(pending []umi.Transaction, transaction umi.Transaction,
+	timestamp uint32) (umi.Transaction, error) {
+	confirmer.Lock()
+	defer confirmer.Unlock()
+
+	confirmer.ResetState()
+	confirmer.BlockHeight++
+
+	if timestamp > confirmer.BlockTimestamp {
+		confirmer.BlockTimestamp = timestamp
+	}
+
+	handlers := confirmer.legacyHandlers()
+	hash := transaction.Hash()
+
+	for _, tx := range pending {
+		if tx.Hash() == hash {
+			continue
+		}
+
+		_, _ = confirmer.isolate(func() (bool, error) {
+			_, err := confirmer.simulateTransaction(handlers, tx)
+
+			return err == nil, err
+		})
+	}
+
+	return confirmer.simulateTransaction(handlers, transaction)
+}
+
+func (confirmer *ConfirmerLegacy) simulateTransaction(handlers map[string]func(umi.Transaction) (umi.Transaction, error),
+	transaction umi.Transaction) (umi.Transaction, error) {
+	hash := transaction.Hash()
+
+	if confirmer.ledger.HasTransaction(hash) {
+		return nil, ErrTxConfirmed
+	}
+
+	for _, txHash := range confirmer.txHashes {
+		if txHash == hash {
+			return nil, ErrTxConfirmed
+		}
+	}
+
+	handler, ok := handlers[transaction.Type()]
+	if !ok {
+		return nil, errUnsupportedTxType
+	}
+
+	confirmed := make(umi.Transaction, umi.TxConfirmedLength)
+	copy(confirmed, transaction[:umi.TxLength])
+
+	confirmer.TransactionHeight++
+
+	confirmed.SetBlockTimestamp(confirmer.BlockTimestamp)
+	confirmed.SetBlockHeight(confirmer.BlockHeight)
+	confirmed.SetBlockTransactionIndex(len(confirmer.txHashes))
+	confirmed.SetTransactionHeight(confirmer.TransactionHeight)
+
+	confirmed, err := handler(confirmed)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmer.txHashes = append(confirmer.txHashes, hash)
+
+	return confirmed, nil
+}
@@ -0,0 +1,77 @@
+package ledger
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestConfirmerLegacy_Simulate(t *testing.T) {
+	t.Parallel()
+
+	ledger := newTestLedger(t, config.DefaultConfig())
+	simulator := NewConfirmerLegacy(ledger)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit1 := newTestAddress(umi.PfxVerRoy, 11)
+	deposit2 := newTestAddress(umi.PfxVerRoy, 12)
+	timestamp := uint32(firstJun2020 + 100)
+
+	before := copyTestState(ledger)
+
+	pending := []umi.Transaction{
+		newTestTransaction(umi.TxV8Send, master, deposit1, 1_000_00, 2),
+		newTestTransaction(umi.TxV8Send, deposit1, deposit2, 200_00, 3),
+	}
+
+	// Транзакции, не прошедшие проверку, пропускаются и не оставляют изменений: у deposit2
+	// недостаточно средств, а при списании уже увеличен счетчик транзакций.
+	failing := []umi.Transaction{
+		pending[0],
+		newTestTransaction(umi.TxV8Send, deposit2, master, 5_000_00, 4),
+		pending[1],
+		newTestTransaction(umi.TxV8Send, master, deposit1, 1, 0xFFFF),
+	}
+	failing[3].SetVersion(0xFF)
+
+	transaction := newTestTransaction(umi.TxV8Send, deposit2, deposit1, 100_00, 5)
+
+	want, err := simulator.Simulate(pending, transaction, timestamp)
+	if err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	got, err := simulator.Simulate(failing, transaction, timestamp)
+	if err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Error("неподходящие транзакции мемпула повлияли на результат")
+	}
+
+	if count := got.SenderAccountTransactionCount(); count != 2 {
+		t.Errorf("expected 2, got %d", count)
+	}
+
+	if got.BlockTimestamp() != timestamp || got.BlockHeight() != ledger.LastBlockHeight+1 {
+		t.Errorf("неверные мета-данные блока: %d, %d", got.BlockTimestamp(), got.BlockHeight())
+	}
+
+	if _, err := simulator.Simulate(nil, transaction, timestamp); !errors.Is(err, errNotFound) &&
+		!errors.Is(err, errInsufficientFunds) {
+		t.Errorf("без транзакций мемпула у отправителя нет средств, получили '%v'", err)
+	}
+
+	// Транзакция, совпадающая с транзакцией мемпула, не применяется дважды.
+	if _, err := simulator.Simulate(pending, pending[1], timestamp); err != nil {
+		t.Errorf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if after := copyTestState(ledger); !reflect.DeepEqual(before, after) {
+		t.Error("симуляция изменила состояние леджера")
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+const skipListMaxLevel = 24
+
+// skipList — упорядоченный по убыванию ключа список адресов с доступом по номеру позиции.
+// Каждая ссылка хранит количество узлов, которые она перепрыгивает, поэтому вставка, удаление
+// и поиск по позиции выполняются за O(log n).
+type skipList struct {
+	head   *skipNode
+	level  int
+	length int
+	seed   uint64
+}
+
+type skipNode struct {
+	key     float64
+	address umi.Address
+	next    []*skipNode
+	span    []int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head: &skipNode{
+			next: make([]*skipNode, skipListMaxLevel),
+			span: make([]int, skipListMaxLevel),
+		},
+		level: 1,
+		seed:  0x9E3779B97F4A7C15,
+	}
+}
+
+// before сообщает, должен ли узел стоять перед элементом с ключом key и адресом address.
+// При равных ключах элементы упорядочены по адресу.
+func (node *skipNode) before(key float64, address umi.Address) bool {
+	if node.key != key {
+		return node.key > key
+	}
+
+	for i := range node.address {
+		if node.address[i] != address[i] {
+			return node.address[i] < address[i]
+		}
+	}
+
+	return false
+}
+
+func (list *skipList) randomLevel() int {
+	level := 1
+
+	for level < skipListMaxLevel {
+		// xorshift64
+		list.seed ^= list.seed << 13
+		list.seed ^= list.seed >> 7
+		list.seed ^= list.seed << 17
+
+		if list.seed&3 != 0 {
+			break
+		}
+
+		level++
+	}
+
+	return level
+}
+
+func (list *skipList) insert(key float64, address umi.Address) {
+	var (
+		update [skipListMaxLevel]*skipNode
+		rank   [skipListMaxLevel]int
+	)
+
+	node := list.head
+
+	for i := list.level - 1; i >= 0; i-- {
+		if i < list.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for node.next[i] != nil && node.next[i].before(key, address) {
+			rank[i] += node.span[i]
+			node = node.next[i]
+		}
+
+		update[i] = node
+	}
+
+	level := list.randomLevel()
+
+	if level > list.level {
+		for i := list.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = list.head
+			update[i].span[i] = list.length
+		}
+
+		list.level = level
+	}
+
+	node = &skipNode{
+		key:     key,
+		address: address,
+		next:    make([]*skipNode, level),
+		span:    make([]int, level),
+	}
+
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+
+		node.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
+	}
+
+	for i := level; i < list.level; i++ {
+		update[i].span[i]++
+	}
+
+	list.length++
+}
+
+func (list *skipList) remove(key float64, address umi.Address) bool {
+	var update [skipListMaxLevel]*skipNode
+
+	node := list.head
+
+	for i := list.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].before(key, address) {
+			node = node.next[i]
+		}
+
+		update[i] = node
+	}
+
+	node = node.next[0]
+
+	if node == nil || node.key != key || node.address != address {
+		return false
+	}
+
+	for i := 0; i < list.level; i++ {
+		if update[i].next[i] == node {
+			update[i].span[i] += node.span[i] - 1
+			update[i].next[i] = node.next[i]
+		} else {
+			update[i].span[i]--
+		}
+	}
+
+	for list.level > 1 && list.head.next[list.level-1] == nil {
+		list.level--
+	}
+
+	list.length--
+
+	return true
+}
+
+// at возвращает узел на позиции rank (с нуля) или nil.
+func (list *skipList) at(rank int) *skipNode {
+	if rank < 0 || rank >= list.length {
+		return nil
+	}
+
+	node := list.head
+	traversed := -1
+
+	for i := list.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && traversed+node.span[i] <= rank {
+			traversed += node.span[i]
+			node = node.next[i]
+		}
+
+		if traversed == rank {
+			return node
+		}
+	}
+
+	return nil
+}
@@ -35,6 +35,8 @@ func (confirmer *Confirmer) stopStaking() {
 			continue
 		}
 
+		confirmer.ledger.saveStructure(pfx)
+
 		structure.Balance = structure.BalanceAt(confirmer.BlockTimestamp)
 		structure.UpdatedAt = confirmer.BlockTimestamp
 		structure.Level = 0
@@ -94,6 +96,8 @@ func (confirmer *Confirmer) checkGlize() {
 
 		if totalGls >= levels[lvl].balance {
 			if structure.LevelInterestRate != newInterestRate {
+				confirmer.ledger.saveStructure(umi.PfxVerGls)
+
 				structure.Balance = totalGls
 				structure.UpdatedAt = confirmer.BlockTimestamp
 				structure.Level = newLevel
@@ -137,6 +141,8 @@ func (confirmer *Confirmer) checkStructureLevel() {
 
 			if balance >= levels[lvl].balance {
 				if structure.Level != newLevel {
+					confirmer.ledger.saveStructure(pfx)
+
 					structure.Balance = balance
 					structure.UpdatedAt = timestamp
 					structure.Level = newLevel
@@ -155,7 +161,17 @@ func (confirmer *Confirmer) updateLevelAddresses(pfx umi.Prefix) {
 	timestamp := confirmer.BlockTimestamp
 	structure := confirmer.ledger.structures[pfx]
 
-	for _, acc := range confirmer.ledger.accounts[pfx] {
+	for addr, acc := range confirmer.ledger.accounts[pfx] {
+		confirmer.ledger.saveAccount(addr)
 		acc.SetInterestRate(structure.InterestRate(acc.Type), timestamp)
 	}
+
+	// Ставки меняются без транзакций адресов, поэтому для расчета исторических балансов
+	// пересчет нужно запомнить.
+	confirmer.ledger.addRateChange(pfx, RateChange{
+		Height:            confirmer.BlockHeight,
+		Timestamp:         timestamp,
+		LevelInterestRate: structure.LevelInterestRate,
+		ProfitPercent:     structure.ProfitPercent,
+	})
 }
@@ -0,0 +1,631 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+const (
+	snapshotMagic   = "UMILEDGR"
+	snapshotVersion = 4
+	snapshotPrefix  = "ledger-"
+	snapshotKeep    = 2
+)
+
+var ErrSnapshot = errors.New("snapshot")
+
+// Worker периодически сохраняет снимок леджера на диск.
+func (ledger *Ledger) Worker(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ledger.RLock()
+			height, snapshotHeight := ledger.LastBlockHeight, ledger.snapshotHeight
+			ledger.RUnlock()
+
+			if height >= snapshotHeight && height-snapshotHeight < uint32(ledger.config.SnapshotInterval) {
+				continue
+			}
+
+			if err := ledger.WriteSnapshot(); err != nil {
+				log.Printf("ledger: не удалось сохранить снимок: %v", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WriteSnapshot сохраняет снимок текущего состояния леджера. Файл сначала пишется во временный,
+// а затем переименовывается, поэтому на диске не может оказаться наполовину записанный снимок.
+func (ledger *Ledger) WriteSnapshot() error {
+	dir := ledger.snapshotDir()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	file, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	defer os.Remove(file.Name())
+
+	// Под блокировкой только копируем состояние, чтобы запись снимка не задерживала Commit.
+	ledger.RLock()
+	state := ledger.copyState()
+	ledger.RUnlock()
+
+	height := state.LastBlockHeight
+	err = state.encodeSnapshot(file)
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	name := path.Join(dir, fmt.Sprintf("%s%010d", snapshotPrefix, height))
+
+	if err := os.Rename(file.Name(), name); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	ledger.Lock()
+	ledger.snapshotHeight = height
+	ledger.Unlock()
+
+	log.Printf("ledger: сохранен снимок на высоте %d", height)
+
+	ledger.removeOldSnapshots(height)
+
+	return nil
+}
+
+// LoadSnapshot загружает самый свежий неповрежденный снимок. Возвращает высоту снимка
+// или 0, если подходящего снимка нет. Снимок обязательно нужно сверить с блокчейном.
+func (ledger *Ledger) LoadSnapshot() uint32 {
+	for _, height := range ledger.snapshots() {
+		name := path.Join(ledger.snapshotDir(), fmt.Sprintf("%s%010d", snapshotPrefix, height))
+
+		if err := ledger.loadSnapshot(name); err != nil {
+			log.Printf("ledger: снимок %s поврежден: %v", name, err)
+
+			continue
+		}
+
+		ledger.Lock()
+		ledger.snapshotHeight = height
+		ledger.Unlock()
+
+		return height
+	}
+
+	return 0
+}
+
+// Reset возвращает леджер в начальное состояние.
+func (ledger *Ledger) Reset() {
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	ledger.reset()
+	ledger.snapshotHeight = 0
+}
+
+func (ledger *Ledger) loadSnapshot(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	defer file.Close()
+
+	loaded := NewLedger(ledger.config)
+
+	if err := loaded.decodeSnapshot(file); err != nil {
+		return err
+	}
+
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	ledger.accounts = loaded.accounts
+	ledger.structures = loaded.structures
+	ledger.transactions = loaded.transactions
+	ledger.nfts = loaded.nfts
+	ledger.nftHeights = loaded.nftHeights
+	ledger.totals = loaded.totals
+	ledger.rateChanges = loaded.rateChanges
+	ledger.history = nil
+
+	ledger.LastBlockTimestamp = loaded.LastBlockTimestamp
+	ledger.LastBlockHeight = loaded.LastBlockHeight
+	ledger.LastBlockHash = loaded.LastBlockHash
+	ledger.LastTransactionHeight = loaded.LastTransactionHeight
+
+	ledger.rebuildStateTree()
+	ledger.rebuildRichList()
+	ledger.rebuildNftOwners()
+	ledger.writeStateRoot(ledger.LastBlockHeight, ledger.stateTree.root())
+
+	return nil
+}
+
+func (ledger *Ledger) snapshotDir() string {
+	return path.Join(ledger.config.DataDir, ledger.config.Network, "snapshots")
+}
+
+// snapshots возвращает высоты сохраненных снимков, начиная с самого свежего.
+func (ledger *Ledger) snapshots() []uint32 {
+	entries, err := os.ReadDir(ledger.snapshotDir())
+	if err != nil {
+		return nil
+	}
+
+	heights := make([]uint32, 0, len(entries))
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), snapshotPrefix) {
+			continue
+		}
+
+		height, err := strconv.ParseUint(strings.TrimPrefix(entry.Name(), snapshotPrefix), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		heights = append(heights, uint32(height))
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] > heights[j]
+	})
+
+	return heights
+}
+
+// removeOldSnapshots оставляет только последние снимки. Снимки выше текущей высоты
+// остались от отброшенной при откате цепочки и тоже удаляются.
+func (ledger *Ledger) removeOldSnapshots(height uint32) {
+	kept := 0
+
+	for _, h := range ledger.snapshots() {
+		if h <= height && kept < snapshotKeep {
+			kept++
+
+			continue
+		}
+
+		_ = os.Remove(path.Join(ledger.snapshotDir(), fmt.Sprintf("%s%010d", snapshotPrefix, h)))
+	}
+}
+
+// copyState копирует данные, которые попадают в снимок. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) copyState() *Ledger {
+	state := &Ledger{
+		config:       ledger.config,
+		accounts:     make(map[umi.Prefix]map[umi.Address]*Account, len(ledger.accounts)),
+		structures:   make(map[umi.Prefix]*Structure, len(ledger.structures)),
+		transactions: make(map[umi.Hash]struct{}, len(ledger.transactions)),
+		nfts:         make(map[umi.Hash]umi.Address, len(ledger.nfts)),
+		nftHeights:   make(map[uint64]umi.Hash, len(ledger.nftHeights)),
+		totals:       make(map[umi.Prefix]supplyTotals, len(ledger.totals)),
+		rateChanges:  make(map[umi.Prefix][]RateChange, len(ledger.rateChanges)),
+
+		LastBlockTimestamp:    ledger.LastBlockTimestamp,
+		LastBlockHeight:       ledger.LastBlockHeight,
+		LastBlockHash:         ledger.LastBlockHash,
+		LastTransactionHeight: ledger.LastTransactionHeight,
+	}
+
+	for prefix, accounts := range ledger.accounts {
+		copied := make(map[umi.Address]*Account, len(accounts))
+
+		for address, account := range accounts {
+			clone := *account
+			copied[address] = &clone
+		}
+
+		state.accounts[prefix] = copied
+	}
+
+	for prefix, structure := range ledger.structures {
+		clone := *structure
+		state.structures[prefix] = &clone
+	}
+
+	for hash := range ledger.transactions {
+		state.transactions[hash] = struct{}{}
+	}
+
+	for hash, owner := range ledger.nfts {
+		state.nfts[hash] = owner
+	}
+
+	for height, hash := range ledger.nftHeights {
+		state.nftHeights[height] = hash
+	}
+
+	for prefix, totals := range ledger.totals {
+		state.totals[prefix] = totals
+	}
+
+	for prefix, changes := range ledger.rateChanges {
+		state.rateChanges[prefix] = append([]RateChange(nil), changes...)
+	}
+
+	return state
+}
+
+// encodeSnapshot пишет снимок. Вызывается для копии леджера, полученной через copyState.
+func (ledger *Ledger) encodeSnapshot(writer io.Writer) error {
+	checksum := sha256.New()
+	buffer := bufio.NewWriter(io.MultiWriter(writer, checksum))
+	enc := &encoder{writer: buffer}
+
+	enc.bytes([]byte(snapshotMagic))
+	enc.uint32(snapshotVersion)
+	enc.uint32(ledger.LastBlockTimestamp)
+	enc.uint32(ledger.LastBlockHeight)
+	enc.bytes(ledger.LastBlockHash[:])
+	enc.uint64(ledger.LastTransactionHeight)
+
+	enc.uint32(uint32(len(ledger.structures)))
+
+	for _, structure := range ledger.structures {
+		enc.structure(structure)
+	}
+
+	count := 0
+
+	for _, accounts := range ledger.accounts {
+		count += len(accounts)
+	}
+
+	enc.uint32(uint32(count))
+
+	for _, accounts := range ledger.accounts {
+		for address, account := range accounts {
+			enc.bytes(address[:])
+			enc.account(account)
+		}
+	}
+
+	enc.uint32(uint32(len(ledger.transactions)))
+
+	for hash := range ledger.transactions {
+		enc.bytes(hash[:])
+	}
+
+	enc.uint32(uint32(len(ledger.nfts)))
+
+	for hash, owner := range ledger.nfts {
+		enc.bytes(hash[:])
+		enc.bytes(owner[:])
+	}
+
+	enc.uint32(uint32(len(ledger.nftHeights)))
+
+	for height, hash := range ledger.nftHeights {
+		enc.uint64(height)
+		enc.bytes(hash[:])
+	}
+
+	enc.uint32(uint32(len(ledger.totals)))
+
+	for prefix, totals := range ledger.totals {
+		enc.uint16(uint16(prefix))
+		enc.uint64(totals.issued)
+		enc.uint64(totals.burned)
+	}
+
+	enc.uint32(uint32(len(ledger.rateChanges)))
+
+	for prefix, changes := range ledger.rateChanges {
+		enc.uint16(uint16(prefix))
+		enc.uint32(uint32(len(changes)))
+
+		for _, change := range changes {
+			enc.uint32(change.Height)
+			enc.uint32(change.Timestamp)
+			enc.uint16(change.LevelInterestRate)
+			enc.uint16(change.ProfitPercent)
+		}
+	}
+
+	if enc.err != nil {
+		return enc.err
+	}
+
+	if err := buffer.Flush(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if _, err := writer.Write(checksum.Sum(nil)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// decodeSnapshot читает снимок в пустой леджер и проверяет контрольную сумму.
+//
+//nolint:funlen // ...
+func (ledger *Ledger) decodeSnapshot(reader io.Reader) error {
+	checksum := sha256.New()
+	dec := &decoder{reader: bufio.NewReader(reader), checksum: checksum}
+
+	if magic := dec.bytes(len(snapshotMagic)); !bytes.Equal(magic, []byte(snapshotMagic)) {
+		return fmt.Errorf("%w: неизвестный формат", ErrSnapshot)
+	}
+
+	if version := dec.uint32(); version != snapshotVersion {
+		return fmt.Errorf("%w: неподдерживаемая версия %d", ErrSnapshot, version)
+	}
+
+	ledger.LastBlockTimestamp = dec.uint32()
+	ledger.LastBlockHeight = dec.uint32()
+	copy(ledger.LastBlockHash[:], dec.bytes(len(umi.Hash{})))
+	ledger.LastTransactionHeight = dec.uint64()
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		structure := dec.structure()
+		ledger.structures[structure.Prefix] = structure
+	}
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		var address umi.Address
+
+		copy(address[:], dec.bytes(umi.AddrLength))
+
+		accounts, ok := ledger.accounts[address.Prefix()]
+		if !ok {
+			accounts = make(map[umi.Address]*Account)
+			ledger.accounts[address.Prefix()] = accounts
+		}
+
+		accounts[address] = dec.account()
+	}
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		var hash umi.Hash
+
+		copy(hash[:], dec.bytes(len(hash)))
+		ledger.transactions[hash] = struct{}{}
+	}
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		var (
+			hash  umi.Hash
+			owner umi.Address
+		)
+
+		copy(hash[:], dec.bytes(len(hash)))
+		copy(owner[:], dec.bytes(len(owner)))
+		ledger.nfts[hash] = owner
+	}
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		var hash umi.Hash
+
+		height := dec.uint64()
+		copy(hash[:], dec.bytes(len(hash)))
+		ledger.nftHeights[height] = hash
+	}
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		prefix := umi.Prefix(dec.uint16())
+
+		ledger.totals[prefix] = supplyTotals{
+			issued: dec.uint64(),
+			burned: dec.uint64(),
+		}
+	}
+
+	for i, n := uint32(0), dec.uint32(); i < n && dec.err == nil; i++ {
+		prefix := umi.Prefix(dec.uint16())
+
+		for j, m := uint32(0), dec.uint32(); j < m && dec.err == nil; j++ {
+			ledger.rateChanges[prefix] = append(ledger.rateChanges[prefix], RateChange{
+				Height:            dec.uint32(),
+				Timestamp:         dec.uint32(),
+				LevelInterestRate: dec.uint16(),
+				ProfitPercent:     dec.uint16(),
+			})
+		}
+	}
+
+	if dec.err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshot, dec.err)
+	}
+
+	expected := checksum.Sum(nil)
+	actual := make([]byte, len(expected))
+
+	if _, err := io.ReadFull(dec.reader, actual); err != nil || !bytes.Equal(expected, actual) {
+		return fmt.Errorf("%w: контрольная сумма не совпадает", ErrSnapshot)
+	}
+
+	return nil
+}
+
+type encoder struct {
+	writer io.Writer
+	err    error
+}
+
+func (enc *encoder) bytes(data []byte) {
+	if enc.err == nil {
+		_, enc.err = enc.writer.Write(data)
+	}
+}
+
+func (enc *encoder) uint8(value uint8) {
+	enc.bytes([]byte{value})
+}
+
+func (enc *encoder) uint16(value uint16) {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, value)
+	enc.bytes(data)
+}
+
+func (enc *encoder) uint32(value uint32) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	enc.bytes(data)
+}
+
+func (enc *encoder) uint64(value uint64) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	enc.bytes(data)
+}
+
+func (enc *encoder) account(account *Account) {
+	enc.uint8(uint8(account.Type))
+	enc.uint64(account.Balance)
+	enc.uint32(account.UpdatedAt)
+	enc.uint16(account.InterestRate)
+	enc.uint64(account.TransactionCount)
+}
+
+func (enc *encoder) structure(structure *Structure) {
+	enc.uint8(uint8(structure.accountType))
+	enc.uint32(structure.CreatedAt)
+	enc.uint16(uint16(structure.Prefix))
+	enc.uint16(uint16(len(structure.Description)))
+	enc.bytes([]byte(structure.Description))
+	enc.uint16(structure.ProfitPercent)
+	enc.uint16(structure.FeePercent)
+	enc.bytes(structure.MasterAddress[:])
+	enc.bytes(structure.FeeAddress[:])
+	enc.bytes(structure.ProfitAddress[:])
+	enc.bytes(structure.DevAddress[:])
+	enc.uint32(uint32(structure.AddressCount))
+	enc.uint64(structure.Balance)
+	enc.uint32(structure.UpdatedAt)
+	enc.uint8(structure.Level)
+	enc.uint16(structure.LevelInterestRate)
+}
+
+type decoder struct {
+	reader   *bufio.Reader
+	checksum hash.Hash
+	err      error
+}
+
+func (dec *decoder) bytes(length int) []byte {
+	data := make([]byte, length)
+
+	if dec.err != nil {
+		return data
+	}
+
+	if _, dec.err = io.ReadFull(dec.reader, data); dec.err == nil {
+		_, _ = dec.checksum.Write(data)
+	}
+
+	return data
+}
+
+func (dec *decoder) uint8() uint8 {
+	return dec.bytes(1)[0]
+}
+
+func (dec *decoder) uint16() uint16 {
+	return binary.BigEndian.Uint16(dec.bytes(2))
+}
+
+func (dec *decoder) uint32() uint32 {
+	return binary.BigEndian.Uint32(dec.bytes(4))
+}
+
+func (dec *decoder) uint64() uint64 {
+	return binary.BigEndian.Uint64(dec.bytes(8))
+}
+
+func (dec *decoder) account() *Account {
+	account := &Account{
+		Type:             umi.AccountType(dec.uint8()),
+		Balance:          dec.uint64(),
+		UpdatedAt:        dec.uint32(),
+		InterestRate:     dec.uint16(),
+		TransactionCount: dec.uint64(),
+	}
+
+	account.updateGrowthRate()
+
+	return account
+}
+
+func (dec *decoder) structure() *Structure {
+	structure := &Structure{
+		accountType: umi.AccountType(dec.uint8()),
+		CreatedAt:   dec.uint32(),
+		Prefix:      umi.Prefix(dec.uint16()),
+	}
+
+	structure.Description = string(dec.bytes(int(dec.uint16())))
+	structure.ProfitPercent = dec.uint16()
+	structure.FeePercent = dec.uint16()
+	copy(structure.MasterAddress[:], dec.bytes(umi.AddrLength))
+	copy(structure.FeeAddress[:], dec.bytes(umi.AddrLength))
+	copy(structure.ProfitAddress[:], dec.bytes(umi.AddrLength))
+	copy(structure.DevAddress[:], dec.bytes(umi.AddrLength))
+	structure.AddressCount = int(dec.uint32())
+	structure.Balance = dec.uint64()
+	structure.UpdatedAt = dec.uint32()
+	structure.Level = dec.uint8()
+	structure.LevelInterestRate = dec.uint16()
+
+	return structure
+}
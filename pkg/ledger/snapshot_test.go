@@ -0,0 +1,82 @@
+package ledger
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestLedger_SnapshotRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	ledger := newTestLedger(t, conf)
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit := newTestAddress(umi.PfxVerRoy, 11)
+
+	issue := newTestTransaction(umi.TxV16Issue, master, deposit, 1_000, 3)
+	issue.SetPrefix(umi.PfxVerRoy)
+
+	commitTestBlock(t, ledger, firstJun2020+20,
+		newTestTransaction(umi.TxV8Send, master, deposit, 3_000_000_00, 2), issue)
+
+	if err := ledger.WriteSnapshot(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	saved := copyTestState(ledger)
+	savedRoot := ledger.stateTree.root()
+
+	commitTestBlock(t, ledger, firstJun2020+86400, newTestTransaction(umi.TxV15Burn, deposit, deposit, 100, 4))
+
+	if err := ledger.WriteSnapshot(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	latest := copyTestState(ledger)
+
+	loaded := NewLedger(conf)
+
+	if height := loaded.LoadSnapshot(); height != 4 {
+		t.Fatalf("expected %d, got %d", 4, height)
+	}
+
+	if !reflect.DeepEqual(latest, copyTestState(loaded)) {
+		t.Error("состояние из снимка отличается от сохраненного")
+	}
+
+	// Поврежденный снимок пропускается, загружается предыдущий.
+	name := path.Join(ledger.snapshotDir(), fmt.Sprintf("%s%010d", snapshotPrefix, 4))
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)/2] ^= 0xFF
+
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = NewLedger(conf)
+
+	if height := loaded.LoadSnapshot(); height != 3 {
+		t.Fatalf("expected %d, got %d", 3, height)
+	}
+
+	if !reflect.DeepEqual(saved, copyTestState(loaded)) {
+		t.Error("состояние из снимка отличается от сохраненного")
+	}
+
+	if root := loaded.stateTree.root(); root != savedRoot {
+		t.Errorf("expected %x, got %x", savedRoot, root)
+	}
+}
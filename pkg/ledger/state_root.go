@@ -0,0 +1,308 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// Аккаунты и структуры раскладываются по корзинам по первым двум байтам хэша ключа. Хэш корзины
+// считается по отсортированным записям, а корень состояния — бинарным деревом Меркла над всеми
+// корзинами. После блока пересчитываются только корзины измененных записей и пути от них до корня.
+const (
+	stateBuckets  = 1 << 16
+	stateRootSize = 32
+)
+
+const (
+	stateAccount   uint8 = 1
+	stateStructure uint8 = 2
+)
+
+type stateTree struct {
+	buckets []map[string]umi.Hash
+	nodes   []umi.Hash
+	dirty   map[int]struct{}
+}
+
+func newStateTree() *stateTree {
+	tree := &stateTree{
+		buckets: make([]map[string]umi.Hash, stateBuckets),
+		nodes:   make([]umi.Hash, 2*stateBuckets),
+		dirty:   make(map[int]struct{}),
+	}
+
+	// Все узлы одного уровня пустого дерева одинаковы.
+	for width := stateBuckets / 2; width > 0; width /= 2 {
+		node := hashPair(tree.nodes[2*width], tree.nodes[2*width+1])
+
+		for i := width; i < 2*width; i++ {
+			tree.nodes[i] = node
+		}
+	}
+
+	return tree
+}
+
+// set записывает хэш записи. Пустой хэш удаляет запись.
+func (tree *stateTree) set(key []byte, leaf umi.Hash) {
+	keyHash := sha256.Sum256(key)
+	bucket := int(binary.BigEndian.Uint16(keyHash[0:2]))
+
+	if leaf == (umi.Hash{}) {
+		delete(tree.buckets[bucket], string(key))
+	} else {
+		if tree.buckets[bucket] == nil {
+			tree.buckets[bucket] = make(map[string]umi.Hash, 1)
+		}
+
+		tree.buckets[bucket][string(key)] = leaf
+	}
+
+	tree.dirty[bucket] = struct{}{}
+}
+
+// root пересчитывает измененные корзины и возвращает корень дерева.
+func (tree *stateTree) root() umi.Hash {
+	for bucket := range tree.dirty {
+		node := stateBuckets + bucket
+		tree.nodes[node] = tree.bucketHash(bucket)
+
+		for node > 1 {
+			node /= 2
+			tree.nodes[node] = hashPair(tree.nodes[2*node], tree.nodes[2*node+1])
+		}
+	}
+
+	tree.dirty = make(map[int]struct{})
+
+	return tree.nodes[1]
+}
+
+func (tree *stateTree) bucketHash(bucket int) umi.Hash {
+	entries := tree.buckets[bucket]
+
+	if len(entries) == 0 {
+		return umi.Hash{}
+	}
+
+	keys := make([]string, 0, len(entries))
+
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	data := make([]byte, 0, len(keys)*sha256.Size)
+
+	for _, key := range keys {
+		leaf := entries[key]
+		data = append(data, leaf[:]...)
+	}
+
+	return sha256.Sum256(data)
+}
+
+func hashPair(left, right umi.Hash) umi.Hash {
+	data := make([]byte, 0, 2*sha256.Size)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+
+	return sha256.Sum256(data)
+}
+
+func accountKey(address umi.Address) []byte {
+	return append([]byte{stateAccount}, address[:]...)
+}
+
+func structureKey(prefix umi.Prefix) []byte {
+	key := []byte{stateStructure, 0, 0}
+	binary.BigEndian.PutUint16(key[1:3], uint16(prefix))
+
+	return key
+}
+
+// stateLeaf хэширует ключ вместе с состоянием в том же виде, в котором оно пишется в снимок.
+func stateLeaf(key []byte, encode func(enc *encoder)) umi.Hash {
+	buffer := new(bytes.Buffer)
+	buffer.Write(key)
+
+	encode(&encoder{writer: buffer})
+
+	return sha256.Sum256(buffer.Bytes())
+}
+
+// rebuildStateTree строит дерево состояния заново. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) rebuildStateTree() {
+	ledger.stateTree = newStateTree()
+
+	for prefix := range ledger.structures {
+		ledger.setStructureLeaf(prefix)
+	}
+
+	for _, accounts := range ledger.accounts {
+		for address := range accounts {
+			ledger.setAccountLeaf(address)
+		}
+	}
+
+	ledger.stateTree.root()
+}
+
+// updateStateTree пересчитывает записи, затронутые блоком, и сохраняет корень состояния
+// для текущей высоты. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) updateStateTree(changes *journal) umi.Hash {
+	for address := range changes.accounts {
+		ledger.setAccountLeaf(address)
+	}
+
+	for prefix := range changes.structures {
+		ledger.setStructureLeaf(prefix)
+	}
+
+	return ledger.stateTree.root()
+}
+
+func (ledger *Ledger) setAccountLeaf(address umi.Address) {
+	var leaf umi.Hash
+
+	key := accountKey(address)
+
+	if account, ok := ledger.accounts[address.Prefix()][address]; ok {
+		leaf = stateLeaf(key, func(enc *encoder) { enc.account(account) })
+	}
+
+	ledger.stateTree.set(key, leaf)
+}
+
+func (ledger *Ledger) setStructureLeaf(prefix umi.Prefix) {
+	var leaf umi.Hash
+
+	key := structureKey(prefix)
+
+	if structure, ok := ledger.structures[prefix]; ok {
+		leaf = stateLeaf(key, func(enc *encoder) { enc.structure(structure) })
+	}
+
+	ledger.stateTree.set(key, leaf)
+}
+
+// OpenStateRoots открывает файл, в котором хранятся корни состояния для каждой высоты.
+// Без него доступен только корень состояния последнего блока.
+func (ledger *Ledger) OpenStateRoots() error {
+	dir := path.Join(ledger.config.DataDir, ledger.config.Network)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	file, err := os.OpenFile(path.Join(dir, "stateroots"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	ledger.stateRoots = file
+
+	return nil
+}
+
+// CloseStateRoots закрывает файл с корнями состояния.
+func (ledger *Ledger) CloseStateRoots() {
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	if ledger.stateRoots != nil {
+		_ = ledger.stateRoots.Close()
+		ledger.stateRoots = nil
+	}
+}
+
+// StateRoot возвращает корень состояния леджера после блока на указанной высоте.
+func (ledger *Ledger) StateRoot(height uint32) (root umi.Hash, ok bool) {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	if height == 0 || height > ledger.LastBlockHeight {
+		return root, false
+	}
+
+	if height == ledger.LastBlockHeight {
+		return ledger.stateTree.nodes[1], true
+	}
+
+	if ledger.stateRoots == nil {
+		return root, false
+	}
+
+	if _, err := ledger.stateRoots.ReadAt(root[:], int64(height-1)*stateRootSize); err != nil {
+		return root, false
+	}
+
+	return root, root != (umi.Hash{})
+}
+
+// writeStateRoot сохраняет корень состояния для высоты. Корни откаченных блоков стираются,
+// поэтому если для высоты уже сохранен другой корень, значит одни и те же блоки дали разное
+// состояние, и это пишется в лог. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) writeStateRoot(height uint32, root umi.Hash) {
+	if ledger.stateRoots == nil || height == 0 {
+		return
+	}
+
+	offset := int64(height-1) * stateRootSize
+
+	var stored umi.Hash
+
+	if _, err := ledger.stateRoots.ReadAt(stored[:], offset); err == nil && stored != (umi.Hash{}) && stored != root {
+		log.Printf("ledger: корень состояния на высоте %d не совпадает: сохранен %s, вычислен %s",
+			height, stored, root)
+	}
+
+	if _, err := ledger.stateRoots.WriteAt(root[:], offset); err != nil {
+		log.Printf("ledger: не удалось сохранить корень состояния: %v", err)
+	}
+}
+
+// eraseStateRoots стирает корни состояния откаченных блоков. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) eraseStateRoots(from, to uint32) {
+	if ledger.stateRoots == nil || from > to {
+		return
+	}
+
+	data := make([]byte, int(to-from+1)*stateRootSize)
+
+	if _, err := ledger.stateRoots.WriteAt(data, int64(from-1)*stateRootSize); err != nil {
+		log.Printf("ledger: не удалось стереть корни состояния: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package ledger
+
+import (
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestLedger_StateRoot(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.ReorgDepth = 3
+
+	ledger := newTestLedger(t, conf)
+
+	if err := ledger.OpenStateRoots(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+	defer ledger.CloseStateRoots()
+
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit := newTestAddress(umi.PfxVerRoy, 11)
+
+	root2, _ := ledger.StateRoot(2)
+
+	commitTestBlock(t, ledger, firstJun2020+20, newTestTransaction(umi.TxV8Send, master, deposit, 1_000_00, 2))
+	root3, _ := ledger.StateRoot(3)
+
+	block4 := []umi.Transaction{newTestTransaction(umi.TxV8Send, master, deposit, 2_000_00, 3)}
+	commitTestBlock(t, ledger, firstJun2020+30, block4...)
+	root4, _ := ledger.StateRoot(4)
+
+	if root2 == root3 || root3 == root4 {
+		t.Fatal("корень состояния должен меняться вместе с состоянием")
+	}
+
+	// Корни прошлых блоков читаются из файла.
+	if root, ok := ledger.StateRoot(3); !ok || root != root3 {
+		t.Errorf("expected %x, got %x", root3, root)
+	}
+
+	if _, ok := ledger.StateRoot(5); ok {
+		t.Error("корня для блока выше последнего нет, must return false")
+	}
+
+	// Инкрементальное обновление дает тот же корень, что и построение дерева заново.
+	ledger.rebuildStateTree()
+
+	if root, _ := ledger.StateRoot(4); root != root4 {
+		t.Errorf("expected %x, got %x", root4, root)
+	}
+
+	if err := ledger.Rewind(3); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if root, ok := ledger.StateRoot(3); !ok || root != root3 {
+		t.Errorf("после отката ожидаем корень %x, получили %x", root3, root)
+	}
+
+	if _, ok := ledger.StateRoot(4); ok {
+		t.Error("корень откаченного блока, must return false")
+	}
+
+	// Другой блок на той же высоте дает другой корень.
+	commitTestBlock(t, ledger, firstJun2020+30, newTestTransaction(umi.TxV8Send, master, deposit, 3_000_00, 3))
+
+	if root, _ := ledger.StateRoot(4); root == root4 {
+		t.Error("корень другого блока на той же высоте должен отличаться")
+	}
+
+	if err := ledger.Rewind(3); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	commitTestBlock(t, ledger, firstJun2020+30, block4...)
+
+	if root, _ := ledger.StateRoot(4); root != root4 {
+		t.Errorf("повторное подтверждение блока: expected %x, got %x", root4, root)
+	}
+
+	commitTestBlock(t, ledger, firstJun2020+40, newTestTransaction(umi.TxV8Send, master, deposit, 4_000_00, 4))
+
+	if root, ok := ledger.StateRoot(4); !ok || root != root4 {
+		t.Errorf("expected %x, got %x", root4, root)
+	}
+}
@@ -63,6 +63,11 @@ func NewStructure(network string, prefix umi.Prefix, masterAddr umi.Address) *St
 	}
 }
 
+// AccountType возвращает тип, с которым создаются новые аккаунты структуры.
+func (structure *Structure) AccountType() umi.AccountType {
+	return structure.accountType
+}
+
 func (structure *Structure) IsOwner(addr umi.Address) bool {
 	return structure.MasterAddress == addr
 }
@@ -0,0 +1,572 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ledger
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// История эмиссии хранится в двух файлах. В supply для каждого блока пишется запись с состоянием
+// структур, измененных в блоке, а в supplyindex — смещение конца записи (8 байт на высоту).
+// Каждые supplyFullInterval блоков записывается полный срез всех структур, поэтому для расчета
+// статистики на любую высоту достаточно прочитать записи от ближайшего полного среза.
+const (
+	supplyFullInterval = 10_000
+	supplyHeaderSize   = 7
+	supplyEntrySize    = 76
+	supplyIndexSize    = 8
+)
+
+const (
+	supplyFull    uint8 = 1
+	supplyUnknown uint8 = 2
+)
+
+var ErrSupply = errors.New("supply")
+
+// supplyTotals — выпущенные и сожженные структурой монеты.
+type supplyTotals struct {
+	issued uint64
+	burned uint64
+}
+
+// SupplyStats — статистика эмиссии структуры. Балансы Profit и Dev указаны без учета
+// включенных в них балансов структуры и Profit.
+type SupplyStats struct {
+	Prefix      string `json:"prefix"`
+	Circulating uint64 `json:"circulating"`
+	Issued      uint64 `json:"issued"`
+	Burned      uint64 `json:"burned"`
+	Deposits    uint64 `json:"deposits"`
+	Profit      uint64 `json:"profit"`
+	Dev         uint64 `json:"dev"`
+	Fee         uint64 `json:"fee"`
+}
+
+// Supply — статистика эмиссии по всем структурам на высоте блока.
+type Supply struct {
+	Height      uint32         `json:"height"`
+	Timestamp   uint32         `json:"timestamp"`
+	Circulating uint64         `json:"circulating"`
+	Issued      uint64         `json:"issued"`
+	Burned      uint64         `json:"burned"`
+	Profit      uint64         `json:"profit"`
+	Dev         uint64         `json:"dev"`
+	Fee         uint64         `json:"fee"`
+	Gls         uint64         `json:"gls"`
+	Glz         uint64         `json:"glz"`
+	Structures  []*SupplyStats `json:"structures"`
+}
+
+// supplyEntry — состояние структуры, достаточное для расчета статистики на любой момент после блока.
+type supplyEntry struct {
+	prefix    umi.Prefix
+	totals    supplyTotals
+	structure Structure
+	profit    Account
+	dev       Account
+	fee       Account
+}
+
+// OpenSupplyHistory открывает файлы с историей эмиссии. Без них доступна только статистика
+// последнего блока.
+func (ledger *Ledger) OpenSupplyHistory() error {
+	dir := path.Join(ledger.config.DataDir, ledger.config.Network)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	data, err := os.OpenFile(path.Join(dir, "supply"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	index, err := os.OpenFile(path.Join(dir, "supplyindex"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		_ = data.Close()
+
+		return fmt.Errorf("%w", err)
+	}
+
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	ledger.supplyData = data
+	ledger.supplyIndex = index
+
+	return nil
+}
+
+// CloseSupplyHistory закрывает файлы с историей эмиссии.
+func (ledger *Ledger) CloseSupplyHistory() {
+	ledger.Lock()
+	defer ledger.Unlock()
+
+	if ledger.supplyData != nil {
+		_ = ledger.supplyData.Close()
+		_ = ledger.supplyIndex.Close()
+		ledger.supplyData = nil
+		ledger.supplyIndex = nil
+	}
+}
+
+// Supply возвращает статистику эмиссии после блока на указанной высоте. Высота 0 означает последний блок.
+func (ledger *Ledger) Supply(height uint32) (*Supply, error) {
+	ledger.RLock()
+	defer ledger.RUnlock()
+
+	if height == 0 || height == ledger.LastBlockHeight {
+		entries := make(map[umi.Prefix]*supplyEntry, len(ledger.structures))
+
+		for prefix := range ledger.structures {
+			entries[prefix] = ledger.supplyEntry(prefix)
+		}
+
+		return newSupply(ledger.LastBlockHeight, ledger.LastBlockTimestamp, entries), nil
+	}
+
+	if height > ledger.LastBlockHeight {
+		return nil, fmt.Errorf("%w: блок %d не найден", ErrSupply, height)
+	}
+
+	timestamp, entries, err := ledger.readSupply(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSupply(height, timestamp, entries), nil
+}
+
+func newSupply(height, timestamp uint32, entries map[umi.Prefix]*supplyEntry) *Supply {
+	supply := &Supply{
+		Height:     height,
+		Timestamp:  timestamp,
+		Structures: make([]*SupplyStats, 0, len(entries)),
+	}
+
+	prefixes := make([]umi.Prefix, 0, len(entries))
+
+	for prefix := range entries {
+		prefixes = append(prefixes, prefix)
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i] < prefixes[j] })
+
+	for _, prefix := range prefixes {
+		stats := entries[prefix].stats(timestamp)
+
+		supply.Circulating += stats.Circulating
+		supply.Issued += stats.Issued
+		supply.Burned += stats.Burned
+		supply.Profit += stats.Profit
+		supply.Dev += stats.Dev
+		supply.Fee += stats.Fee
+
+		// Эти же суммы используются в Confirmer.checkGlize.
+		switch prefix {
+		case umi.PfxVerGls:
+			supply.Gls = stats.Deposits
+		case umi.PfxVerGlz:
+			supply.Glz = stats.Deposits
+		}
+
+		supply.Structures = append(supply.Structures, stats)
+	}
+
+	return supply
+}
+
+// supplyEntry возвращает текущее состояние структуры. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) supplyEntry(prefix umi.Prefix) *supplyEntry {
+	structure := ledger.structures[prefix]
+
+	entry := &supplyEntry{
+		prefix:    prefix,
+		totals:    ledger.totals[prefix],
+		structure: *structure,
+	}
+
+	if prefix == umi.PfxVerUmi {
+		return entry
+	}
+
+	if account, ok := ledger.journalAccount(nil, structure.ProfitAddress); ok {
+		entry.profit = *account
+	}
+
+	if account, ok := ledger.journalAccount(nil, structure.DevAddress); ok {
+		entry.dev = *account
+	}
+
+	// Если адреса Fee и Profit совпадают, комиссии уже учтены в балансе Profit.
+	if structure.FeeAddress != structure.ProfitAddress {
+		if account, ok := ledger.journalAccount(nil, structure.FeeAddress); ok {
+			entry.fee = *account
+		}
+	}
+
+	return entry
+}
+
+func (entry *supplyEntry) stats(timestamp uint32) *SupplyStats {
+	deposits := entry.structure.BalanceAt(timestamp)
+	profit := entry.profit.BalanceAt(timestamp)
+	dev := entry.dev.BalanceAt(timestamp)
+
+	stats := &SupplyStats{
+		Prefix:   entry.prefix.String(),
+		Issued:   entry.totals.issued,
+		Burned:   entry.totals.burned,
+		Deposits: deposits,
+		Fee:      entry.fee.BalanceAt(timestamp),
+	}
+
+	// Баланс Profit включает в себя баланс структуры, а баланс Dev — баланс Profit.
+	if profit > deposits {
+		stats.Profit = profit - deposits
+	}
+
+	if dev > profit {
+		stats.Dev = dev - profit
+	}
+
+	stats.Circulating = stats.Deposits + stats.Profit + stats.Dev + stats.Fee
+
+	return stats
+}
+
+// addTotals фиксирует выпущенные и сожженные в блоке монеты. Вызывается только под блокировкой леджера.
+func (ledger *Ledger) addTotals(totals map[umi.Prefix]supplyTotals) {
+	for prefix, delta := range totals {
+		ledger.saveTotals(prefix)
+
+		current := ledger.totals[prefix]
+		current.issued += delta.issued
+		current.burned += delta.burned
+		ledger.totals[prefix] = current
+	}
+}
+
+// writeSupply дописывает в историю эмиссии запись для последнего блока. Записи откаченных блоков
+// перезаписываются, а пропущенные высоты (например, после загрузки снимка) помечаются как неизвестные.
+// Вызывается только под блокировкой леджера.
+func (ledger *Ledger) writeSupply(changes *journal) {
+	if ledger.supplyIndex == nil || ledger.LastBlockHeight == 0 {
+		return
+	}
+
+	height := ledger.LastBlockHeight
+
+	count, err := ledger.supplyCount()
+	if err != nil {
+		log.Printf("ledger: не удалось прочитать историю эмиссии: %v", err)
+
+		return
+	}
+
+	if count >= height {
+		if err := ledger.truncateSupply(height - 1); err != nil {
+			log.Printf("ledger: не удалось обрезать историю эмиссии: %v", err)
+
+			return
+		}
+
+		count = height - 1
+	}
+
+	offset, err := ledger.supplyOffset(count)
+	if err != nil {
+		log.Printf("ledger: не удалось прочитать историю эмиссии: %v", err)
+
+		return
+	}
+
+	full := count == 0 || height%supplyFullInterval == 0
+	position := int64(count) * supplyIndexSize
+
+	data := make([]byte, 0)
+	index := make([]byte, 0)
+
+	for ; count < height-1; count++ {
+		data = append(data, encodeSupplyHeader(0, supplyUnknown, 0)...)
+		index = appendUint64(index, offset+uint64(len(data)))
+		full = true
+	}
+
+	prefixes := make([]umi.Prefix, 0)
+
+	if full {
+		for prefix := range ledger.structures {
+			prefixes = append(prefixes, prefix)
+		}
+	} else {
+		for _, prefix := range changedPrefixes(changes) {
+			if _, ok := ledger.structures[prefix]; ok {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+
+		for prefix := range changes.totals {
+			if _, ok := ledger.structures[prefix]; ok && !containsPrefix(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i] < prefixes[j] })
+
+	flags := uint8(0)
+	if full {
+		flags = supplyFull
+	}
+
+	data = append(data, encodeSupplyHeader(ledger.LastBlockTimestamp, flags, len(prefixes))...)
+
+	for _, prefix := range prefixes {
+		data = append(data, ledger.supplyEntry(prefix).encode()...)
+	}
+
+	index = appendUint64(index, offset+uint64(len(data)))
+
+	if _, err := ledger.supplyData.WriteAt(data, int64(offset)); err != nil {
+		log.Printf("ledger: не удалось сохранить историю эмиссии: %v", err)
+
+		return
+	}
+
+	if _, err := ledger.supplyIndex.WriteAt(index, position); err != nil {
+		log.Printf("ledger: не удалось сохранить историю эмиссии: %v", err)
+	}
+}
+
+// readSupply восстанавливает состояние структур после блока на указанной высоте.
+// Вызывается только под блокировкой леджера.
+func (ledger *Ledger) readSupply(height uint32) (uint32, map[umi.Prefix]*supplyEntry, error) {
+	errNoData := fmt.Errorf("%w: нет данных для блока %d", ErrSupply, height)
+
+	if ledger.supplyIndex == nil {
+		return 0, nil, errNoData
+	}
+
+	if count, err := ledger.supplyCount(); err != nil || count < height {
+		return 0, nil, errNoData
+	}
+
+	base := height - height%supplyFullInterval
+	if base == 0 {
+		base = 1
+	}
+
+	start, err := ledger.supplyOffset(base - 1)
+	if err != nil {
+		return 0, nil, errNoData
+	}
+
+	end, err := ledger.supplyOffset(height)
+	if err != nil || end < start {
+		return 0, nil, errNoData
+	}
+
+	data := make([]byte, end-start)
+
+	if _, err := ledger.supplyData.ReadAt(data, int64(start)); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrSupply, err)
+	}
+
+	var (
+		timestamp uint32
+		entries   map[umi.Prefix]*supplyEntry
+	)
+
+	for len(data) > 0 {
+		if len(data) < supplyHeaderSize {
+			return 0, nil, fmt.Errorf("%w: поврежденная запись", ErrSupply)
+		}
+
+		flags := data[4]
+		count := int(binary.BigEndian.Uint16(data[5:7]))
+		timestamp = binary.BigEndian.Uint32(data[0:4])
+		data = data[supplyHeaderSize:]
+
+		switch flags {
+		case supplyUnknown:
+			entries = nil
+		case supplyFull:
+			entries = make(map[umi.Prefix]*supplyEntry, count)
+		}
+
+		if len(data) < count*supplyEntrySize {
+			return 0, nil, fmt.Errorf("%w: поврежденная запись", ErrSupply)
+		}
+
+		for i := 0; i < count; i++ {
+			if entries != nil {
+				entry := decodeSupplyEntry(data[:supplyEntrySize])
+				entries[entry.prefix] = entry
+			}
+
+			data = data[supplyEntrySize:]
+		}
+	}
+
+	if entries == nil {
+		return 0, nil, errNoData
+	}
+
+	return timestamp, entries, nil
+}
+
+// truncateSupply удаляет из истории эмиссии записи выше указанной высоты.
+// Вызывается только под блокировкой леджера.
+func (ledger *Ledger) truncateSupply(height uint32) error {
+	if ledger.supplyIndex == nil {
+		return nil
+	}
+
+	count, err := ledger.supplyCount()
+	if err != nil || count <= height {
+		return err
+	}
+
+	offset, err := ledger.supplyOffset(height)
+	if err != nil {
+		return err
+	}
+
+	if err := ledger.supplyIndex.Truncate(int64(height) * supplyIndexSize); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := ledger.supplyData.Truncate(int64(offset)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// supplyCount возвращает количество блоков в истории эмиссии.
+func (ledger *Ledger) supplyCount() (uint32, error) {
+	info, err := ledger.supplyIndex.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	return uint32(info.Size() / supplyIndexSize), nil
+}
+
+// supplyOffset возвращает смещение конца записи для блока на указанной высоте.
+func (ledger *Ledger) supplyOffset(height uint32) (uint64, error) {
+	if height == 0 {
+		return 0, nil
+	}
+
+	data := make([]byte, supplyIndexSize)
+
+	if _, err := ledger.supplyIndex.ReadAt(data, int64(height-1)*supplyIndexSize); err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func encodeSupplyHeader(timestamp uint32, flags uint8, count int) []byte {
+	data := make([]byte, supplyHeaderSize)
+
+	binary.BigEndian.PutUint32(data[0:4], timestamp)
+	data[4] = flags
+	binary.BigEndian.PutUint16(data[5:7], uint16(count))
+
+	return data
+}
+
+func (entry *supplyEntry) encode() []byte {
+	data := make([]byte, supplyEntrySize)
+
+	binary.BigEndian.PutUint16(data[0:2], uint16(entry.prefix))
+	binary.BigEndian.PutUint64(data[2:10], entry.totals.issued)
+	binary.BigEndian.PutUint64(data[10:18], entry.totals.burned)
+	binary.BigEndian.PutUint64(data[18:26], entry.structure.Balance)
+	binary.BigEndian.PutUint32(data[26:30], entry.structure.UpdatedAt)
+	binary.BigEndian.PutUint16(data[30:32], entry.structure.LevelInterestRate)
+	binary.BigEndian.PutUint16(data[32:34], entry.structure.ProfitPercent)
+
+	for i, account := range []*Account{&entry.profit, &entry.dev, &entry.fee} {
+		offset := 34 + i*14
+
+		binary.BigEndian.PutUint64(data[offset:offset+8], account.Balance)
+		binary.BigEndian.PutUint32(data[offset+8:offset+12], account.UpdatedAt)
+		binary.BigEndian.PutUint16(data[offset+12:offset+14], account.InterestRate)
+	}
+
+	return data
+}
+
+func decodeSupplyEntry(data []byte) *supplyEntry {
+	entry := &supplyEntry{
+		prefix: umi.Prefix(binary.BigEndian.Uint16(data[0:2])),
+		totals: supplyTotals{
+			issued: binary.BigEndian.Uint64(data[2:10]),
+			burned: binary.BigEndian.Uint64(data[10:18]),
+		},
+	}
+
+	entry.structure.Balance = binary.BigEndian.Uint64(data[18:26])
+	entry.structure.UpdatedAt = binary.BigEndian.Uint32(data[26:30])
+	entry.structure.LevelInterestRate = binary.BigEndian.Uint16(data[30:32])
+	entry.structure.ProfitPercent = binary.BigEndian.Uint16(data[32:34])
+
+	for i, account := range []*Account{&entry.profit, &entry.dev, &entry.fee} {
+		offset := 34 + i*14
+
+		account.Balance = binary.BigEndian.Uint64(data[offset : offset+8])
+		account.UpdatedAt = binary.BigEndian.Uint32(data[offset+8 : offset+12])
+		account.InterestRate = binary.BigEndian.Uint16(data[offset+12 : offset+14])
+		account.updateGrowthRate()
+	}
+
+	return entry
+}
+
+func appendUint64(data []byte, value uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, value)
+
+	return append(data, buf...)
+}
+
+func containsPrefix(prefixes []umi.Prefix, prefix umi.Prefix) bool {
+	for _, p := range prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,99 @@
+package ledger
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestLedger_Supply(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.ReorgDepth = 3
+
+	ledger := newTestLedger(t, conf)
+
+	if err := ledger.OpenSupplyHistory(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+	defer ledger.CloseSupplyHistory()
+
+	master := newTestAddress(umi.PfxVerUmi, 1)
+	deposit := newTestAddress(umi.PfxVerRoy, 11)
+
+	issue := func(amount uint64, nonce uint32) umi.Transaction {
+		transaction := newTestTransaction(umi.TxV16Issue, master, deposit, amount, nonce)
+		transaction.SetPrefix(umi.PfxVerRoy)
+
+		return transaction
+	}
+
+	nonce := uint32(2)
+	live := make(map[uint32]*Supply)
+	checked := map[uint32]bool{
+		3: true, supplyFullInterval - 1: true, supplyFullInterval: true,
+		supplyFullInterval + 1: true, supplyFullInterval + 2: true,
+	}
+
+	for height := uint32(3); height <= supplyFullInterval+3; height++ {
+		var transactions []umi.Transaction
+
+		// Изменения не в каждом блоке, чтобы в истории были и пустые записи.
+		if height%7 == 0 || checked[height] {
+			transactions = append(transactions, issue(uint64(height), nonce))
+			nonce++
+		}
+
+		commitTestBlock(t, ledger, firstJun2020+height*10, transactions...)
+
+		if checked[height] {
+			live[height], _ = ledger.Supply(0)
+		}
+	}
+
+	if live[supplyFullInterval].Issued <= live[3].Issued {
+		t.Fatalf("эмиссия должна расти: %d, %d", live[3].Issued, live[supplyFullInterval].Issued)
+	}
+
+	for height, want := range live {
+		got, err := ledger.Supply(height)
+		if err != nil {
+			t.Fatalf("блок %d: ожидаем 'nil', получили '%v'", height, err)
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("блок %d: ожидаем %+v, получили %+v", height, want, got)
+		}
+	}
+
+	// Блоки 1 и 2 подтверждены до открытия истории и помечены как неизвестные.
+	if _, err := ledger.Supply(2); !errors.Is(err, ErrSupply) {
+		t.Errorf("блок до открытия истории, ожидаем '%v', получили '%v'", ErrSupply, err)
+	}
+
+	// Откат обрезает историю, а новые блоки на тех же высотах перезаписывают записи.
+	if err := ledger.Rewind(supplyFullInterval + 1); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if _, err := ledger.Supply(supplyFullInterval + 2); !errors.Is(err, ErrSupply) {
+		t.Errorf("откаченный блок, ожидаем '%v', получили '%v'", ErrSupply, err)
+	}
+
+	commitTestBlock(t, ledger, firstJun2020+(supplyFullInterval+2)*10, issue(1_000_00, nonce))
+	want, _ := ledger.Supply(0)
+	commitTestBlock(t, ledger, firstJun2020+(supplyFullInterval+3)*10)
+
+	if got, err := ledger.Supply(supplyFullInterval + 2); err != nil || !reflect.DeepEqual(want, got) {
+		t.Errorf("ожидаем %+v, получили %+v (%v)", want, got, err)
+	}
+
+	if got, err := ledger.Supply(supplyFullInterval + 1); err != nil || !reflect.DeepEqual(live[supplyFullInterval+1], got) {
+		t.Errorf("ожидаем %+v, получили %+v (%v)", live[supplyFullInterval+1], got, err)
+	}
+}
@@ -32,13 +32,19 @@ import (
 	"gitlab.com/umitop/umid/pkg/config"
 	"gitlab.com/umitop/umid/pkg/ledger"
 	"gitlab.com/umitop/umid/pkg/nft"
+	"gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
+type iBlockchain interface {
+	Block(uint32) (umi.Block, error)
+}
+
 type Fetcher struct {
 	config     *config.Config
 	client     *http.Client
 	confirmer  *ledger.ConfirmerLegacy
+	blockchain iBlockchain
 	nftStorage *nft.Storage
 }
 
@@ -53,6 +59,10 @@ func (fetcher *Fetcher) SetConfirmer(confirmer *ledger.ConfirmerLegacy) {
 	fetcher.confirmer = confirmer
 }
 
+func (fetcher *Fetcher) SetBlockchain(blockchain iBlockchain) {
+	fetcher.blockchain = blockchain
+}
+
 func (fetcher *Fetcher) SetNftStorage(nftStorage *nft.Storage) {
 	fetcher.nftStorage = nftStorage
 }
@@ -169,26 +179,43 @@ func (fetcher *Fetcher) fetchNfts(ctx context.Context) int {
 }
 
 func (fetcher *Fetcher) fetchBlocks(ctx context.Context) int {
+	height, _ := fetcher.confirmer.LastBlock()
+
+	blocks, err := fetcher.listBlocks(ctx, height+1, 10_000)
+	if err != nil {
+		log.Printf("fetch error: %v", err)
+
+		return -1
+	}
+
+	if len(blocks) == 0 {
+		return 0
+	}
+
+	// log.Printf("скачано %d блоков, начиная с %d", len(blocks), height+1)
+
+	if !fetcher.parseBlocks(ctx, blocks) {
+		return -1
+	}
+
+	return len(blocks)
+}
+
+func (fetcher *Fetcher) listBlocks(ctx context.Context, height uint32, limit int) ([][]byte, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
 	url := fmt.Sprintf("%s/json-rpc", fetcher.config.Peer)
-	height := fetcher.confirmer.BlockHeight + 1
-	limit := 10_000
 	requestBody := newRequestBody(height, limit)
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, requestBody)
 	if err != nil {
-		log.Printf("fetch error: %v", err)
-
-		return -1
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	response, err := fetcher.client.Do(request)
 	if err != nil {
-		log.Printf("fetch error: %v", err)
-
-		return -1
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	defer response.Body.Close()
@@ -198,41 +225,90 @@ func (fetcher *Fetcher) fetchBlocks(ctx context.Context) int {
 	}{}
 
 	if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
-		log.Printf("fetch error: %v", err)
-
-		return -1
-	}
-
-	if len(responseBody.Result) == 0 {
-		return 0
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	// log.Printf("скачано %d блоков, начиная с %d", len(responseBody.Result), height)
-
-	fetcher.parseBlocks(responseBody.Result)
-
-	return len(responseBody.Result)
+	return responseBody.Result, nil
 }
 
-func (fetcher *Fetcher) parseBlocks(blocks [][]byte) {
+func (fetcher *Fetcher) parseBlocks(ctx context.Context, blocks [][]byte) bool {
 	for _, block := range blocks {
 		blk := (umi.BlockLegacy)(block)
 
 		if err := blk.Verify(); err != nil {
-			return
+			return false
+		}
+
+		// Проверяем подпись до сравнения с нашей цепочкой, чтобы поддельный блок не мог вызвать откат.
+		if err := storage.VerifyBlockLegacy(fetcher.config, blk); err != nil {
+			log.Printf("fetch error: блок %x: %v", blk.Hash(), err)
+
+			return false
 		}
 
 		for i, j := 0, blk.TransactionCount(); i < j; i++ {
 			if err := blk.Transaction(i).Verify(); err != nil {
-				return
+				return false
 			}
 		}
 
+		// Блок не ссылается на наш последний блок, значит пир перешел на другую цепочку.
+		if _, lastBlockHash := fetcher.confirmer.LastBlock(); blk.PreviousBlockHash() != lastBlockHash {
+			return fetcher.rollback(ctx)
+		}
+
 		err := fetcher.confirmer.AppendBlockLegacy(block)
 		if err != nil {
 			log.Printf("error: %v", err)
 
-			return
+			return false
 		}
 	}
+
+	return true
+}
+
+// rollback находит последний блок, совпадающий у нас и у пира, и откатывает блокчейн до него.
+// Глубина поиска ограничена config.ReorgDepth.
+func (fetcher *Fetcher) rollback(ctx context.Context) bool {
+	height, _ := fetcher.confirmer.LastBlock()
+	from := uint32(1)
+
+	if depth := uint32(fetcher.config.ReorgDepth); height > depth {
+		from = height - depth + 1
+	}
+
+	blocks, err := fetcher.listBlocks(ctx, from, int(height-from+1))
+	if err != nil {
+		log.Printf("rollback error: %v", err)
+
+		return false
+	}
+
+	forkHeight := uint32(0)
+
+	for i, block := range blocks {
+		local, err := fetcher.blockchain.Block(from + uint32(i))
+		if err != nil || local.Hash() != (umi.BlockLegacy)(block).Hash() {
+			break
+		}
+
+		forkHeight = from + uint32(i)
+	}
+
+	if forkHeight == 0 || forkHeight >= height {
+		log.Printf("rollback error: общий блок с пиром не найден в диапазоне %d-%d", from, height)
+
+		return false
+	}
+
+	log.Printf("пир перешел на другую цепочку, откатываем блоки с %d по %d", forkHeight+1, height)
+
+	if err := fetcher.confirmer.Rollback(forkHeight); err != nil {
+		log.Printf("rollback error: %v", err)
+
+		return false
+	}
+
+	return true
 }
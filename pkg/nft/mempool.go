@@ -1,6 +1,7 @@
 package nft
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"gitlab.com/umitop/umid/pkg/ledger"
+	storage1 "gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
@@ -28,12 +30,24 @@ type Mempool struct {
 	ledger       iLedger
 	blocks       chan umi.Block
 	transactions map[umi.Hash][]byte
+	queued       map[umi.Hash]*queued
+	queue        evictionQueue
+	senders      map[umi.Address]int
+	bytes        int
+	maxCount     int
+	maxBytes     int
+	senderQuota  int
+	evicted      uint64
+	refused      uint64
+	storage1.MempoolJournal
 }
 
 func NewMempool() *Mempool {
 	return &Mempool{
 		blocks:       make(chan umi.Block, 64),
 		transactions: make(map[umi.Hash][]byte),
+		queued:       make(map[umi.Hash]*queued),
+		senders:      make(map[umi.Address]int),
 	}
 }
 
@@ -50,6 +64,15 @@ func (mempool *Mempool) Mempool() (txs [][]byte) {
 	return txs
 }
 
+// Transaction возвращает неподтвержденную транзакцию по хэшу.
+func (mempool *Mempool) Transaction(hash umi.Hash) (transaction []byte, ok bool) {
+	mempool.RLock()
+	transaction, ok = mempool.transactions[hash]
+	mempool.RUnlock()
+
+	return transaction, ok
+}
+
 func (mempool *Mempool) SubscribeTo(subscriber iSubscriber) {
 	subscriber.Subscribe(mempool.blocks)
 }
@@ -77,13 +100,42 @@ func (mempool *Mempool) SetLedger(ledger1 iLedger) {
 }
 
 func (mempool *Mempool) Push(transaction []byte) error {
-	tx := (Transaction)(transaction)
+	mempool.Lock()
+	defer mempool.Unlock()
 
-	hash := tx.Hash()
+	if err := mempool.push(transaction); err != nil {
+		return err
+	}
 
+	mempool.AppendJournal(transaction)
+
+	return nil
+}
+
+// Restore загружает транзакции из журнала после перезапуска ноды. Транзакции проходят
+// обычную проверку, просроченные и невалидные отбрасываются, после чего журнал сжимается.
+func (mempool *Mempool) Restore() error {
 	mempool.Lock()
 	defer mempool.Unlock()
 
+	timestamp := func(record []byte) (uint32, bool) {
+		if len(record) < hdrLen {
+			return 0, false
+		}
+
+		tx := (Transaction)(record)
+
+		return tx.Timestamp(), true
+	}
+
+	return mempool.RestoreJournal(timestamp, mempool.push, mempool.journalRecords)
+}
+
+func (mempool *Mempool) push(transaction []byte) error {
+	tx := (Transaction)(transaction)
+
+	hash := tx.Hash()
+
 	if _, ok := mempool.transactions[hash]; ok {
 		return fmt.Errorf("%w: tranasction in mempool", ErrMempool)
 	}
@@ -101,7 +153,19 @@ func (mempool *Mempool) Push(transaction []byte) error {
 	//	return fmt.Errorf("%w: insufficient funds", ErrMempool)
 	//}
 
+	sender := tx.Sender()
+
+	if err := mempool.reserve(sender, len(transaction)); err != nil {
+		mempool.refused++
+
+		return err
+	}
+
 	mempool.transactions[hash] = transaction
+	mempool.queued[hash] = &queued{hash: hash, size: len(transaction)}
+	heap.Push(&mempool.queue, mempool.queued[hash])
+	mempool.senders[sender]++
+	mempool.bytes += len(transaction)
 
 	return nil
 }
@@ -118,15 +182,41 @@ func (mempool *Mempool) ParseBlock(block umi.Block) {
 			mempool.remove(hash)
 		}
 	}
+
+	mempool.CompactJournal(len(mempool.transactions), mempool.journalRecords)
+}
+
+func (mempool *Mempool) journalRecords() [][]byte {
+	records := make([][]byte, 0, len(mempool.transactions))
+
+	for _, transaction := range mempool.transactions {
+		records = append(records, transaction)
+	}
+
+	return records
 }
 
 func (mempool *Mempool) remove(hash umi.Hash) {
-	_, ok := mempool.transactions[hash]
+	transaction, ok := mempool.transactions[hash]
 	if !ok {
 		return
 	}
 
 	delete(mempool.transactions, hash)
+
+	heap.Remove(&mempool.queue, mempool.queued[hash].index)
+	delete(mempool.queued, hash)
+
+	tx := (Transaction)(transaction)
+	sender := tx.Sender()
+
+	mempool.senders[sender]--
+
+	if mempool.senders[sender] == 0 {
+		delete(mempool.senders, sender)
+	}
+
+	mempool.bytes -= len(transaction)
 }
 
 func (mempool *Mempool) cleanup() {
@@ -0,0 +1,114 @@
+package nft
+
+import (
+	"bytes"
+	"fmt"
+
+	storage1 "gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// SetLimits задает максимальное количество транзакций, их суммарный размер в байтах
+// и количество неподтвержденных транзакций одного отправителя. Нулевое значение снимает ограничение.
+func (mempool *Mempool) SetLimits(maxCount, maxBytes, senderQuota int) {
+	mempool.Lock()
+	defer mempool.Unlock()
+
+	mempool.maxCount = maxCount
+	mempool.maxBytes = maxBytes
+	mempool.senderQuota = senderQuota
+}
+
+func (mempool *Mempool) Metrics() storage1.MempoolMetrics {
+	mempool.RLock()
+	defer mempool.RUnlock()
+
+	return storage1.MempoolMetrics{
+		Count:   len(mempool.transactions),
+		Bytes:   mempool.bytes,
+		Evicted: mempool.evicted,
+		Refused: mempool.refused,
+	}
+}
+
+// reserve освобождает место под новую транзакцию, вытесняя самые большие NFT. Если новая
+// транзакция не меньше всех, которые можно вытеснить, она не принимается.
+func (mempool *Mempool) reserve(sender umi.Address, size int) error {
+	if mempool.senderQuota > 0 && mempool.senders[sender] >= mempool.senderQuota {
+		return fmt.Errorf("%w: sender has %d pending transactions", storage1.ErrMempoolFull, mempool.senders[sender])
+	}
+
+	for mempool.overflows(size) {
+		victim, ok := mempool.largest()
+		if !ok || len(mempool.transactions[victim]) <= size {
+			return fmt.Errorf("%w: %d transactions, %d bytes", storage1.ErrMempoolFull, len(mempool.transactions), mempool.bytes)
+		}
+
+		mempool.remove(victim)
+		mempool.evicted++
+	}
+
+	return nil
+}
+
+func (mempool *Mempool) overflows(size int) bool {
+	if mempool.maxCount > 0 && len(mempool.transactions)+1 > mempool.maxCount {
+		return true
+	}
+
+	return mempool.maxBytes > 0 && mempool.bytes+size > mempool.maxBytes
+}
+
+// largest возвращает самую большую транзакцию, при равенстве — с меньшим хэшем.
+func (mempool *Mempool) largest() (hash umi.Hash, ok bool) {
+	if mempool.queue.Len() == 0 {
+		return hash, false
+	}
+
+	return mempool.queue[0].hash, true
+}
+
+// queued — позиция транзакции в очереди на вытеснение.
+type queued struct {
+	hash  umi.Hash
+	size  int
+	index int
+}
+
+// evictionQueue — куча транзакций в порядке вытеснения: на вершине самая большая транзакция,
+// при равенстве — с меньшим хэшем.
+type evictionQueue []*queued
+
+func (queue evictionQueue) Len() int {
+	return len(queue)
+}
+
+func (queue evictionQueue) Less(i, j int) bool {
+	if queue[i].size != queue[j].size {
+		return queue[i].size > queue[j].size
+	}
+
+	return bytes.Compare(queue[i].hash[:], queue[j].hash[:]) < 0
+}
+
+func (queue evictionQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+
+func (queue *evictionQueue) Push(x interface{}) {
+	item := x.(*queued)
+	item.index = len(*queue)
+	*queue = append(*queue, item)
+}
+
+func (queue *evictionQueue) Pop() interface{} {
+	old := *queue
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*queue = old[:n-1]
+
+	return item
+}
@@ -0,0 +1,343 @@
+package nft_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/nft"
+	"gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+type ledgerMock struct {
+	confirmed map[umi.Hash]bool
+}
+
+func (mock *ledgerMock) Account(_ umi.Address) (account *ledger.Account, ok bool) {
+	return nil, false
+}
+
+func (mock *ledgerMock) Structure(_ umi.Prefix) (structure *ledger.Structure, ok bool) {
+	return nil, false
+}
+
+func (mock *ledgerMock) HasTransaction(hash umi.Hash) bool {
+	return mock.confirmed[hash]
+}
+
+func newSender(t *testing.T) (umi.Address, ed25519.PrivateKey) {
+	t.Helper()
+
+	pub, sec, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := umi.Address{}
+	address.SetPrefix(umi.ParsePrefix("umi"))
+	copy(address[2:], pub)
+
+	return address, sec
+}
+
+func newMint(sender umi.Address, sec ed25519.PrivateKey, timestamp, nonce uint32, size int) []byte {
+	tx := nft.NewTransaction()
+	tx.SetTimestamp(timestamp)
+	tx.SetNonce(nonce)
+	tx.SetMeta([]byte(`{}`))
+	tx.SetData(make([]byte, size))
+	tx.SetSender(sender)
+	tx.Sign(sec)
+
+	return *tx
+}
+
+// hashOf возвращает хэш транзакции так же, как его считает мемпул.
+func hashOf(transaction []byte) umi.Hash {
+	tx := (nft.Transaction)(transaction)
+
+	return tx.Hash()
+}
+
+func TestMempool_EvictsLargest(t *testing.T) {
+	t.Parallel()
+
+	sender, sec := newSender(t)
+	now := uint32(time.Now().Unix())
+
+	mempool := nft.NewMempool()
+	mempool.SetLedger(&ledgerMock{})
+	mempool.SetLimits(3, 0, 0)
+
+	small := newMint(sender, sec, now, 1, 100)
+	large1 := newMint(sender, sec, now, 2, 200)
+	large2 := newMint(sender, sec, now, 3, 200)
+
+	for _, tx := range [][]byte{small, large1, large2} {
+		if err := mempool.Push(tx); err != nil {
+			t.Fatalf("ожидаем 'nil', получили '%v'", err)
+		}
+	}
+
+	// При равенстве размеров первой вытесняется транзакция с меньшим хэшем.
+	first, second := large1, large2
+
+	if hash1, hash2 := hashOf(large1), hashOf(large2); bytes.Compare(hash1[:], hash2[:]) > 0 {
+		first, second = large2, large1
+	}
+
+	medium1 := newMint(sender, sec, now, 4, 150)
+	if err := mempool.Push(medium1); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if _, ok := mempool.Transaction(hashOf(first)); ok {
+		t.Error("самая большая транзакция с меньшим хэшем должна быть вытеснена")
+	}
+
+	if _, ok := mempool.Transaction(hashOf(second)); !ok {
+		t.Error("вторая большая транзакция должна остаться в мемпуле")
+	}
+
+	medium2 := newMint(sender, sec, now, 5, 150)
+	if err := mempool.Push(medium2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if _, ok := mempool.Transaction(hashOf(second)); ok {
+		t.Error("самая большая транзакция должна быть вытеснена")
+	}
+
+	// Новая транзакция не меньше всех, которые можно вытеснить.
+	refused := [][]byte{
+		newMint(sender, sec, now, 6, 150),
+		newMint(sender, sec, now, 7, 300),
+	}
+
+	for _, tx := range refused {
+		if err := mempool.Push(tx); !errors.Is(err, storage.ErrMempoolFull) {
+			t.Errorf("ожидаем '%v', получили '%v'", storage.ErrMempoolFull, err)
+		}
+	}
+
+	for _, tx := range [][]byte{small, medium1, medium2} {
+		if _, ok := mempool.Transaction(hashOf(tx)); !ok {
+			t.Error("транзакция не должна быть вытеснена")
+		}
+	}
+
+	metrics := mempool.Metrics()
+
+	if metrics.Count != 3 {
+		t.Errorf("expected %d, got %d", 3, metrics.Count)
+	}
+
+	if want := len(small) + len(medium1) + len(medium2); metrics.Bytes != want {
+		t.Errorf("expected %d, got %d", want, metrics.Bytes)
+	}
+
+	if metrics.Evicted != 2 {
+		t.Errorf("expected %d, got %d", 2, metrics.Evicted)
+	}
+
+	if metrics.Refused != 2 {
+		t.Errorf("expected %d, got %d", 2, metrics.Refused)
+	}
+}
+
+func TestMempool_MaxBytes(t *testing.T) {
+	t.Parallel()
+
+	sender, sec := newSender(t)
+	now := uint32(time.Now().Unix())
+
+	small := newMint(sender, sec, now, 1, 100)
+	large := newMint(sender, sec, now, 2, 200)
+
+	mempool := nft.NewMempool()
+	mempool.SetLedger(&ledgerMock{})
+	mempool.SetLimits(0, len(small)+len(large), 0)
+
+	for _, tx := range [][]byte{small, large} {
+		if err := mempool.Push(tx); err != nil {
+			t.Fatalf("ожидаем 'nil', получили '%v'", err)
+		}
+	}
+
+	if err := mempool.Push(newMint(sender, sec, now, 3, 150)); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if _, ok := mempool.Transaction(hashOf(large)); ok {
+		t.Error("самая большая транзакция должна быть вытеснена")
+	}
+
+	if metrics := mempool.Metrics(); metrics.Evicted != 1 || metrics.Refused != 0 {
+		t.Errorf("expected evicted %d refused %d, got %d %d", 1, 0, metrics.Evicted, metrics.Refused)
+	}
+}
+
+func TestMempool_SenderQuota(t *testing.T) {
+	t.Parallel()
+
+	sender, sec := newSender(t)
+	other, otherSec := newSender(t)
+	now := uint32(time.Now().Unix())
+
+	mempool := nft.NewMempool()
+	mempool.SetLedger(&ledgerMock{})
+	mempool.SetLimits(0, 0, 2)
+
+	for nonce := uint32(1); nonce <= 2; nonce++ {
+		if err := mempool.Push(newMint(sender, sec, now, nonce, 100)); err != nil {
+			t.Fatalf("ожидаем 'nil', получили '%v'", err)
+		}
+	}
+
+	if err := mempool.Push(newMint(sender, sec, now, 3, 100)); !errors.Is(err, storage.ErrMempoolFull) {
+		t.Errorf("ожидаем '%v', получили '%v'", storage.ErrMempoolFull, err)
+	}
+
+	if err := mempool.Push(newMint(other, otherSec, now, 1, 100)); err != nil {
+		t.Errorf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	metrics := mempool.Metrics()
+
+	if metrics.Count != 3 {
+		t.Errorf("expected %d, got %d", 3, metrics.Count)
+	}
+
+	if metrics.Evicted != 0 {
+		t.Errorf("expected %d, got %d", 0, metrics.Evicted)
+	}
+
+	if metrics.Refused != 1 {
+		t.Errorf("expected %d, got %d", 1, metrics.Refused)
+	}
+}
+
+// witnessBlock возвращает блок с транзакциями-свидетелями выпуска NFT.
+func witnessBlock(hashes ...umi.Hash) umi.Block {
+	block := umi.NewBlock().SetVersion(1).SetTransactionCount(len(hashes))
+
+	for _, hash := range hashes {
+		block = append(block, umi.NewTransaction().SetVersion(umi.TxV18MintNftWitness).SetHash(hash)...)
+		block = append(block, make([]byte, umi.TxConfirmedLength-umi.TxLength)...)
+	}
+
+	return block
+}
+
+func TestMempool_RestoreJournal(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	sender, sec := newSender(t)
+	now := uint32(time.Now().Unix())
+
+	fresh := newMint(sender, sec, now, 1, 100)
+	confirmed1 := newMint(sender, sec, now, 2, 100)
+	confirmed2 := newMint(sender, sec, now, 3, 100)
+	confirmed3 := newMint(sender, sec, now, 4, 100)
+	expired := newMint(sender, sec, now-3601, 5, 100)
+	future := newMint(sender, sec, now+600, 6, 100)
+
+	journal := storage.NewJournal(conf, "nftmempool")
+
+	if err := journal.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mempool := nft.NewMempool()
+	mempool.SetLedger(&ledgerMock{})
+	mempool.SetJournal(journal)
+
+	for _, tx := range [][]byte{fresh, confirmed1, confirmed2, confirmed3, expired, future} {
+		if err := mempool.Push(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mempool.ParseBlock(witnessBlock(hashOf(confirmed1), hashOf(confirmed2)))
+
+	if _, ok := mempool.Transaction(hashOf(confirmed1)); ok {
+		t.Error("подтвержденная транзакция должна быть удалена из мемпула")
+	}
+
+	// Журнал сжимается, только когда записей больше, чем вдвое больше транзакций в мемпуле.
+	if records := journal.Records(); records != 6 {
+		t.Errorf("expected %d records, got %d", 6, records)
+	}
+
+	mempool.ParseBlock(witnessBlock(hashOf(confirmed3)))
+
+	if records := journal.Records(); records != 6 {
+		t.Errorf("expected %d records, got %d", 6, records)
+	}
+
+	journal.Close()
+
+	journal = storage.NewJournal(conf, "nftmempool")
+
+	if err := journal.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	// После перезапуска подтвержденные транзакции уже есть в леджере.
+	confirmed := &ledgerMock{confirmed: map[umi.Hash]bool{
+		hashOf(confirmed1): true,
+		hashOf(confirmed2): true,
+		hashOf(confirmed3): true,
+	}}
+
+	mempool = nft.NewMempool()
+	mempool.SetLedger(confirmed)
+	mempool.SetJournal(journal)
+
+	if err := mempool.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := mempool.Transaction(hashOf(fresh)); !ok {
+		t.Error("transaction must be restored")
+	}
+
+	if count := len(mempool.Mempool()); count != 1 {
+		t.Errorf("expected %d, got %d", 1, count)
+	}
+
+	if records := journal.Records(); records != 1 {
+		t.Errorf("expected %d records, got %d", 1, records)
+	}
+
+	next1 := newMint(sender, sec, now, 7, 100)
+	next2 := newMint(sender, sec, now, 8, 100)
+
+	for _, tx := range [][]byte{next1, next2} {
+		if err := mempool.Push(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mempool.ParseBlock(witnessBlock(hashOf(next1)))
+
+	if records := journal.Records(); records != 3 {
+		t.Errorf("expected %d records, got %d", 3, records)
+	}
+
+	mempool.ParseBlock(witnessBlock(hashOf(next2)))
+
+	if records := journal.Records(); records != 1 {
+		t.Errorf("expected %d records, got %d", 1, records)
+	}
+}
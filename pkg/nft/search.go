@@ -0,0 +1,183 @@
+package nft
+
+import (
+	"encoding/json"
+	"strings"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// Query описывает фильтр поиска NFT. Пустые поля не ограничивают выборку.
+// Meta сравнивается со значениями ключей верхнего уровня мета-данных: строки без кавычек,
+// остальные значения в виде JSON.
+type Query struct {
+	ContentType *string
+	Sender      *umi.Address
+	Meta        map[string]string
+	From        *uint32
+	To          *uint32
+	MinSize     *int
+	MaxSize     *int
+}
+
+type searchEntry struct {
+	hash        umi.Hash
+	contentType string
+	sender      umi.Address
+	timestamp   uint32
+	size        int
+	meta        map[string]string
+}
+
+// searchIndex хранит разобранные мета-данные NFT в порядке добавления в хранилище
+// и списки позиций по типу содержимого, отправителю и парам ключ-значение мета-данных.
+type searchIndex struct {
+	entries       []searchEntry
+	byContentType map[string][]int
+	bySender      map[umi.Address][]int
+	byMeta        map[string][]int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		entries:       make([]searchEntry, 0),
+		byContentType: make(map[string][]int),
+		bySender:      make(map[umi.Address][]int),
+		byMeta:        make(map[string][]int),
+	}
+}
+
+func (index *searchIndex) add(tx Transaction) {
+	raw := tx.Meta()
+
+	entry := searchEntry{
+		hash:        tx.Hash(),
+		contentType: "application/octet-stream",
+		sender:      tx.Sender(),
+		timestamp:   tx.Timestamp(),
+		size:        len(tx.Data()),
+		meta:        parseMeta(raw),
+	}
+
+	if len(raw) > 0 {
+		m := struct {
+			ContentType *string `json:"contentType"`
+		}{}
+
+		if err := json.Unmarshal(raw, &m); err == nil && m.ContentType != nil {
+			entry.contentType = *m.ContentType
+		}
+	}
+
+	pos := len(index.entries)
+	index.entries = append(index.entries, entry)
+
+	index.byContentType[entry.contentType] = append(index.byContentType[entry.contentType], pos)
+	index.bySender[entry.sender] = append(index.bySender[entry.sender], pos)
+
+	for key, value := range entry.meta {
+		metaKey := metaIndexKey(key, value)
+		index.byMeta[metaKey] = append(index.byMeta[metaKey], pos)
+	}
+}
+
+// search возвращает хеши NFT, подходящих под фильтр. Перебирается самый короткий
+// из списков позиций, соответствующих условиям на равенство.
+func (index *searchIndex) search(query *Query) []umi.Hash {
+	var candidates []int
+
+	all := true
+
+	narrow := func(positions []int) {
+		if all || len(positions) < len(candidates) {
+			candidates = positions
+			all = false
+		}
+	}
+
+	if query.ContentType != nil {
+		narrow(index.byContentType[*query.ContentType])
+	}
+
+	if query.Sender != nil {
+		narrow(index.bySender[*query.Sender])
+	}
+
+	for key, value := range query.Meta {
+		narrow(index.byMeta[metaIndexKey(key, value)])
+	}
+
+	hashes := make([]umi.Hash, 0)
+
+	if all {
+		for i := range index.entries {
+			if index.entries[i].match(query) {
+				hashes = append(hashes, index.entries[i].hash)
+			}
+		}
+
+		return hashes
+	}
+
+	for _, pos := range candidates {
+		if index.entries[pos].match(query) {
+			hashes = append(hashes, index.entries[pos].hash)
+		}
+	}
+
+	return hashes
+}
+
+func (entry *searchEntry) match(query *Query) bool {
+	switch {
+	case query.ContentType != nil && entry.contentType != *query.ContentType,
+		query.Sender != nil && entry.sender != *query.Sender,
+		query.From != nil && entry.timestamp < *query.From,
+		query.To != nil && entry.timestamp > *query.To,
+		query.MinSize != nil && entry.size < *query.MinSize,
+		query.MaxSize != nil && entry.size > *query.MaxSize:
+		return false
+	}
+
+	for key, value := range query.Meta {
+		if actual, ok := entry.meta[key]; !ok || actual != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseMeta разбирает ключи верхнего уровня мета-данных. Некорректные мета-данные
+// считаются пустыми.
+func parseMeta(meta json.RawMessage) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(meta, &fields); err != nil {
+		return nil
+	}
+
+	values := make(map[string]string, len(fields))
+
+	for key, raw := range fields {
+		var str string
+
+		if len(raw) > 0 && raw[0] == '"' && json.Unmarshal(raw, &str) == nil {
+			values[key] = str
+
+			continue
+		}
+
+		values[key] = strings.TrimSpace(string(raw))
+	}
+
+	return values
+}
+
+func metaIndexKey(key, value string) string {
+	return key + "\x00" + value
+}
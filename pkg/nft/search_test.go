@@ -0,0 +1,164 @@
+package nft
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestParseMeta(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		meta string
+		want map[string]string
+	}{
+		{"empty", ``, nil},
+		{"invalid", `{"name":`, nil},
+		{"not an object", `["cat"]`, nil},
+		{"string", `{"name":"cat"}`, map[string]string{"name": "cat"}},
+		{"escaped string", `{"name":"c\"at"}`, map[string]string{"name": `c"at`}},
+		{"number", `{"rank": 1.50}`, map[string]string{"rank": "1.50"}},
+		{"bool", `{"rare":true}`, map[string]string{"rare": "true"}},
+		{"null", `{"owner":null}`, map[string]string{"owner": "null"}},
+		{"object", `{"attrs":{"color":"red"}}`, map[string]string{"attrs": `{"color":"red"}`}},
+		{"array", `{"tags":["a","b"]}`, map[string]string{"tags": `["a","b"]`}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseMeta(json.RawMessage(tt.meta)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSearchIndex_Search(t *testing.T) {
+	t.Parallel()
+
+	sender1 := umi.Address{}
+	sender1.SetPrefix(umi.ParsePrefix("umi"))
+	sender1[2] = 1
+
+	sender2 := umi.Address{}
+	sender2.SetPrefix(umi.ParsePrefix("umi"))
+	sender2[2] = 2
+
+	entries := []struct {
+		name      string
+		meta      string
+		sender    umi.Address
+		timestamp uint32
+		size      int
+	}{
+		{"a", `{"contentType":"image/png","name":"cat","rank":1}`, sender1, 100, 10},
+		{"b", `{"contentType":"image/png","name":"dog","rare":true}`, sender2, 200, 20},
+		{"c", `{"name":"cat","attrs":{"color":"red"}}`, sender1, 300, 30},
+		{"d", `{"contentType":`, sender2, 400, 40},
+		{"e", `{"contentType":"text/plain","rank":"1"}`, sender1, 500, 50},
+		{"f", ``, sender2, 600, 60},
+	}
+
+	index := newSearchIndex()
+	hashes := make(map[string]umi.Hash)
+
+	for _, e := range entries {
+		tx := NewTransaction()
+		tx.SetTimestamp(e.timestamp)
+		tx.SetMeta(json.RawMessage(e.meta))
+		tx.SetData(make([]byte, e.size))
+		tx.SetSender(e.sender)
+
+		index.add(*tx)
+		hashes[e.name] = tx.Hash()
+	}
+
+	str := func(s string) *string { return &s }
+	u32 := func(n uint32) *uint32 { return &n }
+	num := func(n int) *int { return &n }
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"no filter", Query{}, []string{"a", "b", "c", "d", "e", "f"}},
+
+		{"content type", Query{ContentType: str("image/png")}, []string{"a", "b"}},
+		{"default content type", Query{ContentType: str("application/octet-stream")}, []string{"c", "d", "f"}},
+		{"unknown content type", Query{ContentType: str("video/mp4")}, []string{}},
+		{"sender", Query{Sender: &sender1}, []string{"a", "c", "e"}},
+		{"from", Query{From: u32(400)}, []string{"d", "e", "f"}},
+		{"to", Query{To: u32(200)}, []string{"a", "b"}},
+		{"from to", Query{From: u32(200), To: u32(300)}, []string{"b", "c"}},
+		{"exact time", Query{From: u32(500), To: u32(500)}, []string{"e"}},
+		{"min size", Query{MinSize: num(50)}, []string{"e", "f"}},
+		{"max size", Query{MaxSize: num(20)}, []string{"a", "b"}},
+		{"size range", Query{MinSize: num(20), MaxSize: num(40)}, []string{"b", "c", "d"}},
+
+		{"meta string", Query{Meta: map[string]string{"name": "cat"}}, []string{"a", "c"}},
+		{"meta quoted string", Query{Meta: map[string]string{"name": `"cat"`}}, []string{}},
+		{"meta number and string", Query{Meta: map[string]string{"rank": "1"}}, []string{"a", "e"}},
+		{"meta bool", Query{Meta: map[string]string{"rare": "true"}}, []string{"b"}},
+		{"meta object", Query{Meta: map[string]string{"attrs": `{"color":"red"}`}}, []string{"c"}},
+		{"meta content type", Query{Meta: map[string]string{"contentType": "image/png"}}, []string{"a", "b"}},
+		{"meta unknown key", Query{Meta: map[string]string{"color": "red"}}, []string{}},
+
+		{"content type and meta", Query{
+			ContentType: str("image/png"),
+			Meta:        map[string]string{"name": "cat"},
+		}, []string{"a"}},
+		{"default content type and meta", Query{
+			ContentType: str("application/octet-stream"),
+			Meta:        map[string]string{"name": "cat"},
+		}, []string{"c"}},
+		{"sender and content type", Query{
+			Sender:      &sender2,
+			ContentType: str("application/octet-stream"),
+		}, []string{"d", "f"}},
+		{"meta pair", Query{
+			Meta: map[string]string{"name": "cat", "rank": "1"},
+		}, []string{"a"}},
+		{"sender and time", Query{Sender: &sender1, From: u32(200)}, []string{"c", "e"}},
+		{"meta and size", Query{Meta: map[string]string{"name": "cat"}, MinSize: num(20)}, []string{"c"}},
+		{"all filters", Query{
+			ContentType: str("image/png"),
+			Sender:      &sender2,
+			Meta:        map[string]string{"name": "dog"},
+			From:        u32(100),
+			To:          u32(200),
+			MinSize:     num(20),
+			MaxSize:     num(20),
+		}, []string{"b"}},
+		{"all filters mismatch", Query{
+			ContentType: str("image/png"),
+			Sender:      &sender1,
+			Meta:        map[string]string{"name": "dog"},
+		}, []string{}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			want := make([]umi.Hash, 0, len(tt.want))
+			for _, name := range tt.want {
+				want = append(want, hashes[name])
+			}
+
+			if got := index.search(&tt.query); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
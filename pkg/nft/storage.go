@@ -21,8 +21,9 @@ var (
 )
 
 type idx struct {
-	Offset int64
-	Length int
+	Offset    int64
+	Length    int
+	Timestamp uint32
 }
 
 type Storage struct {
@@ -32,6 +33,7 @@ type Storage struct {
 	height     []umi.Hash
 	indexFile  storage1.IFile
 	lastOffset int64
+	search     *searchIndex
 }
 
 func NewStorage(conf *config.Config) *Storage {
@@ -39,6 +41,7 @@ func NewStorage(conf *config.Config) *Storage {
 		config: conf,
 		tokens: make(map[umi.Hash]idx, 1),
 		height: make([]umi.Hash, 0),
+		search: newSearchIndex(),
 	}
 }
 
@@ -99,11 +102,13 @@ func (storage *Storage) Scan() error {
 		hash := tx.Hash()
 
 		storage.tokens[hash] = idx{
-			Offset: storage.lastOffset,
-			Length: len(tx),
+			Offset:    storage.lastOffset,
+			Length:    len(tx),
+			Timestamp: tx.Timestamp(),
 		}
 
 		storage.height = append(storage.height, hash)
+		storage.search.add(tx)
 
 		offset += totalLength
 		storage.lastOffset = offset
@@ -120,18 +125,29 @@ func (storage *Storage) AppendData(data []byte) error {
 	}
 
 	hash := sha256.Sum256(data)
+	tx := Transaction(data)
 
 	storage.tokens[hash] = idx{
-		Offset: storage.lastOffset,
-		Length: n,
+		Offset:    storage.lastOffset,
+		Length:    n,
+		Timestamp: tx.Timestamp(),
 	}
 
 	storage.height = append(storage.height, hash)
+	storage.search.add(data)
 	storage.lastOffset += int64(n)
 
 	return nil
 }
 
+// Search возвращает хеши NFT, подходящих под фильтр, в порядке добавления в хранилище.
+func (storage *Storage) Search(query *Query) []umi.Hash {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return storage.search.search(query)
+}
+
 func (storage *Storage) Count() int {
 	return len(storage.height)
 }
@@ -166,6 +182,16 @@ func (storage *Storage) Data(hash [32]byte) (data Transaction, err error) {
 	return tx, nil
 }
 
+// Timestamp возвращает время выпуска NFT без чтения данных с диска.
+func (storage *Storage) Timestamp(hash [32]byte) (timestamp uint32, ok bool) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	idx, ok := storage.tokens[hash]
+
+	return idx.Timestamp, ok
+}
+
 func (storage *Storage) ParsedData(hash [32]byte) (meta json.RawMessage, data []byte, err error) {
 	tx, err := storage.Data(hash)
 
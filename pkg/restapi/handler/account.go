@@ -23,9 +23,13 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
@@ -64,6 +68,22 @@ func GetAccount(ledger1 iLedger, mempool iMempool) http.HandlerFunc {
 	}
 }
 
+type iRateHistory interface {
+	RateChanges(umi.Prefix, uint32, uint32) []ledger.RateChange
+}
+
+// GetAccountHistory возвращает состояние адреса на высоте блока (?height=) или на момент времени (?at=).
+func GetAccountHistory(blockchain storage.IBlockchain, index *storage.Index, rates iRateHistory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(GetAccountResponse)
+		response.Data, response.Error = processGetAccountHistory(r, blockchain, index, rates)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
 func processGetAccount(r *http.Request, ledger1 iLedger, mempool iMempool) (*GetAccountData, *Error) {
 	bech32 := strings.TrimPrefix(r.URL.Path, "/api/addresses/")
 	bech32 = strings.TrimSuffix(bech32, "/account")
@@ -199,3 +219,144 @@ func processDev(address umi.Address, ledger1 iLedger, mempool iMempool) (*GetAcc
 
 	return data, nil
 }
+
+// processGetAccountHistory восстанавливает состояние адреса по мета-данным последней подтвержденной
+// транзакции адреса не позже заданной высоты, применяет пересчеты ставок структуры после этой
+// транзакции и начисляет проценты до заданного времени.
+func processGetAccountHistory(r *http.Request, blockchain storage.IBlockchain, index *storage.Index,
+	rates iRateHistory) (*GetAccountData, *Error) {
+	bech32 := strings.TrimPrefix(r.URL.Path, "/api/addresses/")
+	bech32 = strings.TrimSuffix(bech32, "/account")
+
+	address, err := umi.ParseAddress(bech32)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	height, timestamp, errResp := parseHistoryParams(r, blockchain)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	txs, ok := index.TransactionsByAddress(address)
+	if !ok {
+		return nil, NewError(404, "Account not found")
+	}
+
+	// Транзакции в индексе отсортированы по высоте блока.
+	count := sort.Search(len(*txs), func(i int) bool {
+		return uint32((*txs)[i]>>16) > height
+	})
+
+	if count == 0 {
+		return nil, NewError(404, "Account not found")
+	}
+
+	key := (*txs)[count-1]
+
+	transaction, ok := blockchain.Transaction(uint32(key>>16), uint16(key&0xFFFF))
+	if !ok {
+		return nil, NewError(503, "Internal error")
+	}
+
+	account := accountFromTransaction(transaction, address)
+
+	switch account.Type {
+	case umi.Profit, umi.Dev:
+		return nil, NewError(400, "history is not available for profit and dev accounts")
+	}
+
+	// Пересчет ставок фиксируется после транзакций блока, поэтому пересчет в блоке транзакции
+	// тоже применяется.
+	for _, change := range rates.RateChanges(address.Prefix(), transaction.BlockHeight(), height) {
+		account.SetInterestRate(change.InterestRate(account.Type), change.Timestamp)
+	}
+
+	data := &GetAccountData{
+		Type:             account.Type.String(),
+		ConfirmedBalance: account.BalanceAt(timestamp),
+		TransactionCount: account.TransactionCount,
+	}
+
+	data.UnconfirmedBalance = int64(data.ConfirmedBalance)
+
+	if account.Type != umi.Umi {
+		data.Balance = new(uint64)
+		*data.Balance = account.Balance
+
+		data.InterestRate = new(uint16)
+		*data.InterestRate = account.InterestRate
+
+		data.UpdatedAt = new(string)
+		*data.UpdatedAt = time.Unix(int64(account.UpdatedAt), 0).UTC().Format(time.RFC3339)
+	}
+
+	return data, nil
+}
+
+// parseHistoryParams возвращает высоту блока и время, на которые запрошено состояние адреса.
+// Параметр at принимает время в формате RFC3339 или в секундах Unix.
+func parseHistoryParams(r *http.Request, blockchain storage.IBlockchain) (uint32, uint32, *Error) {
+	query := r.URL.Query()
+
+	if str := query.Get("height"); str != "" {
+		height, err := strconv.ParseUint(str, 10, 32)
+		if err != nil {
+			return 0, 0, NewError(400, err.Error())
+		}
+
+		block, err := blockchain.Block(uint32(height))
+		if err != nil {
+			return 0, 0, NewError(404, "Block not found")
+		}
+
+		return uint32(height), block.Timestamp(), nil
+	}
+
+	str := query.Get("at")
+
+	if epoch, err := strconv.ParseUint(str, 10, 32); err == nil {
+		return blockchain.HeightAt(uint32(epoch)), uint32(epoch), nil
+	}
+
+	at, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return 0, 0, NewError(400, err.Error())
+	}
+
+	return blockchain.HeightAt(uint32(at.Unix())), uint32(at.Unix()), nil
+}
+
+// accountFromTransaction возвращает состояние адреса после транзакции. Мета-данные комиссии
+// записываются последними, поэтому проверяются первыми.
+func accountFromTransaction(transaction umi.Transaction, address umi.Address) *ledger.Account {
+	account := &ledger.Account{
+		UpdatedAt: transaction.BlockTimestamp(),
+	}
+
+	var interestRate uint16
+
+	switch {
+	case transaction.HasFee() && transaction.FeeAddress() == address:
+		account.Type = umi.Fee
+		account.Balance = transaction.FeeAccountBalance()
+		account.TransactionCount = transaction.FeeAccountTransactionCount()
+		interestRate = transaction.FeeAccountInterestRate()
+
+	case transaction.HasRecipient() && transaction.Recipient() == address:
+		account.Type = transaction.RecipientAccountType()
+		account.Balance = transaction.RecipientAccountBalance()
+		account.TransactionCount = transaction.RecipientAccountTransactionCount()
+		interestRate = transaction.RecipientAccountInterestRate()
+
+	default:
+		account.Type = transaction.SenderAccountType()
+		account.Balance = transaction.SenderAccountBalance()
+		account.TransactionCount = transaction.SenderAccountTransactionCount()
+		interestRate = transaction.SenderAccountInterestRate()
+	}
+
+	account.SetInterestRate(interestRate, account.UpdatedAt)
+
+	return account
+}
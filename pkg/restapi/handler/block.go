@@ -55,6 +55,21 @@ type ListBlocksRawData struct {
 	Items      [][]byte `json:"items"`
 }
 
+type GetStateRootResponse struct {
+	Data  *GetStateRootData `json:"data,omitempty"`
+	Error *Error            `json:"error,omitempty"`
+}
+
+type GetStateRootData struct {
+	Height    uint32 `json:"height"`
+	BlockHash string `json:"blockHash"`
+	StateRoot string `json:"stateRoot"`
+}
+
+type iStateRoot interface {
+	StateRoot(height uint32) (root umi.Hash, ok bool)
+}
+
 func GetBlock(blockchain storage.IBlockchain) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		setHeaders(w, r)
@@ -86,6 +101,18 @@ func ListBlocks(blockchain storage.IBlockchain) http.HandlerFunc {
 	}
 }
 
+// GetStateRoot возвращает корень состояния леджера после блока.
+func GetStateRoot(blockchain storage.IBlockchain, ledger1 iStateRoot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(GetStateRootResponse)
+		response.Data, response.Error = processGetStateRoot(r, blockchain, ledger1)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
 func processGetBlock(r *http.Request, blockchain storage.IBlockchain) (*umi.Block, *Error) {
 	height := strings.TrimPrefix(r.URL.Path, "/api/blocks/")
 
@@ -166,3 +193,31 @@ func processListBlocksRaw(r *http.Request, blockchain storage.IBlockchain) (*Lis
 
 	return data, nil
 }
+
+func processGetStateRoot(r *http.Request, blockchain storage.IBlockchain, ledger1 iStateRoot) (*GetStateRootData, *Error) {
+	height := strings.TrimPrefix(r.URL.Path, "/api/blocks/")
+	height = strings.TrimSuffix(height, "/state-root")
+
+	blockHeight, err := strconv.ParseUint(height, 10, 32)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	block, err := blockchain.Block(uint32(blockHeight))
+	if err != nil {
+		return nil, NewError(404, err.Error())
+	}
+
+	root, ok := ledger1.StateRoot(uint32(blockHeight))
+	if !ok {
+		return nil, NewError(404, "State root not found")
+	}
+
+	data := &GetStateRootData{
+		Height:    uint32(blockHeight),
+		BlockHash: block.Hash().String(),
+		StateRoot: root.String(),
+	}
+
+	return data, nil
+}
@@ -28,6 +28,7 @@ import (
 	"strings"
 
 	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
@@ -37,6 +38,8 @@ var (
 	ErrOutOfRange = errors.New("out of range")
 	ErrLimit      = errors.New("limit")
 	ErrOffset     = errors.New("offset")
+	ErrType       = errors.New("type")
+	ErrParam      = errors.New("param")
 )
 
 type iLedger interface {
@@ -48,12 +51,18 @@ type iLedger interface {
 type iMempool interface {
 	Mempool() (transactions []*umi.Transaction)
 	Transactions(address umi.Address) (transactions []*umi.Transaction)
+	Transaction(hash umi.Hash) (transaction *umi.Transaction, ok bool)
 	Push(transaction umi.Transaction) error
 	UnconfirmedBalance(address umi.Address) int64
 }
 
 type iNftMempool interface {
 	Push(transaction []byte) error
+	Transaction(hash umi.Hash) (transaction []byte, ok bool)
+}
+
+type iMempoolMetrics interface {
+	Metrics() storage.MempoolMetrics
 }
 
 type Error struct {
@@ -29,6 +29,7 @@ import (
 	"strings"
 	"time"
 
+	"gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
@@ -95,6 +96,47 @@ func ListMempoolByAddress(mempool iMempool) http.HandlerFunc {
 	}
 }
 
+type GetMempoolMetricsResponse struct {
+	Data  *GetMempoolMetricsData `json:"data,omitempty"`
+	Error *Error                 `json:"error,omitempty"`
+}
+
+type GetMempoolMetricsData struct {
+	Mempool    MempoolMetrics `json:"mempool"`
+	NftMempool MempoolMetrics `json:"nftMempool"`
+}
+
+// MempoolMetrics — размер мемпула и количество вытесненных и отклоненных из-за ограничений транзакций.
+type MempoolMetrics struct {
+	Count   int    `json:"count"`
+	Bytes   int    `json:"bytes"`
+	Evicted uint64 `json:"evicted"`
+	Refused uint64 `json:"refused"`
+}
+
+func GetMempoolMetrics(mempool, nftMempool iMempoolMetrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(GetMempoolMetricsResponse)
+		response.Data = &GetMempoolMetricsData{
+			Mempool:    newMempoolMetrics(mempool.Metrics()),
+			NftMempool: newMempoolMetrics(nftMempool.Metrics()),
+		}
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func newMempoolMetrics(metrics storage.MempoolMetrics) MempoolMetrics {
+	return MempoolMetrics{
+		Count:   metrics.Count,
+		Bytes:   metrics.Bytes,
+		Evicted: metrics.Evicted,
+		Refused: metrics.Refused,
+	}
+}
+
 func PushMempool(mempool iMempool, nftMempool iNftMempool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		setHeaders(w, r)
@@ -192,7 +234,7 @@ func processPushMempool(r *http.Request, mempool iMempool, nftMempool iNftMempoo
 		}
 
 		if err := nftMempool.Push(request.Data); err != nil {
-			return nil, NewError(400, err.Error())
+			return nil, pushError(err)
 		}
 
 		return (*umi.Transaction)(&request.Data), nil
@@ -205,7 +247,7 @@ func processPushMempool(r *http.Request, mempool iMempool, nftMempool iNftMempoo
 	transaction := (umi.Transaction)(request.Data)
 	txVer := transaction.Version()
 
-	if txVer < umi.TxV8Send || txVer > umi.TxV16Issue {
+	if !isSupportedTxVersion(txVer) {
 		return nil, NewError(400, "Unsupported tx version")
 	}
 
@@ -218,12 +260,27 @@ func processPushMempool(r *http.Request, mempool iMempool, nftMempool iNftMempoo
 	}
 
 	if err := mempool.Push(transaction); err != nil {
-		return nil, NewError(400, err.Error())
+		return nil, pushError(err)
 	}
 
 	return &transaction, nil
 }
 
+// pushError отличает отказ из-за переполнения мемпула от невалидной транзакции:
+// такую транзакцию можно отправить повторно позже.
+func pushError(err error) *Error {
+	if errors.Is(err, storage.ErrMempoolFull) {
+		return NewError(429, err.Error())
+	}
+
+	return NewError(400, err.Error())
+}
+
+// isSupportedTxVersion проверяет, что транзакцию с такой версией можно принять в мемпул.
+func isSupportedTxVersion(txVer uint8) bool {
+	return (txVer >= umi.TxV8Send && txVer <= umi.TxV16Issue) || txVer == umi.TxV19TransferNft
+}
+
 func TxValidate(transaction umi.Transaction) error {
 	currentTime := uint32(time.Now().Unix())
 	txTime := transaction.Timestamp()
@@ -5,15 +5,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
-	"gitlab.com/umitop/umid/pkg/ledger"
 	"gitlab.com/umitop/umid/pkg/nft"
+	"gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
+type iNftOwner interface {
+	NftOwner(hash umi.Hash) (owner umi.Address, ok bool)
+}
+
+type iNftsByAddr interface {
+	NftsByAddr(addr umi.Address) []umi.Hash
+}
+
 type GetNftMetaResponse struct {
 	Data  *json.RawMessage `json:"data,omitempty"`
 	Error *Error           `json:"error,omitempty"`
@@ -25,8 +34,27 @@ type GetNftRawResponse struct {
 }
 
 type ListNftsByAddressResponse struct {
-	Data  *[]string `json:"data,omitempty"`
-	Error *Error    `json:"error,omitempty"`
+	Data  *ListNftsData `json:"data,omitempty"`
+	Error *Error        `json:"error,omitempty"`
+}
+
+type ListNftsData struct {
+	TotalCount int       `json:"totalCount"`
+	Items      []NftItem `json:"items"`
+}
+
+// NftItem описывает NFT в списках. Если данных NFT нет в хранилище, заполняется только хеш.
+type NftItem struct {
+	Hash        string           `json:"hash"`
+	ContentType *string          `json:"contentType,omitempty"`
+	Size        *int             `json:"size,omitempty"`
+	Timestamp   *string          `json:"timestamp,omitempty"`
+	Meta        *json.RawMessage `json:"meta,omitempty"`
+}
+
+type SearchNftsResponse struct {
+	Data  *ListNftsData `json:"data,omitempty"`
+	Error *Error        `json:"error,omitempty"`
 }
 
 type ListNftRawResponse struct {
@@ -34,18 +62,100 @@ type ListNftRawResponse struct {
 	Error *Error    `json:"error,omitempty"`
 }
 
-func ListNftsByAddress(ledger1 *ledger.Ledger) http.HandlerFunc {
+type GetNftHistoryResponse struct {
+	Data  *GetNftHistoryData `json:"data,omitempty"`
+	Error *Error             `json:"error,omitempty"`
+}
+
+// GetNftHistoryData содержит текущего владельца NFT и транзакции выпуска и передачи
+// в порядке подтверждения.
+type GetNftHistoryData struct {
+	Hash       string            `json:"hash"`
+	Owner      *string           `json:"owner,omitempty"`
+	TotalCount int               `json:"totalCount"`
+	Items      []umi.Transaction `json:"items"`
+}
+
+func GetNftHistory(blockchain storage.IBlockchain, index *storage.Index, ledger1 iNftOwner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(GetNftHistoryResponse)
+		response.Data, response.Error = processGetNftHistory(r, blockchain, index, ledger1)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func processGetNftHistory(r *http.Request, blockchain storage.IBlockchain, index *storage.Index,
+	ledger1 iNftOwner) (*GetNftHistoryData, *Error) {
+	hexHash := strings.TrimPrefix(r.URL.Path, "/api/nfts/")
+	hexHash = strings.TrimSuffix(hexHash, "/history")
+
+	if len(hexHash) != 64 {
+		return nil, NewError(404, "Not found")
+	}
+
+	hashSlice, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return nil, NewError(404, "Not found")
+	}
+
+	var hash umi.Hash
+	copy(hash[:], hashSlice)
+
+	txs, ok := index.TransactionsByNft(hash)
+	if !ok {
+		return nil, NewError(404, "Not found")
+	}
+
+	totalCount := len(*txs)
+
+	firstIndex, lastIndex, err := ParseParams(r, totalCount)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	txz := (*txs)[firstIndex:lastIndex]
+	transactions := make([]umi.Transaction, 0, len(txz))
+
+	for _, key := range txz {
+		transaction, ok := blockchain.Transaction(uint32(key>>16), uint16(key&0xFFFF))
+		if !ok {
+			return nil, NewError(503, "Internal error")
+		}
+
+		transactions = append(transactions, transaction)
+	}
+
+	data := &GetNftHistoryData{
+		Hash:       hash.String(),
+		TotalCount: totalCount,
+		Items:      transactions,
+	}
+
+	if owner, ok := ledger1.NftOwner(hash); ok {
+		data.Owner = new(string)
+		*data.Owner = owner.String()
+	}
+
+	return data, nil
+}
+
+// ListNftsByAddress возвращает NFT адреса постранично вместе с мета-данными, типом содержимого,
+// размером и временем выпуска, чтобы клиенту не нужно было запрашивать каждый NFT отдельно.
+func ListNftsByAddress(ledger1 iNftsByAddr, nftStorage *nft.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		setHeaders(w, r)
 
 		response := new(ListNftsByAddressResponse)
-		response.Data, response.Error = processListNftsByAddress(r, ledger1)
+		response.Data, response.Error = processListNftsByAddress(r, ledger1, nftStorage)
 
 		_ = json.NewEncoder(w).Encode(response)
 	}
 }
 
-func processListNftsByAddress(r *http.Request, ledger1 *ledger.Ledger) (*[]string, *Error) {
+func processListNftsByAddress(r *http.Request, ledger1 iNftsByAddr, nftStorage *nft.Storage) (*ListNftsData, *Error) {
 	bech32 := strings.TrimPrefix(r.URL.Path, "/api/addresses/")
 	bech32 = strings.TrimSuffix(bech32, "/nfts")
 
@@ -55,14 +165,65 @@ func processListNftsByAddress(r *http.Request, ledger1 *ledger.Ledger) (*[]strin
 	}
 
 	nfts := ledger1.NftsByAddr(address)
+	totalCount := len(nfts)
+
+	firstIndex, lastIndex, err := ParseParams(r, totalCount)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	items := make([]NftItem, 0, lastIndex-firstIndex)
+
+	for _, hash := range nfts[firstIndex:lastIndex] {
+		items = append(items, newNftItem(hash, nftStorage))
+	}
+
+	data := &ListNftsData{
+		TotalCount: totalCount,
+		Items:      items,
+	}
+
+	return data, nil
+}
+
+func newNftItem(hash umi.Hash, nftStorage *nft.Storage) NftItem {
+	item := NftItem{
+		Hash: hash.String(),
+	}
+
+	meta, data, err := nftStorage.ParsedData(hash)
+	if err != nil {
+		return item
+	}
+
+	epoch, _ := nftStorage.Timestamp(hash)
 
-	hashes := make([]string, 0, len(nfts))
+	size := len(data)
+	contentType := nftContentType(meta)
+	timestamp := time.Unix(int64(epoch), 0).UTC().Format(time.RFC3339)
 
-	for _, hash := range nfts {
-		hashes = append(hashes, hash.String())
+	item.ContentType = &contentType
+	item.Size = &size
+	item.Timestamp = &timestamp
+
+	if len(meta) > 0 {
+		item.Meta = &meta
+	}
+
+	return item
+}
+
+// nftContentType возвращает тип содержимого NFT из мета-данных.
+func nftContentType(meta json.RawMessage) string {
+	m := struct {
+		ContentType *string `json:"contentType,omitempty"`
+	}{}
+
+	if err := json.Unmarshal(meta, &m); err == nil && m.ContentType != nil {
+		return *m.ContentType
 	}
 
-	return &hashes, nil
+	return "application/octet-stream"
 }
 
 func ListNft(nftStorage *nft.Storage) http.HandlerFunc {
@@ -80,6 +241,127 @@ func ListNft(nftStorage *nft.Storage) http.HandlerFunc {
 	}
 }
 
+// SearchNfts ищет NFT по мета-данным, типу содержимого, отправителю, времени выпуска и размеру.
+func SearchNfts(nftStorage *nft.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(SearchNftsResponse)
+		response.Data, response.Error = processSearchNfts(r, nftStorage)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func processSearchNfts(r *http.Request, nftStorage *nft.Storage) (*ListNftsData, *Error) {
+	query, err := parseNftQuery(r)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	hashes := nftStorage.Search(query)
+	totalCount := len(hashes)
+
+	firstIndex, lastIndex, err := ParseParams(r, totalCount)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	items := make([]NftItem, 0, lastIndex-firstIndex)
+
+	for _, hash := range hashes[firstIndex:lastIndex] {
+		items = append(items, newNftItem(hash, nftStorage))
+	}
+
+	data := &ListNftsData{
+		TotalCount: totalCount,
+		Items:      items,
+	}
+
+	return data, nil
+}
+
+// parseNftQuery разбирает параметры поиска NFT: contentType, sender, from и to (метка времени
+// выпуска), minSize и maxSize (размер данных), meta.<ключ> (значение ключа мета-данных).
+func parseNftQuery(r *http.Request) (*nft.Query, error) {
+	query := &nft.Query{
+		Meta: make(map[string]string),
+	}
+
+	values := r.URL.Query()
+
+	if contentType := values.Get("contentType"); contentType != "" {
+		query.ContentType = &contentType
+	}
+
+	if bech32 := values.Get("sender"); bech32 != "" {
+		sender, err := umi.ParseAddress(bech32)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		query.Sender = &sender
+	}
+
+	var err error
+
+	if query.From, err = parseUint32Param(values, "from"); err != nil {
+		return nil, err
+	}
+
+	if query.To, err = parseUint32Param(values, "to"); err != nil {
+		return nil, err
+	}
+
+	if query.MinSize, err = parseSizeParam(values, "minSize"); err != nil {
+		return nil, err
+	}
+
+	if query.MaxSize, err = parseSizeParam(values, "maxSize"); err != nil {
+		return nil, err
+	}
+
+	for name := range values {
+		if key := strings.TrimPrefix(name, "meta."); key != name && key != "" {
+			query.Meta[key] = values.Get(name)
+		}
+	}
+
+	return query, nil
+}
+
+func parseUint32Param(values url.Values, name string) (*uint32, error) {
+	str := values.Get(name)
+	if str == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseUint(str, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrParam, name, err.Error())
+	}
+
+	result := uint32(value)
+
+	return &result, nil
+}
+
+func parseSizeParam(values url.Values, name string) (*int, error) {
+	str := values.Get(name)
+	if str == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseUint(str, 10, 31)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrParam, name, err.Error())
+	}
+
+	result := int(value)
+
+	return &result, nil
+}
+
 func processListNft(r *http.Request, nftStorage *nft.Storage) (*[][]byte, *Error) {
 	var height int
 
@@ -138,18 +420,7 @@ func GetNft(nftStorage *nft.Storage) http.HandlerFunc {
 			_ = json.NewEncoder(w).Encode(response)
 
 		default:
-			m := struct {
-				ContentType *string `json:"contentType,omitempty"`
-			}{}
-
-			contentType := "application/octet-stream"
-
-			if err := json.Unmarshal(tx.Meta(), &m); err == nil {
-				if m.ContentType != nil {
-					contentType = *m.ContentType
-				}
-			}
-
+			contentType := nftContentType(tx.Meta())
 			data := tx.Data()
 
 			w.Header().Set("Content-Type", contentType)
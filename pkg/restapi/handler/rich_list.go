@@ -0,0 +1,161 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+type ListAccountsResponse struct {
+	Data  *ListAccountsData `json:"data,omitempty"`
+	Error *Error            `json:"error,omitempty"`
+}
+
+type ListAccountsData struct {
+	TotalCount int                 `json:"totalCount"`
+	Items      []*ListAccountsItem `json:"items"`
+}
+
+type ListAccountsItem struct {
+	Address          string `json:"address"`
+	Type             string `json:"type"`
+	Balance          uint64 `json:"balance"`
+	InterestRate     uint16 `json:"interestRate"`
+	TransactionCount uint64 `json:"transactionCount"`
+}
+
+type iRichList interface {
+	RichListCount(prefixes []umi.Prefix, types []umi.AccountType) int
+	RichList(prefixes []umi.Prefix, types []umi.AccountType, timestamp uint32, first, last int) []*ledger.RichListItem
+}
+
+var accountTypes = map[string]umi.AccountType{
+	"genesis": umi.Genesis,
+	"umi":     umi.Umi,
+	"deposit": umi.Deposit,
+	"transit": umi.Transit,
+	"fee":     umi.Fee,
+	"profit":  umi.Profit,
+	"dev":     umi.Dev,
+}
+
+// ListStructureAccounts возвращает аккаунты структуры, упорядоченные по убыванию баланса.
+// Параметр ?type= (через запятую) ограничивает типы аккаунтов.
+func ListStructureAccounts(ledger1 iLedger, richList iRichList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(ListAccountsResponse)
+		response.Data, response.Error = processListStructureAccounts(r, ledger1, richList)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// ListTopAccounts возвращает аккаунты всех структур, упорядоченные по убыванию баланса.
+func ListTopAccounts(richList iRichList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(ListAccountsResponse)
+		response.Data, response.Error = processRichList(r, richList, nil)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func processListStructureAccounts(r *http.Request, ledger1 iLedger, richList iRichList) (*ListAccountsData, *Error) {
+	hrp := strings.TrimPrefix(r.URL.Path, "/api/structures/")
+	hrp = strings.TrimSuffix(hrp, "/accounts")
+
+	if len(hrp) != 3 {
+		return nil, NewError(404, "Not found")
+	}
+
+	prefix := umi.ParsePrefix(hrp)
+
+	if _, ok := ledger1.Structure(prefix); !ok {
+		return nil, NewError(404, "Not found")
+	}
+
+	return processRichList(r, richList, []umi.Prefix{prefix})
+}
+
+func processRichList(r *http.Request, richList iRichList, prefixes []umi.Prefix) (*ListAccountsData, *Error) {
+	types, err := parseAccountTypes(r)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	totalCount := richList.RichListCount(prefixes, types)
+
+	firstIndex, lastIndex, err := ParseParams(r, totalCount)
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	timestamp := uint32(time.Now().Unix())
+	accounts := richList.RichList(prefixes, types, timestamp, firstIndex, lastIndex)
+
+	data := &ListAccountsData{
+		TotalCount: totalCount,
+		Items:      make([]*ListAccountsItem, 0, len(accounts)),
+	}
+
+	for _, item := range accounts {
+		data.Items = append(data.Items, &ListAccountsItem{
+			Address:          item.Address.String(),
+			Type:             item.Account.Type.String(),
+			Balance:          item.Account.BalanceAt(timestamp),
+			InterestRate:     item.Account.InterestRate,
+			TransactionCount: item.Account.TransactionCount,
+		})
+	}
+
+	return data, nil
+}
+
+func parseAccountTypes(r *http.Request) ([]umi.AccountType, error) {
+	value := r.URL.Query().Get("type")
+	if value == "" {
+		return nil, nil
+	}
+
+	types := make([]umi.AccountType, 0)
+
+	for _, name := range strings.Split(value, ",") {
+		accountType, ok := accountTypes[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown account type %s", ErrType, name)
+		}
+
+		types = append(types, accountType)
+	}
+
+	return types, nil
+}
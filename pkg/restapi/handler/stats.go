@@ -0,0 +1,70 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gitlab.com/umitop/umid/pkg/ledger"
+)
+
+type GetSupplyResponse struct {
+	Data  *ledger.Supply `json:"data,omitempty"`
+	Error *Error         `json:"error,omitempty"`
+}
+
+type iSupply interface {
+	Supply(height uint32) (*ledger.Supply, error)
+}
+
+// GetSupply возвращает статистику эмиссии по структурам на последнем блоке или на высоте ?height=.
+func GetSupply(ledger1 iSupply) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(GetSupplyResponse)
+		response.Data, response.Error = processGetSupply(r, ledger1)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func processGetSupply(r *http.Request, ledger1 iSupply) (*ledger.Supply, *Error) {
+	var height uint64
+
+	if value := r.URL.Query().Get("height"); value != "" {
+		var err error
+
+		height, err = strconv.ParseUint(value, 10, 32)
+		if err != nil || height == 0 {
+			return nil, NewError(400, "invalid height")
+		}
+	}
+
+	supply, err := ledger1.Supply(uint32(height))
+	if err != nil {
+		return nil, NewError(404, err.Error())
+	}
+
+	return supply, nil
+}
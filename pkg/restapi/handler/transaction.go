@@ -21,6 +21,7 @@
 package handler
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -50,6 +51,28 @@ type ListTransactionsRawData struct {
 	Items      [][]byte `json:"items"`
 }
 
+type GetTransactionResponse struct {
+	Data  *GetTransactionData `json:"data,omitempty"`
+	Error *Error              `json:"error,omitempty"`
+}
+
+type GetTransactionData struct {
+	Status      string           `json:"status"`
+	Transaction *umi.Transaction `json:"transaction"`
+}
+
+func GetTransaction(
+	blockchain storage.IBlockchain, index *storage.Index, mempool iMempool, nftMempool iNftMempool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(GetTransactionResponse)
+		response.Data, response.Error = processGetTransaction(r, blockchain, index, mempool, nftMempool)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
 func ListTransactionsByAddress(blockchain storage.IBlockchain, index *storage.Index) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		setHeaders(w, r)
@@ -125,6 +148,44 @@ func processListTransactionsByAddress(
 	return data, nil
 }
 
+func processGetTransaction(r *http.Request, blockchain storage.IBlockchain, index *storage.Index,
+	mempool iMempool, nftMempool iNftMempool) (*GetTransactionData, *Error) {
+	hexHash := strings.TrimPrefix(r.URL.Path, "/api/transactions/")
+
+	if len(hexHash) != 64 {
+		return nil, NewError(404, "Not Found")
+	}
+
+	hashSlice, err := hex.DecodeString(hexHash)
+	if err != nil {
+		return nil, NewError(404, "Not Found")
+	}
+
+	var hash umi.Hash
+	copy(hash[:], hashSlice)
+
+	if blockHeight, txIndex, ok := index.Transaction(hash); ok {
+		transaction, ok := blockchain.Transaction(blockHeight, txIndex)
+		if !ok {
+			return nil, NewError(503, "Internal error")
+		}
+
+		return &GetTransactionData{Status: "confirmed", Transaction: &transaction}, nil
+	}
+
+	if transaction, ok := mempool.Transaction(hash); ok {
+		return &GetTransactionData{Status: "pending", Transaction: transaction}, nil
+	}
+
+	if tx, ok := nftMempool.Transaction(hash); ok {
+		transaction := umi.Transaction(tx)
+
+		return &GetTransactionData{Status: "pending", Transaction: &transaction}, nil
+	}
+
+	return nil, NewError(404, "Not Found")
+}
+
 func processListTransactionsByBlock(r *http.Request, blockchain storage.IBlockchain) (*ListTransactionsData, *Error) {
 	height := strings.TrimPrefix(r.URL.Path, "/api/blocks/")
 	height = strings.TrimSuffix(height, "/transactions")
@@ -43,6 +43,7 @@ type CreateTransactionRequest struct {
 	Seed             *[]byte          `json:"seed,omitempty"`
 	NftMeta          *json.RawMessage `json:"nftMeta,omitempty"`
 	NftData          *[]byte          `json:"nftData,omitempty"`
+	NftHeight        *uint64          `json:"nftHeight,omitempty"`
 }
 
 type CreateTransactionResponse struct {
@@ -113,6 +114,9 @@ func verifyCreateTransactionRequest(request *CreateTransactionRequest) *Error {
 	case umi.TxMintNft:
 		return verifyTxMintNft(request)
 
+	case umi.TxTransferNft:
+		return verifyTxTransferNft(request)
+
 	default:
 		return NewError(-1, "Некорректное значение параметра 'type'.")
 	}
@@ -246,6 +250,26 @@ func verifyTxMintNft(request *CreateTransactionRequest) *Error {
 	return nil
 }
 
+func verifyTxTransferNft(request *CreateTransactionRequest) *Error {
+	if err := verifySender(request); err != nil {
+		return err
+	}
+
+	if err := verifyRecipient(request); err != nil {
+		return err
+	}
+
+	if request.NftHeight == nil {
+		return NewError(-1, "Для транзакции имеющий тип 'transferNft' параметр 'nftHeight' является обязательным.")
+	}
+
+	if *request.NftHeight == 0 {
+		return NewError(-1, "Значение параметра 'nftHeight' должно быть больше нуля.")
+	}
+
+	return nil
+}
+
 func buildTransaction(request *CreateTransactionRequest) umi.Transaction { //nolint:funlen,revive // Временно
 	transaction := umi.NewTransaction()
 
@@ -317,6 +341,13 @@ func buildTransaction(request *CreateTransactionRequest) umi.Transaction { //nol
 		transaction.SetRecipient(recipient)
 		transaction.SetAmount(*request.Amount)
 
+	case umi.TxTransferNft:
+		recipient, _ := umi.ParseAddress(*request.RecipientAddress)
+
+		transaction.SetVersion(umi.TxV19TransferNft)
+		transaction.SetRecipient(recipient)
+		transaction.SetNftHeight(*request.NftHeight)
+
 	case umi.TxMintNft:
 		tx := nft.NewTransaction()
 
@@ -0,0 +1,150 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+type SimulateTransactionRequest struct {
+	Data []byte `json:"data,omitempty"`
+
+	CreateTransactionRequest
+}
+
+type SimulateTransactionResponse struct {
+	Data  *umi.Transaction `json:"data,omitempty"`
+	Error *Error           `json:"error,omitempty"`
+}
+
+type iSimulator interface {
+	Simulate(pending []umi.Transaction, transaction umi.Transaction, timestamp uint32) (umi.Transaction, error)
+}
+
+// SimulateTransaction проверяет транзакцию на текущем состоянии леджера с учетом мемпула и возвращает
+// ее такой, какой она была бы в блоке: с комиссией, балансами и типами аккаунтов. Транзакция
+// передается либо в сыром виде в поле data, либо параметрами как в /api/transaction:create.
+func SimulateTransaction(simulator iSimulator, mempool iMempool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, r)
+
+		response := new(SimulateTransactionResponse)
+		response.Data, response.Error = processSimulateTransaction(r, simulator, mempool)
+
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func processSimulateTransaction(r *http.Request, simulator iSimulator, mempool iMempool) (*umi.Transaction, *Error) {
+	contentType := r.Header.Get("Content-Type")
+
+	if !strings.HasPrefix(contentType, "application/json") {
+		return nil, NewError(400, "'Content-Type' must be 'application/json'")
+	}
+
+	request := new(SimulateTransactionRequest)
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	transaction, errResp := parseSimulateTransactionRequest(request)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	if err := TxValidate(transaction); err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	confirmed, err := simulator.Simulate(pendingTransactions(mempool), transaction, uint32(time.Now().Unix()))
+	if err != nil {
+		return nil, NewError(400, err.Error())
+	}
+
+	return &confirmed, nil
+}
+
+func parseSimulateTransactionRequest(request *SimulateTransactionRequest) (umi.Transaction, *Error) {
+	if request.Data != nil {
+		if len(request.Data) != umi.TxLength {
+			return nil, NewError(400, "Malformed transaction")
+		}
+
+		transaction := (umi.Transaction)(request.Data)
+
+		if !isSupportedTxVersion(transaction.Version()) {
+			return nil, NewError(400, "Unsupported tx version")
+		}
+
+		if err := transaction.Verify(); err != nil {
+			return nil, NewError(400, err.Error())
+		}
+
+		return transaction, nil
+	}
+
+	// Для симуляции подпись не нужна, поэтому seed необязателен.
+	if request.Seed == nil {
+		seed := make([]byte, 32)
+		request.Seed = &seed
+	}
+
+	if err := verifyCreateTransactionRequest(&request.CreateTransactionRequest); err != nil {
+		return nil, err
+	}
+
+	switch *request.Type {
+	case umi.TxGenesis, umi.TxMintNft:
+		return nil, NewError(400, "Unsupported tx version")
+	}
+
+	return buildTransaction(&request.CreateTransactionRequest), nil
+}
+
+// pendingTransactions возвращает транзакции мемпула в порядке меток времени.
+func pendingTransactions(mempool iMempool) []umi.Transaction {
+	transactions := mempool.Mempool()
+	pending := make([]umi.Transaction, 0, len(transactions))
+
+	for _, transaction := range transactions {
+		pending = append(pending, *transaction)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Timestamp() != pending[j].Timestamp() {
+			return pending[i].Timestamp() < pending[j].Timestamp()
+		}
+
+		hashI, hashJ := pending[i].Hash(), pending[j].Hash()
+
+		return bytes.Compare(hashI[:], hashJ[:]) < 0
+	})
+
+	return pending
+}
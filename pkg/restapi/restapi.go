@@ -30,6 +30,7 @@ import (
 type RestAPI struct {
 	blockchain storage.IBlockchain
 	ledger     *ledger.Ledger
+	simulator  *ledger.ConfirmerLegacy
 	mempool    *storage.Mempool
 	nftMempool *nft.Mempool
 	nftStorage *nft.Storage
@@ -47,6 +48,7 @@ func (restApi *RestAPI) SetBlockchain(blockchain storage.IBlockchain) {
 
 func (restApi *RestAPI) SetLedger(ledger1 *ledger.Ledger) {
 	restApi.ledger = ledger1
+	restApi.simulator = ledger.NewConfirmerLegacy(ledger1)
 }
 
 func (restApi *RestAPI) SetMempool(mempool *storage.Mempool) {
@@ -52,6 +52,22 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 			handlerFunc = handler.MethodNotAllowed(http.MethodPost)
 		}
 
+	case path == "/api/transaction:simulate":
+		switch r.Method {
+		case http.MethodPost:
+			handlerFunc = handler.SimulateTransaction(restApi.simulator, restApi.mempool)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodPost)
+		}
+
+	case path == "/api/mempool:metrics":
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.GetMempoolMetrics(restApi.mempool, restApi.nftMempool)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
 	case path == "/api/mempool":
 		switch r.Method {
 		case http.MethodGet:
@@ -65,7 +81,11 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/api/addresses/") && strings.HasSuffix(path, "/account"):
 		switch r.Method {
 		case http.MethodGet:
-			handlerFunc = handler.GetAccount(restApi.ledger, restApi.mempool)
+			if query := r.URL.Query(); query.Get("height") != "" || query.Get("at") != "" {
+				handlerFunc = handler.GetAccountHistory(restApi.blockchain, restApi.index, restApi.ledger)
+			} else {
+				handlerFunc = handler.GetAccount(restApi.ledger, restApi.mempool)
+			}
 		default:
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
@@ -89,7 +109,15 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/api/addresses/") && strings.HasSuffix(path, "/nfts"):
 		switch r.Method {
 		case http.MethodGet:
-			handlerFunc = handler.ListNftsByAddress(restApi.ledger)
+			handlerFunc = handler.ListNftsByAddress(restApi.ledger, restApi.nftStorage)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
+	case strings.HasPrefix(path, "/api/transactions/"):
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.GetTransaction(restApi.blockchain, restApi.index, restApi.mempool, restApi.nftMempool)
 		default:
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
@@ -102,6 +130,22 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
 
+	case strings.HasPrefix(path, "/api/structures/") && strings.HasSuffix(path, "/accounts"):
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.ListStructureAccounts(restApi.ledger, restApi.ledger)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
+	case path == "/api/accounts/top":
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.ListTopAccounts(restApi.ledger)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
 	case strings.HasPrefix(path, "/api/structures/"):
 		switch r.Method {
 		case http.MethodGet:
@@ -126,6 +170,14 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
 
+	case strings.HasPrefix(path, "/api/blocks/") && strings.HasSuffix(path, "/state-root"):
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.GetStateRoot(restApi.blockchain, restApi.ledger)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
 	case strings.HasPrefix(path, "/api/blocks/"):
 		switch r.Method {
 		case http.MethodGet:
@@ -134,6 +186,14 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
 
+	case path == "/api/stats/supply":
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.GetSupply(restApi.ledger)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
 	case strings.HasPrefix(path, "/events/addresses/"):
 		switch r.Method {
 		case http.MethodGet:
@@ -150,6 +210,14 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
 
+	case strings.HasPrefix(path, "/api/nfts/") && strings.HasSuffix(path, "/history"):
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.GetNftHistory(restApi.blockchain, restApi.index, restApi.ledger)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
 	case strings.HasPrefix(path, "/api/nfts/"):
 		switch r.Method {
 		case http.MethodGet:
@@ -158,6 +226,14 @@ func (restApi *RestAPI) Router(w http.ResponseWriter, r *http.Request) {
 			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
 		}
 
+	case path == "/api/nfts:search":
+		switch r.Method {
+		case http.MethodGet:
+			handlerFunc = handler.SearchNfts(restApi.nftStorage)
+		default:
+			handlerFunc = handler.MethodNotAllowed(http.MethodGet)
+		}
+
 	case path == "/api/nfts":
 		switch r.Method {
 		case http.MethodGet:
@@ -177,7 +253,7 @@ func (*RestAPI) Healthz(w http.ResponseWriter, _ *http.Request) {
 	_, _ = fmt.Fprint(w, "OK")
 }
 
-func (*RestAPI) Status(w http.ResponseWriter, _ *http.Request) {
+func (restApi *RestAPI) Status(w http.ResponseWriter, _ *http.Request) {
 	// Create a sample for the metric.
 	sample := make([]metrics.Sample, 4)
 	sample[0].Name = "/memory/classes/total:bytes"
@@ -192,4 +268,22 @@ func (*RestAPI) Status(w http.ResponseWriter, _ *http.Request) {
 	_, _ = fmt.Fprintf(w, "Memory that is completely free :     %d\n", sample[1].Value.Uint64())
 	_, _ = fmt.Fprintf(w, "Count of live goroutines: %d\n", sample[2].Value.Uint64())
 	_, _ = fmt.Fprintf(w, "Number of objects: %d\n", sample[3].Value.Uint64())
+
+	if restApi.mempool != nil {
+		m := restApi.mempool.Metrics()
+		_, _ = fmt.Fprintf(w, "Mempool: %d transactions, %d bytes, evicted: %d, refused: %d\n",
+			m.Count, m.Bytes, m.Evicted, m.Refused)
+	}
+
+	if restApi.nftMempool != nil {
+		m := restApi.nftMempool.Metrics()
+		_, _ = fmt.Fprintf(w, "NFT mempool: %d transactions, %d bytes, evicted: %d, refused: %d\n",
+			m.Count, m.Bytes, m.Evicted, m.Refused)
+	}
+
+	if restApi.ledger != nil {
+		if height, hash, ok := restApi.ledger.LastCheckpoint(); ok {
+			_, _ = fmt.Fprintf(w, "Last checkpoint passed: %d %s\n", height, hash)
+		}
+	}
 }
@@ -21,15 +21,20 @@
 package restapi_test
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"gitlab.com/umitop/umid/pkg/config"
 	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/nft"
 	"gitlab.com/umitop/umid/pkg/restapi/handler"
+	"gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
@@ -73,6 +78,10 @@ func (mock *mockMempool) Transactions(address umi.Address) (transactions []*umi.
 	return nil
 }
 
+func (mock *mockMempool) Transaction(hash umi.Hash) (transaction *umi.Transaction, ok bool) {
+	return nil, false
+}
+
 func (mock *mockMempool) Push(transaction umi.Transaction) error {
 	return nil
 }
@@ -228,3 +237,593 @@ func TestEventsHandlerGetAccount_MalformedAddress(t *testing.T) {
 		t.Logf("%s", w.Body.String())
 	}
 }
+
+type mockRateHistory struct {
+	changes []ledger.RateChange
+}
+
+func (mock *mockRateHistory) RateChanges(_ umi.Prefix, from, to uint32) (changes []ledger.RateChange) {
+	for _, change := range mock.changes {
+		if change.Height >= from && change.Height <= to {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+func TestEventsHandlerGetAccountHistory(t *testing.T) {
+	t.Parallel()
+
+	addr := "roy1y8tdlvup2ja964jwp2revprjvnmc4wku80z0eg42sftqwkzwg6vsys7kds"
+	address, _ := umi.ParseAddress(addr)
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	blockchain := storage.NewBlockchainMemory(conf)
+	prevHash := umi.Hash{}
+
+	// Баланс адреса после блока N равен N*100, время блока N равно N*10. Ставка адреса в мета-данных
+	// транзакций нулевая, в блоке 2 структура меняет уровень.
+	for i := 1; i <= 3; i++ {
+		transaction := make(umi.Transaction, umi.TxConfirmedLength)
+		transaction.SetVersion(umi.TxV1Send)
+		transaction.SetSender(address)
+		transaction.SetRecipient(address)
+		transaction.SetBlockHeight(uint32(i))
+		transaction.SetBlockTimestamp(uint32(i * 10))
+		transaction.SetRecipientAccountType(umi.Deposit)
+		transaction.SetRecipientAccountBalance(uint64(i * 100))
+
+		block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+		block.SetPreviousBlockHash(prevHash)
+		block.SetTimestamp(uint32(i * 10))
+		block = append(block, transaction...)
+
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+
+		prevHash = block.Hash()
+	}
+
+	index := storage.NewIndex(conf)
+
+	if err := index.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	if err := index.Sync(blockchain); err != nil {
+		t.Fatal(err)
+	}
+
+	rates := &mockRateHistory{
+		changes: []ledger.RateChange{{Height: 2, Timestamp: 20, LevelInterestRate: 10_00, ProfitPercent: 5_00}},
+	}
+
+	tests := []struct {
+		query   string
+		balance uint64
+		rate    uint16
+		code    int32
+	}{
+		{"height=1", 100, 0, 0},
+		{"height=2", 200, 5_00, 0},
+		{"height=3", 300, 0, 0},
+		{"at=25", 200, 5_00, 0},
+		{"at=1970-01-01T00:00:35Z", 300, 0, 0},
+		{"at=5", 0, 0, 404},
+		{"height=4", 0, 0, 404},
+		{"height=abc", 0, 0, 400},
+	}
+
+	for _, test := range tests {
+		target := fmt.Sprintf("/api/addresses/%s/account?%s", addr, test.query)
+
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+
+		handler.GetAccountHistory(blockchain, index, rates)(w, r)
+
+		resp := handler.GetAccountResponse{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if test.code != 0 {
+			if resp.Error == nil || resp.Error.Code != test.code {
+				t.Errorf("%s: ожидаем ошибку %d, получили %s", test.query, test.code, w.Body.String())
+			}
+
+			continue
+		}
+
+		if resp.Data == nil || resp.Data.ConfirmedBalance != test.balance {
+			t.Errorf("%s: ожидаем баланс %d, получили %s", test.query, test.balance, w.Body.String())
+
+			continue
+		}
+
+		if resp.Data.InterestRate == nil || *resp.Data.InterestRate != test.rate {
+			t.Errorf("%s: ожидаем ставку %d, получили %s", test.query, test.rate, w.Body.String())
+		}
+	}
+}
+
+type mockStateRoot struct{}
+
+func (mock *mockStateRoot) StateRoot(height uint32) (root umi.Hash, ok bool) {
+	root[0] = uint8(height)
+
+	return root, height == 1
+}
+
+func TestEventsHandlerGetStateRoot(t *testing.T) {
+	t.Parallel()
+
+	blockchain := storage.NewBlockchainMemory(config.DefaultConfig())
+
+	if err := blockchain.AppendBlock(storage.GenesisBlock("mainnet")); err != nil {
+		t.Fatal(err)
+	}
+
+	for target, want := range map[string]bool{"/api/blocks/1/state-root": true, "/api/blocks/2/state-root": false} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+
+		handler.GetStateRoot(blockchain, &mockStateRoot{})(w, r)
+
+		resp := handler.GetStateRootResponse{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if got := resp.Data != nil && resp.Data.StateRoot[0:2] == "01"; got != want {
+			t.Errorf("%s: got %s", target, w.Body.String())
+		}
+	}
+}
+
+func TestEventsHandlerSimulateTransaction(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	genesis := storage.GenesisBlock(conf.Network)
+
+	ledger1 := ledger.NewLedger(conf)
+	confirmer := ledger.NewConfirmerLegacy(ledger1)
+	confirmer.SetBlockchain(storage.NewBlockchainMemory(conf))
+
+	if err := confirmer.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := genesis.Transaction(0).Recipient()
+	account, _ := ledger1.Account(sender)
+
+	recipient := sender
+	recipient[33] ^= 0xFF
+
+	tests := []struct {
+		amount  uint64
+		balance uint64
+		message string
+	}{
+		{1, account.Balance - 1, ""},
+		{account.Balance + 1, 0, "insufficient funds"},
+	}
+
+	for _, test := range tests {
+		body := fmt.Sprintf(`{"type":"send","senderAddress":"%s","recipientAddress":"%s","amount":%d}`,
+			sender.String(), recipient.String(), test.amount)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/transaction:simulate", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		handler.SimulateTransaction(ledger.NewConfirmerLegacy(ledger1), &mockMempool{})(w, r)
+
+		resp := struct {
+			Data  *struct{ SenderAccountBalance uint64 } `json:"data"`
+			Error *handler.Error                         `json:"error"`
+		}{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if test.message != "" {
+			if resp.Error == nil || !strings.Contains(resp.Error.Message, test.message) {
+				t.Errorf("ожидаем ошибку '%s', получили %s", test.message, w.Body.String())
+			}
+
+			continue
+		}
+
+		if resp.Data == nil || resp.Data.SenderAccountBalance != test.balance {
+			t.Errorf("ожидаем баланс %d, получили %s", test.balance, w.Body.String())
+		}
+	}
+
+	if _, ok := ledger1.Account(sender); !ok || account.Balance != genesis.Transaction(0).Amount() {
+		t.Error("симуляция не должна менять леджер")
+	}
+}
+
+func TestEventsHandlerGetSupply(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	genesis := storage.GenesisBlock(conf.Network)
+
+	ledger1 := ledger.NewLedger(conf)
+	confirmer := ledger.NewConfirmerLegacy(ledger1)
+	confirmer.SetBlockchain(storage.NewBlockchainMemory(conf))
+
+	if err := confirmer.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	amount := genesis.Transaction(0).Amount()
+
+	tests := []struct {
+		target string
+		code   int32
+	}{
+		{"/api/stats/supply", 0},
+		{"/api/stats/supply?height=1", 0},
+		{"/api/stats/supply?height=2", 404},
+		{"/api/stats/supply?height=abc", 400},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		w := httptest.NewRecorder()
+
+		handler.GetSupply(ledger1)(w, r)
+
+		resp := handler.GetSupplyResponse{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if test.code != 0 {
+			if resp.Error == nil || resp.Error.Code != test.code {
+				t.Errorf("%s: ожидаем код %d, получили %s", test.target, test.code, w.Body.String())
+			}
+
+			continue
+		}
+
+		if resp.Data == nil || resp.Data.Height != 1 || resp.Data.Circulating != amount || resp.Data.Issued != 0 {
+			t.Errorf("%s: got %s", test.target, w.Body.String())
+		}
+	}
+}
+
+func TestEventsHandlerListAccounts(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	genesis := storage.GenesisBlock(conf.Network)
+
+	ledger1 := ledger.NewLedger(conf)
+	confirmer := ledger.NewConfirmerLegacy(ledger1)
+	confirmer.SetBlockchain(storage.NewBlockchainMemory(conf))
+
+	if err := confirmer.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	recipient := genesis.Transaction(0).Recipient()
+
+	tests := []struct {
+		target string
+		total  int
+		code   int32
+	}{
+		{"/api/accounts/top", 1, 0},
+		{"/api/accounts/top?type=umi,deposit", 1, 0},
+		{"/api/structures/umi/accounts", 1, 0},
+		{"/api/structures/umi/accounts?type=deposit", 0, 0},
+		{"/api/structures/umi/accounts?type=bogus", 0, 400},
+		{"/api/structures/xyz/accounts", 0, 404},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		w := httptest.NewRecorder()
+
+		if strings.HasPrefix(test.target, "/api/accounts/") {
+			handler.ListTopAccounts(ledger1)(w, r)
+		} else {
+			handler.ListStructureAccounts(ledger1, ledger1)(w, r)
+		}
+
+		resp := handler.ListAccountsResponse{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if test.code != 0 {
+			if resp.Error == nil || resp.Error.Code != test.code {
+				t.Errorf("%s: ожидаем код %d, получили %s", test.target, test.code, w.Body.String())
+			}
+
+			continue
+		}
+
+		if resp.Data == nil || resp.Data.TotalCount != test.total || len(resp.Data.Items) != test.total {
+			t.Errorf("%s: got %s", test.target, w.Body.String())
+
+			continue
+		}
+
+		if test.total > 0 && resp.Data.Items[0].Address != recipient.String() {
+			t.Errorf("%s: got %s", test.target, w.Body.String())
+		}
+	}
+}
+
+func TestEventsHandlerCreateTransferNft(t *testing.T) {
+	t.Parallel()
+
+	seed := make([]byte, 32)
+	seed[0] = 1
+
+	pub, _ := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+
+	var sender, recipient umi.Address
+
+	sender.SetPrefix(umi.PfxVerNft)
+	sender.SetPublicKey((umi.PublicKey)(pub))
+
+	recipient.SetPrefix(umi.PfxVerNft)
+	recipient[33] = 1
+
+	seedJSON, _ := json.Marshal(seed)
+
+	tests := []struct {
+		nftHeight string
+		code      int32
+	}{
+		{`,"nftHeight":42`, 0},
+		{`,"nftHeight":0`, -1},
+		{``, -1},
+	}
+
+	for _, test := range tests {
+		body := fmt.Sprintf(`{"type":"transferNft","senderAddress":"%s","recipientAddress":"%s","seed":%s%s}`,
+			sender.String(), recipient.String(), seedJSON, test.nftHeight)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/transaction:create", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		handler.CreateTransaction()(w, r)
+
+		resp := handler.CreateTransactionResponse{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if test.code != 0 {
+			if resp.Error == nil || resp.Error.Code != test.code {
+				t.Errorf("ожидаем код %d, получили %s", test.code, w.Body.String())
+			}
+
+			continue
+		}
+
+		transaction := (umi.Transaction)(resp.Data)
+
+		if resp.Error != nil || transaction.Type() != umi.TxTransferNft || transaction.NftHeight() != 42 {
+			t.Fatalf("got %s", w.Body.String())
+		}
+
+		if err := transaction.Verify(); err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+	}
+}
+
+type mockNftsByAddr []umi.Hash
+
+func (mock mockNftsByAddr) NftsByAddr(_ umi.Address) []umi.Hash {
+	return mock
+}
+
+func TestEventsHandlerListNftsByAddress(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	nftStorage := nft.NewStorage(conf)
+
+	if err := nftStorage.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer nftStorage.Close()
+
+	token := nft.NewTransaction()
+	token.SetTimestamp(1)
+	token.SetMeta(json.RawMessage(`{"contentType":"image/png","name":"test"}`))
+	token.SetData([]byte{1, 2, 3})
+
+	var address umi.Address
+
+	address.SetPrefix(umi.PfxVerNft)
+
+	token.SetSender(address)
+	token.Sign(ed25519.NewKeyFromSeed(make([]byte, 32)))
+
+	if err := nftStorage.AppendData(*token); err != nil {
+		t.Fatal(err)
+	}
+
+	owned := mockNftsByAddr{token.Hash(), {0xFF}}
+	target := fmt.Sprintf("/api/addresses/%s/nfts?limit=1", address.String())
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	w := httptest.NewRecorder()
+
+	handler.ListNftsByAddress(owned, nftStorage)(w, r)
+
+	resp := handler.ListNftsByAddressResponse{}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("JSON parsing error: %v", err)
+	}
+
+	if resp.Data == nil || resp.Data.TotalCount != 2 || len(resp.Data.Items) != 1 {
+		t.Fatalf("got %s", w.Body.String())
+	}
+
+	item := resp.Data.Items[0]
+
+	if item.ContentType == nil || *item.ContentType != "image/png" || item.Size == nil || *item.Size != 3 ||
+		item.Meta == nil || item.Timestamp == nil || *item.Timestamp != "1970-01-01T00:00:01Z" {
+		t.Errorf("got %s", w.Body.String())
+	}
+}
+
+func TestEventsHandlerSearchNfts(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	nftStorage := nft.NewStorage(conf)
+
+	if err := nftStorage.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sender, other umi.Address
+
+	sender.SetPrefix(umi.PfxVerNft)
+	other.SetPrefix(umi.PfxVerNft)
+	other[33] = 1
+
+	for i, meta := range []string{
+		`{"contentType":"image/png","artist":"alice","year":2021}`,
+		`{"contentType":"image/png","artist":"bob"}`,
+		`{"contentType":"text/plain","artist":"alice","year":2022}`,
+		``,
+	} {
+		token := nft.NewTransaction()
+		token.SetTimestamp(uint32(i + 1))
+		token.SetMeta(json.RawMessage(meta))
+		token.SetData(make([]byte, i*10))
+
+		if i == 3 {
+			token.SetSender(sender)
+		} else {
+			token.SetSender(other)
+		}
+
+		token.Sign(ed25519.NewKeyFromSeed(make([]byte, 32)))
+
+		if err := nftStorage.AppendData(*token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nftStorage.Close()
+
+	// Индекс строится заново при чтении хранилища.
+	nftStorage = nft.NewStorage(conf)
+
+	if err := nftStorage.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer nftStorage.Close()
+
+	if err := nftStorage.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		total int
+		code  int32
+	}{
+		{"", 4, 0},
+		{"contentType=image/png", 2, 0},
+		{"contentType=application/octet-stream", 1, 0},
+		{"meta.artist=alice", 2, 0},
+		{"meta.artist=alice&meta.year=2022", 1, 0},
+		{"meta.artist=alice&contentType=image/png", 1, 0},
+		{"from=2&to=3", 2, 0},
+		{"minSize=10&maxSize=20", 2, 0},
+		{"sender=" + sender.String(), 1, 0},
+		{"meta.artist=carol", 0, 0},
+		{"from=abc", 0, 400},
+		{"sender=abc", 0, 400},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/nfts:search?"+test.query, nil)
+		w := httptest.NewRecorder()
+
+		handler.SearchNfts(nftStorage)(w, r)
+
+		resp := handler.SearchNftsResponse{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("JSON parsing error: %v", err)
+		}
+
+		if test.code != 0 {
+			if resp.Error == nil || resp.Error.Code != test.code {
+				t.Errorf("%s: ожидаем код %d, получили %s", test.query, test.code, w.Body.String())
+			}
+
+			continue
+		}
+
+		if resp.Data == nil || resp.Data.TotalCount != test.total || len(resp.Data.Items) != test.total {
+			t.Errorf("%s: ожидаем %d NFT, получили %s", test.query, test.total, w.Body.String())
+		}
+	}
+}
+
+type mockMempoolMetrics storage.MempoolMetrics
+
+func (mock mockMempoolMetrics) Metrics() storage.MempoolMetrics {
+	return storage.MempoolMetrics(mock)
+}
+
+func TestEventsHandlerGetMempoolMetrics(t *testing.T) {
+	t.Parallel()
+
+	mempool := mockMempoolMetrics{Count: 2, Bytes: 300, Evicted: 3, Refused: 4}
+	nftMempool := mockMempoolMetrics{Count: 1, Bytes: 100, Evicted: 5, Refused: 6}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/mempool:metrics", nil)
+	w := httptest.NewRecorder()
+
+	handler.GetMempoolMetrics(mempool, nftMempool)(w, r)
+
+	resp := handler.GetMempoolMetricsResponse{}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("JSON parsing error: %v", err)
+	}
+
+	expected := handler.GetMempoolMetricsData{
+		Mempool:    handler.MempoolMetrics{Count: 2, Bytes: 300, Evicted: 3, Refused: 4},
+		NftMempool: handler.MempoolMetrics{Count: 1, Bytes: 100, Evicted: 5, Refused: 6},
+	}
+
+	if resp.Data == nil || *resp.Data != expected {
+		t.Errorf("got %s", w.Body.String())
+	}
+}
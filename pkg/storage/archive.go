@@ -0,0 +1,311 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// Архив состоит из заголовка (сигнатура, версия, сеть, высота первого блока) и записей
+// вида: тип (1 байт), длина (4 байта), данные, CRC32 данных (4 байта). Последняя запись
+// содержит количество блоков, количество NFT и SHA-256 всех предыдущих байт архива.
+// Архив целиком может быть сжат gzip, это определяется по первым байтам файла.
+const (
+	ArchiveBlock uint8 = 1
+	ArchiveNft   uint8 = 2
+
+	archiveEnd       uint8 = 0
+	archiveMagic           = "UMIARCH"
+	archiveVersion         = 1
+	archiveEndLength       = 4 + 4 + sha256.Size
+	archiveMaxRecord       = 64 << 20
+)
+
+var ErrArchive = errors.New("archive")
+
+// ArchiveWriter записывает блоки и NFT в архив.
+type ArchiveWriter struct {
+	gzip   *gzip.Writer
+	writer *bufio.Writer
+	hash   hash.Hash
+	blocks uint32
+	nfts   uint32
+}
+
+// NewArchiveWriter записывает заголовок архива. Если compress равен true, архив сжимается gzip.
+func NewArchiveWriter(writer io.Writer, network string, from uint32, compress bool) (*ArchiveWriter, error) {
+	aw := &ArchiveWriter{
+		hash: sha256.New(),
+	}
+
+	if compress {
+		aw.gzip = gzip.NewWriter(writer)
+		writer = aw.gzip
+	}
+
+	aw.writer = bufio.NewWriter(writer)
+
+	if len(network) > 255 {
+		return nil, fmt.Errorf("%w: слишком длинное название сети", ErrArchive)
+	}
+
+	header := make([]byte, 0, len(archiveMagic)+1+1+len(network)+4)
+	header = append(header, archiveMagic...)
+	header = append(header, archiveVersion, uint8(len(network)))
+	header = append(header, network...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[len(header)-4:], from)
+
+	if err := aw.write(header); err != nil {
+		return nil, err
+	}
+
+	return aw, nil
+}
+
+// WriteBlock добавляет блок в архив.
+func (aw *ArchiveWriter) WriteBlock(block umi.Block) error {
+	aw.blocks++
+
+	return aw.writeRecord(ArchiveBlock, block)
+}
+
+// WriteNft добавляет запись хранилища NFT в архив.
+func (aw *ArchiveWriter) WriteNft(data []byte) error {
+	aw.nfts++
+
+	return aw.writeRecord(ArchiveNft, data)
+}
+
+// Close записывает завершающую запись и сбрасывает буферы. Writer, переданный в
+// NewArchiveWriter, не закрывается.
+func (aw *ArchiveWriter) Close() error {
+	data := make([]byte, 8, archiveEndLength)
+	binary.BigEndian.PutUint32(data[0:4], aw.blocks)
+	binary.BigEndian.PutUint32(data[4:8], aw.nfts)
+	data = aw.hash.Sum(data)
+
+	if err := aw.writeRecord(archiveEnd, data); err != nil {
+		return err
+	}
+
+	if err := aw.writer.Flush(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if aw.gzip != nil {
+		if err := aw.gzip.Close(); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (aw *ArchiveWriter) writeRecord(kind uint8, data []byte) error {
+	if len(data) > archiveMaxRecord {
+		return fmt.Errorf("%w: запись длиной %d байт", ErrArchive, len(data))
+	}
+
+	hdr := make([]byte, 5)
+	hdr[0] = kind
+	binary.BigEndian.PutUint32(hdr[1:5], uint32(len(data)))
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(data))
+
+	for _, part := range [][]byte{hdr, data, crc} {
+		if err := aw.write(part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (aw *ArchiveWriter) write(data []byte) error {
+	aw.hash.Write(data)
+
+	if _, err := aw.writer.Write(data); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// ArchiveReader читает архив, записанный ArchiveWriter.
+type ArchiveReader struct {
+	reader  io.Reader
+	hash    hash.Hash
+	network string
+	from    uint32
+	blocks  uint32
+	nfts    uint32
+}
+
+// NewArchiveReader читает и проверяет заголовок архива. Сжатый архив распаковывается автоматически.
+func NewArchiveReader(reader io.Reader) (*ArchiveReader, error) {
+	buffered := bufio.NewReader(reader)
+	reader = buffered
+
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrArchive, err)
+		}
+
+		reader = gz
+	}
+
+	ar := &ArchiveReader{
+		reader: reader,
+		hash:   sha256.New(),
+	}
+
+	hdr, err := ar.read(len(archiveMagic) + 2)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(hdr[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("%w: неизвестный формат", ErrArchive)
+	}
+
+	if version := hdr[len(archiveMagic)]; version != archiveVersion {
+		return nil, fmt.Errorf("%w: неподдерживаемая версия %d", ErrArchive, version)
+	}
+
+	network, err := ar.read(int(hdr[len(archiveMagic)+1]))
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := ar.read(4)
+	if err != nil {
+		return nil, err
+	}
+
+	ar.network = string(network)
+	ar.from = binary.BigEndian.Uint32(from)
+
+	return ar, nil
+}
+
+// Network возвращает название сети, для которой записан архив.
+func (ar *ArchiveReader) Network() string {
+	return ar.network
+}
+
+// From возвращает высоту первого блока в архиве.
+func (ar *ArchiveReader) From() uint32 {
+	return ar.from
+}
+
+// Next возвращает тип и данные следующей записи. После завершающей записи, если количество
+// записей и хэш архива совпали, возвращается io.EOF.
+func (ar *ArchiveReader) Next() (kind uint8, data []byte, err error) {
+	sum := ar.hash.Sum(nil)
+
+	hdr, err := ar.read(5)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	kind = hdr[0]
+	length := binary.BigEndian.Uint32(hdr[1:5])
+
+	if length > archiveMaxRecord {
+		return 0, nil, fmt.Errorf("%w: запись длиной %d байт", ErrArchive, length)
+	}
+
+	if data, err = ar.read(int(length)); err != nil {
+		return 0, nil, err
+	}
+
+	crc, err := ar.read(4)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if binary.BigEndian.Uint32(crc) != crc32.ChecksumIEEE(data) {
+		return 0, nil, fmt.Errorf("%w: контрольная сумма записи не совпадает", ErrArchive)
+	}
+
+	switch kind {
+	case ArchiveBlock:
+		ar.blocks++
+	case ArchiveNft:
+		ar.nfts++
+	case archiveEnd:
+		return 0, nil, ar.verifyEnd(data, sum)
+	default:
+		return 0, nil, fmt.Errorf("%w: неизвестный тип записи %d", ErrArchive, kind)
+	}
+
+	return kind, data, nil
+}
+
+func (ar *ArchiveReader) verifyEnd(data, sum []byte) error {
+	if len(data) != archiveEndLength {
+		return fmt.Errorf("%w: поврежден конец архива", ErrArchive)
+	}
+
+	blocks := binary.BigEndian.Uint32(data[0:4])
+	nfts := binary.BigEndian.Uint32(data[4:8])
+
+	if blocks != ar.blocks || nfts != ar.nfts {
+		return fmt.Errorf("%w: ожидаем %d блоков и %d NFT, прочитано %d и %d",
+			ErrArchive, blocks, nfts, ar.blocks, ar.nfts)
+	}
+
+	if !bytes.Equal(data[8:], sum) {
+		return fmt.Errorf("%w: хэш архива не совпадает", ErrArchive)
+	}
+
+	return io.EOF
+}
+
+func (ar *ArchiveReader) read(length int) ([]byte, error) {
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(ar.reader, data); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: архив обрезан", ErrArchive)
+		}
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	ar.hash.Write(data)
+
+	return data, nil
+}
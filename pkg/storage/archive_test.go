@@ -0,0 +1,102 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	. "gitlab.com/umitop/umid/pkg/storage"
+)
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+
+	for _, compress := range []bool{false, true} {
+		buffer := new(bytes.Buffer)
+
+		writer, err := NewArchiveWriter(buffer, "testnet", 1, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block := GenesisBlock("testnet")
+		nft := []byte("nft")
+
+		if err = writer.WriteBlock(block); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = writer.WriteNft(nft); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewArchiveReader(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reader.Network() != "testnet" || reader.From() != 1 {
+			t.Fatalf("заголовок архива: %q %d", reader.Network(), reader.From())
+		}
+
+		for _, expected := range []struct {
+			kind uint8
+			data []byte
+		}{{ArchiveBlock, block}, {ArchiveNft, nft}} {
+			kind, data, err := reader.Next()
+			if err != nil || kind != expected.kind || !bytes.Equal(data, expected.data) {
+				t.Fatalf("ожидаем запись типа %d, получили %d и '%v'", expected.kind, kind, err)
+			}
+		}
+
+		if _, _, err = reader.Next(); !errors.Is(err, io.EOF) {
+			t.Fatalf("ожидаем 'EOF', получили '%v'", err)
+		}
+	}
+}
+
+func TestArchive_Corrupted(t *testing.T) {
+	t.Parallel()
+
+	buffer := new(bytes.Buffer)
+
+	writer, err := NewArchiveWriter(buffer, "testnet", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = writer.WriteBlock(GenesisBlock("testnet"))
+	_ = writer.Close()
+
+	data := buffer.Bytes()
+	data[100] ^= 0xFF
+
+	reader, err := NewArchiveReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = reader.Next(); !errors.Is(err, ErrArchive) {
+		t.Errorf("ожидаем ошибку контрольной суммы, получили '%v'", err)
+	}
+
+	data[100] ^= 0xFF
+
+	reader, err = NewArchiveReader(bytes.NewReader(data[:len(data)-10]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = reader.Next(); !errors.Is(err, ErrArchive) {
+		t.Errorf("ожидаем ошибку обрезанного архива, получили '%v'", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+var ErrAudit = errors.New("audit")
+
+type iReplayer interface {
+	ProcessBlockLegacy([]byte) (umi.Block, error)
+	Commit() error
+}
+
+// Части мета-данных подтвержденной транзакции.
+var metaFields = []struct {
+	name      string
+	low, high int
+}{
+	{"положение в блокчейне", 150, 168},
+	{"мета-данные отправителя", 168, 187},
+	{"мета-данные получателя", 187, 206},
+	{"мета-данные комиссии", 206, umi.TxConfirmedLength},
+}
+
+// Audit проверяет блоки, записанные на диск: контрольные суммы записей индекса, длину блоков,
+// цепочку хэшей, корень Меркла, подписи блоков и транзакций, ключи генераторов. Если передан
+// replayer, блоки заново подтверждаются и мета-данные транзакций сверяются с записанными.
+// Возвращает количество проверенных блоков и ошибку с высотой первого поврежденного блока.
+func (bc *Blockchain) Audit(replayer iReplayer) (uint32, error) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	indexData := make([]byte, indexDataSize)
+	prevHash := umi.Hash{}
+	height := uint32(0)
+
+	for ; ; height++ {
+		if height > 0 && height%100_000 == 0 {
+			log.Printf("verify: проверено %d блоков", height)
+		}
+
+		if _, err := bc.indexFile.ReadAt(indexData, int64(indexDataSize*height)); err != nil {
+			if errors.Is(err, io.EOF) {
+				return height, nil
+			}
+
+			return height, fmt.Errorf("%w", err)
+		}
+
+		if isZero(indexData) {
+			return height, nil
+		}
+
+		block, err := bc.auditBlock(indexData, prevHash)
+		if err == nil && replayer != nil {
+			err = auditMeta(replayer, block)
+		}
+
+		if err != nil {
+			return height, fmt.Errorf("%w: блок %d: %v", ErrAudit, height+1, err)
+		}
+
+		prevHash = block.Hash()
+	}
+}
+
+func (bc *Blockchain) auditBlock(indexData []byte, prevHash umi.Hash) (umi.Block, error) {
+	chunkIndex := binary.BigEndian.Uint16(indexData[0:2])
+	chunkOffset := binary.BigEndian.Uint32(indexData[2:6])
+	blockSize := binary.BigEndian.Uint32(indexData[6:10])
+	blockChecksum := binary.BigEndian.Uint32(indexData[10:14])
+
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return nil, fmt.Errorf("%w: недопустимый размер блока %d", errMalformed, blockSize)
+	}
+
+	block := make(umi.Block, blockSize)
+
+	if err := bc.chunkReadAt(block, chunkIndex, chunkOffset); err != nil {
+		return nil, fmt.Errorf("не удалось прочитать блок: %w", err)
+	}
+
+	if blockChecksum != crc32.ChecksumIEEE(block) {
+		return nil, fmt.Errorf("%w: контрольная сумма не совпадает с записью в индексе", errMalformed)
+	}
+
+	if err := block.Verify(); err != nil {
+		return nil, fmt.Errorf("длина блока не соответствует количеству транзакций: %w", err)
+	}
+
+	if block.PreviousBlockHash() != prevHash {
+		return nil, fmt.Errorf("%w: блок не ссылается на предыдущий", errMalformed)
+	}
+
+	if err := VerifyBlock(bc.config, block); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, block.TransactionCount(); i < j; i++ {
+		if err := block.Transaction(i).Verify(); err != nil {
+			return nil, fmt.Errorf("транзакция %d: %w", i, err)
+		}
+	}
+
+	return block, nil
+}
+
+// auditMeta подтверждает блок заново и сверяет мета-данные транзакций с записанными.
+// Блок обрабатывается один раз: состояние, по которому посчитаны мета-данные, сразу фиксируется.
+func auditMeta(replayer iReplayer, block umi.Block) error {
+	replayed, err := replayer.ProcessBlockLegacy(block.Legacy())
+	if err != nil {
+		return fmt.Errorf("блок не прошел подтверждение: %w", err)
+	}
+
+	for i, j := 0, block.TransactionCount(); i < j; i++ {
+		stored, expected := block.Transaction(i), replayed.Transaction(i)
+
+		for _, field := range metaFields {
+			if !bytes.Equal(stored[field.low:field.high], expected[field.low:field.high]) {
+				return fmt.Errorf("транзакция %d: не совпадают %s", i, field.name)
+			}
+		}
+	}
+
+	if err := replayer.Commit(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package storage_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	. "gitlab.com/umitop/umid/pkg/storage"
+)
+
+func TestBlockchain_Audit(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.IndexSize = 14 * 16
+	conf.ChunkSize = 1 << 16
+
+	blockchain := NewBlockchain(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Close()
+
+	confirmer := ledger.NewConfirmerLegacy(ledger.NewLedger(conf))
+	confirmer.SetBlockchain(blockchain)
+
+	if err := blockchain.Scan(confirmer); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := confirmer.AppendBlock(GenesisBlock(conf.Network)); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := ledger.NewLedger(conf)
+
+	height, err := blockchain.Audit(ledger.NewConfirmerLegacy(replayed))
+	if err != nil || height != 1 {
+		t.Fatalf("ожидаем 1 блок и 'nil', получили %d и '%v'", height, err)
+	}
+
+	// Проверенный блок зафиксирован в леджере за один проход.
+	if hash := GenesisBlock(conf.Network).Transaction(0).Hash(); !replayed.HasTransaction(hash) {
+		t.Error("транзакция GENESIS-блока должна быть в леджере")
+	}
+
+	// Портим подпись блока.
+	chunk, err := os.OpenFile(path.Join(conf.DataDir, conf.Network, "blockchain0"), os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = chunk.WriteAt([]byte{0xFF}, 120); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = chunk.Close()
+
+	if height, err = blockchain.Audit(nil); !errors.Is(err, ErrAudit) || height != 0 {
+		t.Errorf("ожидаем ошибку в блоке 1, получили %d и '%v'", height, err)
+	}
+}
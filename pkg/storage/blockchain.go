@@ -28,7 +28,9 @@ import (
 	"io"
 	"log"
 	"path"
+	"sort"
 	"sync"
+	"time"
 
 	"gitlab.com/umitop/umid/pkg/config"
 	"gitlab.com/umitop/umid/pkg/umi"
@@ -38,35 +40,48 @@ const (
 	minBlockSize  = umi.HdrLength + umi.TxConfirmedLength
 	maxBlockSize  = umi.HdrLength + (umi.TxConfirmedLength * 65535)
 	indexDataSize = 14
+	tailWindow    = 1024
 )
 
 var (
-	ErrNotFound      = errors.New("not found")
-	ErrBlockSequence = errors.New("block sequence")
-	errMalformed     = errors.New("malformed")
+	ErrNotFound          = errors.New("not found")
+	ErrBlockSequence     = errors.New("block sequence")
+	ErrSnapshot          = errors.New("snapshot does not match blockchain")
+	ErrBlockVerification = errors.New("block verification")
+	errMalformed         = errors.New("malformed")
+	errTornTail          = errors.New("torn tail")
 )
 
 type IBlockchain interface {
 	OpenOrCreate() error
 	Close()
 	Subscribe(chan umi.Block)
+	SubscribeRollback(chan umi.Block)
 	Scan(confirmer iConfirmer) error
+	Load() error
 	AppendBlock(umi.Block) error
+	Truncate(uint32) error
 	Block(uint32) (umi.Block, error)
 	Transaction(uint32, uint16) (umi.Transaction, bool)
 	StreamBlocks(io.Writer, uint32, uint32)
 	Height() int
+	HeightAt(uint32) uint32
 }
 
 type iConfirmer interface {
 	ProcessBlock([]byte) error
 	Commit() error
+	LastBlock() (uint32, umi.Hash)
 }
 
 type Blockchain struct {
 	sync.Mutex
 	config *config.Config
 
+	// notifying сохраняет порядок уведомлений подписчиков, когда откат рассылается
+	// без блокировки блокчейна.
+	notifying sync.Mutex
+
 	indexFile   IFile
 	chunkFiles  map[uint16]IFile
 	chunkIndex  uint16
@@ -75,8 +90,10 @@ type Blockchain struct {
 	lastBlockHeight uint32
 	lastBlockHash   umi.Hash
 	lastBlockTime   uint32
+	times           timeIndex
 
 	subscriptions []chan umi.Block
+	rollbacks     []chan umi.Block
 }
 
 func NewBlockchain(conf *config.Config) *Blockchain {
@@ -84,6 +101,7 @@ func NewBlockchain(conf *config.Config) *Blockchain {
 		config:        conf,
 		chunkFiles:    make(map[uint16]IFile, 1),
 		subscriptions: make([]chan umi.Block, 0),
+		rollbacks:     make([]chan umi.Block, 0),
 	}
 }
 
@@ -116,58 +134,222 @@ func (bc *Blockchain) Subscribe(ch chan umi.Block) {
 	bc.subscriptions = append(bc.subscriptions, ch)
 }
 
+// SubscribeRollback подписывает канал на блоки, удаленные из блокчейна при откате.
+func (bc *Blockchain) SubscribeRollback(ch chan umi.Block) {
+	bc.rollbacks = append(bc.rollbacks, ch)
+}
+
+// Scan читает блоки с диска и передает их в леджер. Если леджер загружен из снимка,
+// блоки до высоты снимка не обрабатываются, а только сверяется хэш блока на высоте снимка.
+// Поврежденный хвост блокчейна (например, после аварийного завершения во время записи) отбрасывается.
 func (bc *Blockchain) Scan(confirmer iConfirmer) error {
 	bc.Lock()
 	defer bc.Unlock()
 
-	indexData := make([]byte, indexDataSize)
+	snapshotHeight, snapshotHash := confirmer.LastBlock()
 
 	for {
-		indexOffset := bc.lastBlockHeight * indexDataSize
+		block, chunkIndex, chunkOffset, err := bc.nextBlock()
+		if errors.Is(err, errTornTail) {
+			log.Printf("blockchain: блок %d поврежден: %v", bc.lastBlockHeight+1, err)
 
-		if _, err := bc.indexFile.ReadAt(indexData, int64(indexOffset)); err != nil {
-			return fmt.Errorf("%w", err)
+			block = nil
+		} else if err != nil {
+			return err
 		}
 
-		chunkIndex := binary.BigEndian.Uint16(indexData[0:2])
-		chunkOffset := binary.BigEndian.Uint32(indexData[2:6])
-		blockSize := binary.BigEndian.Uint32(indexData[6:10])
-		blockChecksum := binary.BigEndian.Uint32(indexData[10:14])
+		if block == nil {
+			if err := bc.discardTail(); err != nil {
+				return err
+			}
 
-		if chunkIndex == bc.chunkIndex && chunkOffset != bc.chunkOffset {
-			return nil
+			break
 		}
 
-		if blockSize < minBlockSize || blockSize > maxBlockSize {
-			return nil
-		}
+		height := bc.lastBlockHeight + 1
 
-		block := make(umi.Block, blockSize)
+		if height == snapshotHeight && block.Hash() != snapshotHash {
+			return fmt.Errorf("%w: блок %d (%s)", ErrSnapshot, height, block.Hash())
+		}
 
-		if err := bc.chunkReadAt(block, chunkIndex, chunkOffset); err != nil {
-			return err
+		if height > snapshotHeight {
+			err := VerifyBlock(bc.config, block)
+			if err == nil {
+				err = confirmer.ProcessBlock(block)
+			}
+
+			// Блок уже записан на диск, поэтому ошибка означает недоверенный ключ в конфигурации
+			// или поврежденные данные. Молча отбрасывать хвост блокчейна нельзя.
+			if err != nil {
+				return fmt.Errorf("%w: блок %d (%s): %v", ErrBlockVerification, height, block.Hash(), err)
+			}
+
+			// Ошибка возможна только при включенной проверке инвариантов.
+			if err := confirmer.Commit(); err != nil {
+				return fmt.Errorf("%w", err)
+			}
 		}
 
-		if blockChecksum != crc32.ChecksumIEEE(block) {
+		bc.chunkIndex = chunkIndex
+		bc.chunkOffset = chunkOffset + uint32(len(block))
+		bc.lastBlockHash = block.Hash()
+		bc.lastBlockTime = block.Timestamp()
+		bc.lastBlockHeight++
+		bc.times.append(block.Timestamp())
+
+		bc.notify(block)
+	}
+
+	if bc.lastBlockHeight < snapshotHeight {
+		return fmt.Errorf("%w: в блокчейне %d блоков, в снимке %d", ErrSnapshot, bc.lastBlockHeight, snapshotHeight)
+	}
+
+	return nil
+}
+
+// Load читает индекс без проверки и обработки блоков и ничего не пишет на диск: поврежденный
+// хвост не отбрасывается, а только не читается. Используется, когда блоки нужно лишь прочитать.
+func (bc *Blockchain) Load() error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	for {
+		block, chunkIndex, chunkOffset, err := bc.nextBlock()
+		if errors.Is(err, errTornTail) {
 			return nil
 		}
 
-		if err := confirmer.ProcessBlock(block); err != nil {
-			log.Printf("blockchain: блок %d (%x) не прошел проверку %s", bc.lastBlockHeight+1, block.Hash(), err.Error())
+		if err != nil {
+			return err
+		}
 
+		if block == nil {
 			return nil
 		}
 
-		_ = confirmer.Commit()
-
 		bc.chunkIndex = chunkIndex
-		bc.chunkOffset = chunkOffset + blockSize
+		bc.chunkOffset = chunkOffset + uint32(len(block))
 		bc.lastBlockHash = block.Hash()
 		bc.lastBlockTime = block.Timestamp()
 		bc.lastBlockHeight++
+		bc.times.append(block.Timestamp())
+	}
+}
 
-		bc.notify(block)
+// VerifyBlock проверяет подпись блока, корень Меркла и то, что блок подписан доверенным генератором.
+func VerifyBlock(conf *config.Config, block umi.Block) error {
+	return VerifyBlockLegacy(conf, block.Legacy())
+}
+
+// VerifyBlockLegacy выполняет те же проверки для блока в формате legacy.
+func VerifyBlockLegacy(conf *config.Config, block umi.BlockLegacy) error {
+	if err := block.VerifySignature(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := block.VerifyMerkleRoot(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if !conf.TrustedGenerator(block.PublicKey()) {
+		return fmt.Errorf("%w: untrusted generator %x", umi.ErrBlock, block.PublicKey())
+	}
+
+	return nil
+}
+
+// nextBlock читает блок, следующий за последним. Возвращает nil, если блоков больше нет,
+// и ошибку errTornTail, если запись индекса или блок повреждены.
+func (bc *Blockchain) nextBlock() (block umi.Block, chunkIndex uint16, chunkOffset uint32, err error) {
+	indexData := make([]byte, indexDataSize)
+	indexOffset := bc.lastBlockHeight * indexDataSize
+
+	if _, err := bc.indexFile.ReadAt(indexData, int64(indexOffset)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, 0, nil
+		}
+
+		return nil, 0, 0, fmt.Errorf("%w", err)
+	}
+
+	if isZero(indexData) {
+		return nil, 0, 0, nil
+	}
+
+	chunkIndex = binary.BigEndian.Uint16(indexData[0:2])
+	chunkOffset = binary.BigEndian.Uint32(indexData[2:6])
+	blockSize := binary.BigEndian.Uint32(indexData[6:10])
+	blockChecksum := binary.BigEndian.Uint32(indexData[10:14])
+
+	// Блок пишется либо сразу за предыдущим, либо в начало следующего чанка.
+	sameChunk := chunkIndex == bc.chunkIndex && chunkOffset == bc.chunkOffset
+	nextChunk := chunkIndex == bc.chunkIndex+1 && chunkOffset == 0
+
+	if !sameChunk && !nextChunk {
+		return nil, 0, 0, fmt.Errorf("%w: запись индекса указывает на %d:%d, ожидаем %d:%d",
+			errTornTail, chunkIndex, chunkOffset, bc.chunkIndex, bc.chunkOffset)
+	}
+
+	if blockSize < minBlockSize || blockSize > maxBlockSize {
+		return nil, 0, 0, fmt.Errorf("%w: недопустимый размер блока %d", errTornTail, blockSize)
+	}
+
+	block = make(umi.Block, blockSize)
+
+	if err := bc.chunkReadAt(block, chunkIndex, chunkOffset); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrSize) {
+			return nil, 0, 0, fmt.Errorf("%w: чанк %d обрезан: %v", errTornTail, chunkIndex, err)
+		}
+
+		return nil, 0, 0, err
+	}
+
+	if blockChecksum != crc32.ChecksumIEEE(block) {
+		return nil, 0, 0, fmt.Errorf("%w: контрольная сумма не совпадает", errTornTail)
+	}
+
+	return block, chunkIndex, chunkOffset, nil
+}
+
+// discardTail обнуляет записи индекса после последнего целого блока. Записи читаются окнами,
+// пока не встретится окно без единой ненулевой записи, поэтому отбрасываются и записи,
+// оказавшиеся после пустой.
+func (bc *Blockchain) discardTail() error {
+	buf := make([]byte, indexDataSize*tailWindow)
+	offset := int64(indexDataSize) * int64(bc.lastBlockHeight)
+	discarded := 0
+
+	for {
+		n, err := bc.indexFile.ReadAt(buf, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w", err)
+		}
+
+		end := 0
+
+		for i := 0; i+indexDataSize <= n; i += indexDataSize {
+			if !isZero(buf[i : i+indexDataSize]) {
+				discarded++
+				end = i + indexDataSize
+			}
+		}
+
+		if end == 0 {
+			break
+		}
+
+		if _, err = bc.indexFile.WriteAt(make([]byte, end), offset); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		offset += int64(n)
 	}
+
+	if discarded > 0 {
+		log.Printf("blockchain: отброшено %d записей индекса после блока %d", discarded, bc.lastBlockHeight)
+	}
+
+	return nil
 }
 
 func (bc *Blockchain) Block(height uint32) (umi.Block, error) {
@@ -175,26 +357,80 @@ func (bc *Blockchain) Block(height uint32) (umi.Block, error) {
 		return nil, ErrNotFound
 	}
 
-	b := make([]byte, 10)
-	off := 14 * (height - 1)
+	return bc.readBlock(height)
+}
 
-	if _, err := bc.indexFile.ReadAt(b, int64(off)); err != nil {
-		err = fmt.Errorf("%w", err)
+// Truncate удаляет из блокчейна все блоки выше указанной высоты.
+// Подписчики получают удаленные блоки в обратном порядке.
+func (bc *Blockchain) Truncate(height uint32) error {
+	bc.Lock()
 
-		return nil, err
+	removed, err := bc.truncate(height)
+	if err != nil {
+		bc.Unlock()
+
+		return err
 	}
 
-	chunk := binary.BigEndian.Uint16(b[0:2])
-	offset := binary.BigEndian.Uint32(b[2:6])
-	length := binary.BigEndian.Uint32(b[6:10])
+	// Подписчики разбирают откат без блокировки блокчейна, чтобы медленный подписчик
+	// не останавливал чтение и запись. Новые блоки будут разосланы после отката.
+	bc.notifying.Lock()
+	defer bc.notifying.Unlock()
 
-	block := make([]byte, length)
+	bc.Unlock()
 
-	if err := bc.chunkReadAt(block, chunk, offset); err != nil {
-		return nil, err
+	for _, block := range removed {
+		notifyRollback(bc.subscriptions, bc.rollbacks, block)
 	}
 
-	return block, nil
+	return nil
+}
+
+func (bc *Blockchain) truncate(height uint32) ([]umi.Block, error) {
+	if height > bc.lastBlockHeight {
+		return nil, ErrNotFound
+	}
+
+	removed := make([]umi.Block, 0, bc.lastBlockHeight-height)
+
+	for h := bc.lastBlockHeight; h > height; h-- {
+		block, err := bc.readBlock(h)
+		if err != nil {
+			return nil, err
+		}
+
+		removed = append(removed, block)
+	}
+
+	zeros := make([]byte, indexDataSize*len(removed))
+
+	if _, err := bc.indexFile.WriteAt(zeros, int64(indexDataSize*height)); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	bc.chunkIndex, bc.chunkOffset = 0, 0
+	bc.lastBlockHash, bc.lastBlockTime = umi.Hash{}, 0
+	bc.lastBlockHeight = height
+	bc.times.truncate(height)
+
+	if height > 0 {
+		chunk, offset, size, err := bc.readIndex(height)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := bc.readBlock(height)
+		if err != nil {
+			return nil, err
+		}
+
+		bc.chunkIndex = chunk
+		bc.chunkOffset = offset + size
+		bc.lastBlockHash = block.Hash()
+		bc.lastBlockTime = block.Timestamp()
+	}
+
+	return removed, nil
 }
 
 func (bc *Blockchain) Transaction(blockHeight uint32, txIndex uint16) (umi.Transaction, bool) {
@@ -258,6 +494,7 @@ func (bc *Blockchain) AppendBlock(block umi.Block) error {
 	bc.lastBlockHeight++
 	bc.lastBlockHash = block.Hash()
 	bc.lastBlockTime = block.Timestamp()
+	bc.times.append(block.Timestamp())
 
 	bc.notify(block)
 
@@ -268,6 +505,11 @@ func (bc *Blockchain) Height() int {
 	return int(bc.lastBlockHeight)
 }
 
+// HeightAt возвращает высоту последнего блока, созданного не позже указанного времени.
+func (bc *Blockchain) HeightAt(timestamp uint32) uint32 {
+	return bc.times.heightAt(timestamp)
+}
+
 func (bc *Blockchain) appendIndex(block []byte, chunkIndex uint16, chunkOffset uint32) error {
 	blockSize := uint32(len(block))
 	blockChecksum := crc32.ChecksumIEEE(block)
@@ -288,6 +530,35 @@ func (bc *Blockchain) appendIndex(block []byte, chunkIndex uint16, chunkOffset u
 	return nil
 }
 
+func (bc *Blockchain) readIndex(height uint32) (chunk uint16, offset, size uint32, err error) {
+	data := make([]byte, 10)
+
+	if _, err = bc.indexFile.ReadAt(data, int64(indexDataSize*(height-1))); err != nil {
+		return 0, 0, 0, fmt.Errorf("%w", err)
+	}
+
+	chunk = binary.BigEndian.Uint16(data[0:2])
+	offset = binary.BigEndian.Uint32(data[2:6])
+	size = binary.BigEndian.Uint32(data[6:10])
+
+	return chunk, offset, size, nil
+}
+
+func (bc *Blockchain) readBlock(height uint32) (umi.Block, error) {
+	chunk, offset, size, err := bc.readIndex(height)
+	if err != nil {
+		return nil, err
+	}
+
+	block := make([]byte, size)
+
+	if err := bc.chunkReadAt(block, chunk, offset); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
 func (bc *Blockchain) chunkWriteAt(data []byte, index uint16, offset uint32) error {
 	chunk, err := bc.chunk(index)
 	if err != nil {
@@ -333,8 +604,73 @@ func (bc *Blockchain) chunk(index uint16) (IFile, error) {
 	return file, nil
 }
 
+// timeIndex хранит время создания блоков по высотам, чтобы находить блок по времени без чтения блоков.
+type timeIndex struct {
+	sync.RWMutex
+	timestamps []uint32
+}
+
+func (index *timeIndex) append(timestamp uint32) {
+	index.Lock()
+	index.timestamps = append(index.timestamps, timestamp)
+	index.Unlock()
+}
+
+func (index *timeIndex) truncate(height uint32) {
+	index.Lock()
+	if int(height) < len(index.timestamps) {
+		index.timestamps = index.timestamps[:height]
+	}
+	index.Unlock()
+}
+
+// heightAt возвращает высоту последнего блока с меткой времени не больше timestamp или 0.
+// Метки времени блоков не убывают, поэтому используется двоичный поиск.
+func (index *timeIndex) heightAt(timestamp uint32) uint32 {
+	index.RLock()
+	defer index.RUnlock()
+
+	return uint32(sort.Search(len(index.timestamps), func(i int) bool {
+		return index.timestamps[i] > timestamp
+	}))
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (bc *Blockchain) notify(block umi.Block) {
+	bc.notifying.Lock()
+	defer bc.notifying.Unlock()
+
 	for _, ch := range bc.subscriptions {
 		ch <- block
 	}
 }
+
+// notifyRollback отправляет подписчикам удаленный блок. Прежде чем отправить блок, дожидаемся,
+// пока подписчики разберут ранее добавленные блоки, а после отправки — пока заберут откат.
+// Так откат гарантированно обрабатывается после всех предыдущих блоков и до всех последующих.
+func notifyRollback(subscriptions, rollbacks []chan umi.Block, block umi.Block) {
+	waitDrained(subscriptions)
+
+	for _, ch := range rollbacks {
+		ch <- block
+	}
+
+	waitDrained(rollbacks)
+}
+
+func waitDrained(channels []chan umi.Block) {
+	for _, ch := range channels {
+		for len(ch) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
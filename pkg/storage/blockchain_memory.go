@@ -32,12 +32,18 @@ type BlockchainMemory struct {
 	sync.Mutex
 	config *config.Config
 
+	// notifying сохраняет порядок уведомлений подписчиков, когда откат рассылается
+	// без блокировки блокчейна.
+	notifying sync.Mutex
+
 	blocks []umi.Block
 
 	lastBlockHash umi.Hash
 	lastBlockTime uint32
+	times         timeIndex
 
 	subscriptions []chan umi.Block
+	rollbacks     []chan umi.Block
 }
 
 func NewBlockchainMemory(conf *config.Config) *BlockchainMemory {
@@ -45,6 +51,7 @@ func NewBlockchainMemory(conf *config.Config) *BlockchainMemory {
 		config:        conf,
 		blocks:        make([]umi.Block, 0),
 		subscriptions: make([]chan umi.Block, 0),
+		rollbacks:     make([]chan umi.Block, 0),
 	}
 }
 
@@ -65,6 +72,7 @@ func (bc *BlockchainMemory) AppendBlock(block umi.Block) error {
 	bc.blocks = append(bc.blocks, block)
 	bc.lastBlockHash = block.Hash()
 	bc.lastBlockTime = block.Timestamp()
+	bc.times.append(block.Timestamp())
 
 	bc.notify(block)
 
@@ -82,7 +90,7 @@ func (bc *BlockchainMemory) StreamBlocks(writer io.Writer, height, limit uint32)
 }
 
 func (bc *BlockchainMemory) Block(height uint32) (umi.Block, error) {
-	if height == 0 || int(height) >= len(bc.blocks) {
+	if height == 0 || int(height) > len(bc.blocks) {
 		return nil, ErrNotFound
 	}
 
@@ -93,7 +101,7 @@ func (bc *BlockchainMemory) Block(height uint32) (umi.Block, error) {
 }
 
 func (bc *BlockchainMemory) Transaction(blockHeight uint32, txIndex uint16) (umi.Transaction, bool) {
-	if blockHeight == 0 || blockHeight >= uint32(len(bc.blocks)) {
+	if blockHeight == 0 || blockHeight > uint32(len(bc.blocks)) {
 		return nil, false
 	}
 
@@ -106,15 +114,64 @@ func (bc *BlockchainMemory) Subscribe(ch chan umi.Block) {
 	bc.subscriptions = append(bc.subscriptions, ch)
 }
 
+func (bc *BlockchainMemory) SubscribeRollback(ch chan umi.Block) {
+	bc.rollbacks = append(bc.rollbacks, ch)
+}
+
+func (bc *BlockchainMemory) Truncate(height uint32) error {
+	bc.Lock()
+
+	if int(height) > len(bc.blocks) {
+		bc.Unlock()
+
+		return ErrNotFound
+	}
+
+	removed := bc.blocks[height:]
+	bc.blocks = bc.blocks[:height:height]
+	bc.times.truncate(height)
+
+	bc.lastBlockHash, bc.lastBlockTime = umi.Hash{}, 0
+
+	if height > 0 {
+		block := bc.blocks[height-1]
+		bc.lastBlockHash = block.Hash()
+		bc.lastBlockTime = block.Timestamp()
+	}
+
+	bc.notifying.Lock()
+	defer bc.notifying.Unlock()
+
+	bc.Unlock()
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		notifyRollback(bc.subscriptions, bc.rollbacks, removed[i])
+	}
+
+	return nil
+}
+
 func (bc *BlockchainMemory) Height() int {
 	return len(bc.blocks)
 }
 
+// HeightAt возвращает высоту последнего блока, созданного не позже указанного времени.
+func (bc *BlockchainMemory) HeightAt(timestamp uint32) uint32 {
+	return bc.times.heightAt(timestamp)
+}
+
 func (*BlockchainMemory) Scan(iConfirmer) error {
 	return nil
 }
 
+func (*BlockchainMemory) Load() error {
+	return nil
+}
+
 func (bc *BlockchainMemory) notify(block umi.Block) {
+	bc.notifying.Lock()
+	defer bc.notifying.Unlock()
+
 	for _, ch := range bc.subscriptions {
 		ch <- block
 	}
@@ -30,102 +30,85 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
 	"sync"
 	"syscall"
-	"time"
 
 	"gitlab.com/umitop/umid/pkg/config"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
+const (
+	mmapEntrySize    = 16
+	mmapIndexGrowth  = mmapEntrySize << 20 // Индекс растет на 1_048_576 блоков.
+	mmapBlocksGrowth = 1 << 30             // Файл блоков растет на 1GB.
+)
+
+// BlockchainMmap хранит блоки в одном файле, а индекс — в другом. Оба файла отображаются в память,
+// поэтому чтение блоков не требует системных вызовов. Запись индекса: смещение блока (uint64),
+// размер (uint32), контрольная сумма (uint32). Блок записывается раньше записи индекса, а запись
+// индекса с неверной контрольной суммой считается концом блокчейна, поэтому прерванная запись
+// не повреждает уже записанные блоки.
 type BlockchainMmap struct {
 	sync.Mutex
 	config *config.Config
 
-	index  []byte
-	blocks []byte
+	// notifying сохраняет порядок уведомлений подписчиков, когда откат рассылается
+	// без блокировки блокчейна.
+	notifying sync.Mutex
+
+	// mapping защищает отображения, которые заменяются при росте файлов, и высоту блокчейна.
+	mapping sync.RWMutex
+	index   []byte
+	blocks  []byte
 
 	indexFile  *os.File
 	blocksFile *os.File
+	blocksSize uint64
 
 	lastBlockHeight uint32
-	lastBlockHash   [32]byte
+	lastBlockHash   umi.Hash
 	lastBlockTime   uint32
+	times           timeIndex
 
 	subscriptions []chan umi.Block
+	rollbacks     []chan umi.Block
 }
 
-func NewBlockchainMmap(config1 *config.Config) *BlockchainMmap {
+func NewBlockchainMmap(conf *config.Config) *BlockchainMmap {
 	return &BlockchainMmap{
-		config:        config1,
+		config:        conf,
 		subscriptions: make([]chan umi.Block, 0),
+		rollbacks:     make([]chan umi.Block, 0),
 	}
 }
 
-func (bc *BlockchainMmap) OpenOrCreate() error {
-	return bc.AppendBlock(GenesisBlock(bc.config.Network))
-}
-
-/*
-func (bc *BlockchainMmap) Open(dir string) (err error) {
-	subDir := fmt.Sprintf(subdirPath, dir)
-
-	if err = ensureDirectory(subDir); err != nil {
-		return err
-	}
-
-	idxPath := fmt.Sprintf(indexPathMb, subDir)
-	blkPath := fmt.Sprintf(blockchainFile, subDir)
-
-	if err = ensurerFile(idxPath, indexSizeByte); err != nil {
-		return err
-	}
+func (bc *BlockchainMmap) OpenOrCreate() (err error) {
+	cfg := bc.config
+	dir := path.Join(cfg.DataDir, cfg.Network)
 
-	if err = ensurerFile(blkPath, blockchainByte); err != nil {
+	if err = CheckOrCreateDir(NewFSx(), dir); err != nil {
 		return err
 	}
 
-	// mmap для идекса
-	f1, err := os.Open(idxPath)
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	if bc.index, err = syscall.Mmap(int(f1.Fd()), 0, indexSizeByte, syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
+	if bc.indexFile, err = os.OpenFile(path.Join(dir, "mmap-index"), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
-	if err = f1.Close(); err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	// mmap для блоков
-	f2, err := os.Open(blkPath)
-	if err != nil {
+	if bc.blocksFile, err = os.OpenFile(path.Join(dir, "mmap-blocks"), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
-	if bc.blocks, err = syscall.Mmap(int(f2.Fd()), 0, blockchainByte, syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	if err = f2.Close(); err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	// Открываем индекс для записи
-	if bc.indexFile, err = os.OpenFile(idxPath, os.O_RDWR, 0644); err != nil {
-		return fmt.Errorf("%w", err)
+	if bc.index, err = remap(bc.indexFile, nil, 0, mmapIndexGrowth); err != nil {
+		return err
 	}
 
-	// Открываем файл блокчейна для записи
-	if bc.dataFile, err = os.OpenFile(blkPath, os.O_RDWR, 0644); err != nil {
-		return fmt.Errorf("%w", err)
+	if bc.blocks, err = remap(bc.blocksFile, nil, 0, mmapBlocksGrowth); err != nil {
+		return err
 	}
 
-	return fmt.Errorf("%w", err)
+	return nil
 }
-*/
 
 func (bc *BlockchainMmap) Close() {
 	if err := syscall.Munmap(bc.blocks); err != nil {
@@ -149,187 +132,350 @@ func (bc *BlockchainMmap) Subscribe(ch chan umi.Block) {
 	bc.subscriptions = append(bc.subscriptions, ch)
 }
 
-func (bc *BlockchainMmap) notify(block []byte) {
-	for _, ch := range bc.subscriptions {
-		ch <- block
-	}
+// SubscribeRollback подписывает канал на блоки, удаленные из блокчейна при откате.
+func (bc *BlockchainMmap) SubscribeRollback(ch chan umi.Block) {
+	bc.rollbacks = append(bc.rollbacks, ch)
 }
 
-/*
-func (bc *BlockchainMmap) QuickScan(updater *ledger.Confirmer) {
-	log.Println("blockchain: quick scan start")
+// Scan читает блоки с диска и передает их в леджер. Если леджер загружен из снимка,
+// блоки до высоты снимка не обрабатываются, а только сверяется хэш блока на высоте снимка.
+func (bc *BlockchainMmap) Scan(confirmer iConfirmer) error {
+	bc.Lock()
+	defer bc.Unlock()
 
-	t := time.Now()
+	snapshotHeight, snapshotHash := confirmer.LastBlock()
 
 	for {
-		idx := bc.lastBlockHeight * 8
+		block, offset := bc.nextBlock()
+		if block == nil {
+			break
+		}
 
-		low := binary.BigEndian.Uint32(bc.index[idx : idx+4])        // начало блока
-		checksum := binary.BigEndian.Uint32(bc.index[idx+4 : idx+8]) // котрольная сумма
-		high := binary.BigEndian.Uint32(bc.index[idx+8 : idx+12])    // конец блока
+		height := bc.lastBlockHeight + 1
 
-		if high <= low || high-low > 9830417 {
-			break
+		if height == snapshotHeight && block.Hash() != snapshotHash {
+			return fmt.Errorf("%w: блок %d (%s)", ErrSnapshot, height, block.Hash())
 		}
 
-		if checksum != crc32.ChecksumIEEE(bc.blocks[low:high]) {
-			break
+		if height > snapshotHeight {
+			err := VerifyBlock(bc.config, block)
+			if err == nil {
+				err = confirmer.ProcessBlock(block)
+			}
+
+			// Блок уже записан на диск, поэтому ошибка означает недоверенный ключ в конфигурации
+			// или поврежденные данные. Молча отбрасывать хвост блокчейна нельзя.
+			if err != nil {
+				return fmt.Errorf("%w: блок %d (%s): %v", ErrBlockVerification, height, block.Hash(), err)
+			}
+
+			// Ошибка возможна только при включенной проверке инвариантов.
+			if err := confirmer.Commit(); err != nil {
+				return fmt.Errorf("%w", err)
+			}
 		}
 
-		block := umi.Block(bc.blocks[low:high])
+		bc.mapping.Lock()
+		bc.blocksSize = offset + uint64(len(block))
+		bc.lastBlockHash = block.Hash()
+		bc.lastBlockTime = block.Timestamp()
+		bc.lastBlockHeight++
+		bc.mapping.Unlock()
 
-		if bc.lastBlockHash != block.PreviousBlockHash() {
-			break
-		}
+		bc.times.append(block.Timestamp())
 
-		if bc.lastBlockTime > block.Timestamp() {
-			break
-		}
+		bc.notify(block)
+	}
 
-		if err := updater.ProcessBlock(block); err != nil {
-			log.Printf("blockchain: блок %d (%x) не прошел проверку", bc.lastBlockHeight+1, block.Hash())
+	if bc.lastBlockHeight < snapshotHeight {
+		return fmt.Errorf("%w: в блокчейне %d блоков, в снимке %d", ErrSnapshot, bc.lastBlockHeight, snapshotHeight)
+	}
 
-			break
-		}
+	return nil
+}
 
-		if err := updater.Commit(); err != nil {
-			log.Printf("blockchain: блок %d (%x) не закоммитился", bc.lastBlockHeight+1, block.Hash())
+// Load читает индекс без проверки и обработки блоков. Используется, когда блоки нужно лишь прочитать.
+func (bc *BlockchainMmap) Load() error {
+	bc.Lock()
+	defer bc.Unlock()
 
-			break
+	for {
+		block, offset := bc.nextBlock()
+		if block == nil {
+			return nil
 		}
 
-		bc.lastBlockHeight++
+		bc.mapping.Lock()
+		bc.blocksSize = offset + uint64(len(block))
 		bc.lastBlockHash = block.Hash()
 		bc.lastBlockTime = block.Timestamp()
+		bc.lastBlockHeight++
+		bc.mapping.Unlock()
+
+		bc.times.append(block.Timestamp())
+	}
+}
+
+// nextBlock читает блок, следующий за последним. Возвращает nil, если блока нет или он поврежден.
+func (bc *BlockchainMmap) nextBlock() (umi.Block, uint64) {
+	bc.mapping.RLock()
+	defer bc.mapping.RUnlock()
+
+	if uint64(bc.lastBlockHeight+1)*mmapEntrySize > uint64(len(bc.index)) {
+		return nil, 0
+	}
+
+	offset, size, checksum := bc.entry(bc.lastBlockHeight + 1)
+
+	if offset != bc.blocksSize || size < minBlockSize || size > maxBlockSize {
+		return nil, 0
+	}
+
+	if offset+uint64(size) > uint64(len(bc.blocks)) {
+		return nil, 0
+	}
+
+	block := make(umi.Block, size)
+	copy(block, bc.blocks[offset:offset+uint64(size)])
+
+	if checksum != crc32.ChecksumIEEE(block) {
+		return nil, 0
 	}
 
-	log.Println("blockchain: quick scan done", time.Since(t), bc.lastBlockHeight)
+	return block, offset
 }
-*/
 
 func (bc *BlockchainMmap) Block(height uint32) (umi.Block, error) {
-	if height > bc.lastBlockHeight || height == 0 {
-		return nil, fmt.Errorf("%w: блок еще не добавлен в блокчейн", ErrNotFound)
+	bc.mapping.RLock()
+	defer bc.mapping.RUnlock()
+
+	if height == 0 || height > bc.lastBlockHeight {
+		return nil, ErrNotFound
 	}
 
-	idx := (height - 1) * 8
+	offset, size, _ := bc.entry(height)
+
+	block := make(umi.Block, size)
+	copy(block, bc.blocks[offset:offset+uint64(size)])
 
-	low := binary.BigEndian.Uint32(bc.index[idx : idx+4])
-	high := binary.BigEndian.Uint32(bc.index[idx+8 : idx+12])
+	return block, nil
+}
+
+func (bc *BlockchainMmap) Transaction(blockHeight uint32, txIndex uint16) (umi.Transaction, bool) {
+	bc.mapping.RLock()
+	defer bc.mapping.RUnlock()
 
-	if low >= high {
-		return nil, fmt.Errorf("%w: индекс содержит некорректные данные", errMalformed)
+	if blockHeight == 0 || blockHeight > bc.lastBlockHeight {
+		return nil, false
 	}
 
-	return bc.blocks[low:high], nil
+	offset, size, _ := bc.entry(blockHeight)
+	low := uint64(umi.HdrLength + umi.TxConfirmedLength*int(txIndex))
+	high := low + umi.TxConfirmedLength
+
+	if high > uint64(size) {
+		return nil, false
+	}
+
+	transaction := make(umi.Transaction, umi.TxConfirmedLength)
+	copy(transaction, bc.blocks[offset+low:offset+high])
+
+	return transaction, true
 }
 
 func (bc *BlockchainMmap) StreamBlocks(writer io.Writer, height, limit uint32) {
-	_ = bc
-	_ = writer
-	_ = height
-	_ = limit
-}
+	_, _ = height, limit
 
-func (bc *BlockchainMmap) Transaction(blockHeight uint32, txIndex uint16) (umi.Transaction, bool) {
-	_ = bc
-	_, _ = blockHeight, txIndex
+	bc.mapping.RLock()
+	defer bc.mapping.RUnlock()
 
-	return nil, false
+	_, _ = writer.Write(bc.blocks[:bc.blocksSize])
 }
 
-func (bc *BlockchainMmap) AppendBlock(blk umi.Block) error {
-	if bc.lastBlockHash != blk.PreviousBlockHash() {
-		return fmt.Errorf("%w: новый блок не ссылается на последний", ErrBlockSequence)
+func (bc *BlockchainMmap) AppendBlock(block umi.Block) error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if bc.lastBlockHash != block.PreviousBlockHash() {
+		return ErrBlockSequence
 	}
 
 	height := bc.lastBlockHeight + 1
+	offset := bc.blocksSize
 
-	if err := bc.insertBlock(height, blk); err != nil {
+	if err := bc.insertBlock(height, offset, block); err != nil {
 		return err
 	}
 
+	bc.mapping.Lock()
+	bc.blocksSize = offset + uint64(len(block))
 	bc.lastBlockHeight = height
-	bc.lastBlockHash = blk.Hash()
+	bc.lastBlockHash = block.Hash()
+	bc.lastBlockTime = block.Timestamp()
+	bc.mapping.Unlock()
 
-	bc.notify(blk)
+	bc.times.append(block.Timestamp())
 
-	if height%100_000 == 0 {
-		log.Printf("blockchain: блок %d добавлен", height)
-	}
+	bc.notify(block)
 
 	return nil
 }
 
-func (bc *BlockchainMmap) Height() int {
-	return int(bc.lastBlockHeight)
+// Truncate удаляет из блокчейна все блоки выше указанной высоты.
+// Подписчики получают удаленные блоки в обратном порядке.
+func (bc *BlockchainMmap) Truncate(height uint32) error {
+	bc.Lock()
+
+	removed, err := bc.truncate(height)
+	if err != nil {
+		bc.Unlock()
+
+		return err
+	}
+
+	bc.notifying.Lock()
+	defer bc.notifying.Unlock()
+
+	bc.Unlock()
+
+	for _, block := range removed {
+		notifyRollback(bc.subscriptions, bc.rollbacks, block)
+	}
+
+	return nil
 }
 
-func (bc *BlockchainMmap) insertBlock(height uint32, block []byte) error {
-	idxOffset := (height - 1) * 8
-	blkOffset := binary.BigEndian.Uint32(bc.index[idxOffset : idxOffset+4])
+func (bc *BlockchainMmap) truncate(height uint32) ([]umi.Block, error) {
+	if height > bc.lastBlockHeight {
+		return nil, ErrNotFound
+	}
 
-	idxData := make([]byte, 8)
-	binary.BigEndian.PutUint32(idxData[0:4], crc32.ChecksumIEEE(block))    // контроьная сумма
-	binary.BigEndian.PutUint32(idxData[4:8], blkOffset+uint32(len(block))) // индекс следующего блока
+	removed := make([]umi.Block, 0, bc.lastBlockHeight-height)
 
-	if _, err := bc.indexFile.WriteAt(idxData, int64(idxOffset+4)); err != nil {
-		return fmt.Errorf("%w", err)
+	for h := bc.lastBlockHeight; h > height; h-- {
+		block, err := bc.Block(h)
+		if err != nil {
+			return nil, err
+		}
+
+		removed = append(removed, block)
 	}
 
-	if _, err := bc.blocksFile.WriteAt(block, int64(blkOffset)); err != nil {
-		return fmt.Errorf("%w", err)
+	zeros := make([]byte, mmapEntrySize*len(removed))
+
+	if _, err := bc.indexFile.WriteAt(zeros, int64(mmapEntrySize)*int64(height)); err != nil {
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	return nil
+	bc.times.truncate(height)
+
+	bc.mapping.Lock()
+	bc.blocksSize = 0
+	bc.lastBlockHash, bc.lastBlockTime = umi.Hash{}, 0
+	bc.lastBlockHeight = height
+
+	if height > 0 {
+		offset, size, _ := bc.entry(height)
+		block := umi.Block(bc.blocks[offset : offset+uint64(size)])
+
+		bc.blocksSize = offset + uint64(size)
+		bc.lastBlockHash = block.Hash()
+		bc.lastBlockTime = block.Timestamp()
+	}
+
+	bc.mapping.Unlock()
+
+	return removed, nil
 }
 
-func (bc *BlockchainMmap) FastScan() {
-	log.Println("blockchain: fast scan start")
+func (bc *BlockchainMmap) Height() int {
+	bc.mapping.RLock()
+	defer bc.mapping.RUnlock()
 
-	currentTime := time.Now()
+	return int(bc.lastBlockHeight)
+}
 
-	for {
-		off := bc.lastBlockHeight * 8
+// HeightAt возвращает высоту последнего блока, созданного не позже указанного времени.
+func (bc *BlockchainMmap) HeightAt(timestamp uint32) uint32 {
+	return bc.times.heightAt(timestamp)
+}
 
-		data := make([]byte, 12) // 4 bytes * 3
+func (bc *BlockchainMmap) entry(height uint32) (offset uint64, size, checksum uint32) {
+	data := bc.index[(height-1)*mmapEntrySize : height*mmapEntrySize]
 
-		if _, err := bc.indexFile.ReadAt(data, int64(off)); err != nil {
-			log.Println(err)
+	return binary.BigEndian.Uint64(data[0:8]), binary.BigEndian.Uint32(data[8:12]), binary.BigEndian.Uint32(data[12:16])
+}
 
-			return
-		}
+// insertBlock записывает блок, а затем запись индекса. При необходимости файлы увеличиваются
+// и отображаются в память заново.
+func (bc *BlockchainMmap) insertBlock(height uint32, offset uint64, block umi.Block) (err error) {
+	bc.mapping.Lock()
 
-		low := binary.BigEndian.Uint32(data[0:4])      // начало блока
-		crc32sum := binary.BigEndian.Uint32(data[4:8]) // контрольная сумма
-		high := binary.BigEndian.Uint32(data[8:12])    // конец блока
+	if bc.index, err = remap(bc.indexFile, bc.index, uint64(height)*mmapEntrySize, mmapIndexGrowth); err == nil {
+		bc.blocks, err = remap(bc.blocksFile, bc.blocks, offset+uint64(len(block)), mmapBlocksGrowth)
+	}
 
-		if high <= low || high-low > 9830417 {
-			break
-		}
+	bc.mapping.Unlock()
 
-		blk := make([]byte, high-low)
+	if err != nil {
+		return err
+	}
 
-		if _, err := bc.blocksFile.ReadAt(blk, int64(low)); err != nil {
-			log.Println(err)
+	if _, err = bc.blocksFile.WriteAt(block, int64(offset)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
 
-			return
-		}
+	data := make([]byte, mmapEntrySize)
+	binary.BigEndian.PutUint64(data[0:8], offset)
+	binary.BigEndian.PutUint32(data[8:12], uint32(len(block)))
+	binary.BigEndian.PutUint32(data[12:16], crc32.ChecksumIEEE(block))
 
-		if crc32sum != crc32.ChecksumIEEE(blk) {
-			break
-		}
+	if _, err = bc.indexFile.WriteAt(data, int64(height-1)*mmapEntrySize); err != nil {
+		return fmt.Errorf("%w", err)
+	}
 
-		block := (umi.Block)(blk)
+	return nil
+}
 
-		if bc.lastBlockHash != block.PreviousBlockHash() {
-			break
+func (bc *BlockchainMmap) notify(block umi.Block) {
+	bc.notifying.Lock()
+	defer bc.notifying.Unlock()
+
+	for _, ch := range bc.subscriptions {
+		ch <- block
+	}
+}
+
+// remap увеличивает файл так, чтобы он вмещал size байт (с шагом growth), и отображает его в память.
+// Если текущее отображение достаточно велико, оно возвращается без изменений.
+func remap(file *os.File, mapped []byte, size, growth uint64) ([]byte, error) {
+	if mapped != nil && size <= uint64(len(mapped)) {
+		return mapped, nil
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return mapped, fmt.Errorf("%w", err)
+	}
+
+	fileSize := uint64(fileInfo.Size())
+
+	if fileSize < size || fileSize == 0 {
+		fileSize = (size/growth + 1) * growth
+
+		if err = file.Truncate(int64(fileSize)); err != nil {
+			return mapped, fmt.Errorf("%w", err)
 		}
+	}
 
-		bc.lastBlockHeight++
-		bc.lastBlockHash = block.Hash()
-		bc.lastBlockTime = block.Timestamp()
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(fileSize), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return mapped, fmt.Errorf("%w", err)
+	}
+
+	if mapped != nil {
+		_ = syscall.Munmap(mapped)
 	}
 
-	log.Println("blockchain: fast scan done", time.Since(currentTime), bc.lastBlockHeight)
+	return data, nil
 }
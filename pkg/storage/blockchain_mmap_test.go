@@ -0,0 +1,113 @@
+//go:build !windows
+// +build !windows
+
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	. "gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestBlockchainMmap_Truncate(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	blocks := newTestChain(5)
+	blockchain := NewBlockchainMmap(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Close()
+
+	rollbacks := make(chan umi.Block)
+	done := make(chan struct{})
+
+	blockchain.SubscribeRollback(rollbacks)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			<-rollbacks
+		}
+
+		close(done)
+	}()
+
+	for _, block := range blocks {
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if block, err := blockchain.Block(4); err != nil || !bytes.Equal(block, blocks[3]) {
+		t.Errorf("ожидаем блок 4, получили '%v'", err)
+	}
+
+	if tx, ok := blockchain.Transaction(5, 0); !ok || !bytes.Equal(tx, blocks[4].Transaction(0)) {
+		t.Error("ожидаем транзакцию 5:0")
+	}
+
+	if _, ok := blockchain.Transaction(5, 1); ok {
+		t.Error("транзакции 5:1 нет, must return false")
+	}
+
+	if err := blockchain.Truncate(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	<-done
+
+	if blockchain.Height() != 2 {
+		t.Errorf("height must be 2, got %d", blockchain.Height())
+	}
+
+	if err := blockchain.AppendBlock(blocks[2]); err != nil {
+		t.Errorf("ожидаем 'nil', получили '%v'", err)
+	}
+}
+
+func TestBlockchainMmap_Scan(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	blockchain := NewBlockchainMmap(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blockchain.AppendBlock(GenesisBlock(conf.Network)); err != nil {
+		t.Fatal(err)
+	}
+
+	blockchain.Close()
+
+	// После перезапуска блоки должны быть прочитаны с диска.
+	blockchain = NewBlockchainMmap(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Close()
+
+	if err := blockchain.Scan(ledger.NewConfirmerLegacy(ledger.NewLedger(conf))); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if blockchain.Height() != 1 {
+		t.Errorf("height must be 1, got %d", blockchain.Height())
+	}
+
+	if block, err := blockchain.Block(1); err != nil || block.Hash() != GenesisBlock(conf.Network).Hash() {
+		t.Errorf("ожидаем genesis-блок, получили '%v'", err)
+	}
+}
@@ -1,7 +1,16 @@
 package storage_test
 
 import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
 	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/ledger"
+	. "gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
 )
 
 // Должны успешно создаться все необходимые файлы
@@ -48,3 +57,284 @@ func TestA001(t *testing.T) {
 	//	t.Errorf("ожидаем '%x', получили '%x'", sha256.Sum256(GenesisBlock(Mainnet)), sha256.Sum256(block))
 	//}
 }
+
+func newTestChain(length int) []umi.Block {
+	blocks := make([]umi.Block, 0, length)
+	prevHash := umi.Hash{}
+
+	for i := 0; i < length; i++ {
+		transaction := make(umi.Transaction, umi.TxConfirmedLength)
+		transaction.SetVersion(umi.TxV1Send)
+		transaction.SetBlockHeight(uint32(i + 1))
+		transaction.SetNonce(uint32(i))
+
+		block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+		block.SetPreviousBlockHash(prevHash)
+		block.SetTimestamp(uint32(i))
+		block = append(block, transaction...)
+
+		blocks = append(blocks, block)
+		prevHash = block.Hash()
+	}
+
+	return blocks
+}
+
+func TestBlockchainMemory_Truncate(t *testing.T) {
+	t.Parallel()
+
+	blocks := newTestChain(5)
+	blockchain := NewBlockchainMemory(config.DefaultConfig())
+	rollbacks := make(chan umi.Block)
+	removed := make(chan []umi.Block)
+
+	blockchain.SubscribeRollback(rollbacks)
+
+	go func() {
+		received := make([]umi.Block, 0)
+
+		for i := 0; i < 3; i++ {
+			received = append(received, <-rollbacks)
+		}
+
+		removed <- received
+	}()
+
+	for _, block := range blocks {
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := blockchain.Truncate(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if blockchain.Height() != 2 {
+		t.Errorf("height must be 2, got %d", blockchain.Height())
+	}
+
+	for i, block := range <-removed {
+		if height := 5 - i; block.Hash() != blocks[height-1].Hash() {
+			t.Errorf("ожидаем блок %d", height)
+		}
+	}
+
+	if _, err := blockchain.Block(3); err == nil {
+		t.Error("must return error")
+	}
+
+	if err := blockchain.AppendBlock(blocks[3]); err == nil {
+		t.Error("блок 4 не ссылается на блок 2, must return error")
+	}
+
+	if err := blockchain.AppendBlock(blocks[2]); err != nil {
+		t.Errorf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if err := blockchain.Truncate(10); err == nil {
+		t.Error("must return error")
+	}
+}
+
+func TestBlockchain_Truncate(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.IndexSize = 14 * 16
+	conf.ChunkSize = 1 << 16
+
+	blocks := newTestChain(5)
+	blockchain := NewBlockchain(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	rollbacks := make(chan umi.Block)
+	removed := make(chan []umi.Block)
+
+	blockchain.SubscribeRollback(rollbacks)
+
+	go func() {
+		received := make([]umi.Block, 0)
+
+		for i := 0; i < 3; i++ {
+			received = append(received, <-rollbacks)
+		}
+
+		removed <- received
+	}()
+
+	for _, block := range blocks {
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := blockchain.Truncate(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	for i, block := range <-removed {
+		if height := 5 - i; block.Hash() != blocks[height-1].Hash() {
+			t.Errorf("ожидаем блок %d", height)
+		}
+	}
+
+	if err := blockchain.Truncate(10); err == nil {
+		t.Error("must return error")
+	}
+
+	if err := blockchain.AppendBlock(blocks[3]); err == nil {
+		t.Error("блок 4 не ссылается на блок 2, must return error")
+	}
+
+	if err := blockchain.AppendBlock(blocks[2]); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	blockchain.Close()
+
+	// Записи индекса удаленных блоков должны быть обнулены на диске.
+	data, err := os.ReadFile(path.Join(conf.DataDir, conf.Network, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for height := 1; height <= 5; height++ {
+		entry := data[14*(height-1) : 14*height]
+
+		if zero := binary.BigEndian.Uint32(entry[10:14]) == 0; zero != (height > 3) {
+			t.Errorf("запись индекса блока %d: ожидаем обнуление %v", height, height > 3)
+		}
+	}
+}
+
+func TestVerifyBlock(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	block := GenesisBlock(conf.Network)
+
+	if err := VerifyBlock(conf, block); err != nil {
+		t.Errorf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	conf.GeneratorKeys = []string{"4652d6097f24434facbc182894fafa4fc5ade78db3e01705a6324d35d8d34a69"}
+
+	if err := VerifyBlock(conf, block); err == nil {
+		t.Error("блок подписан недоверенным ключом, must return error")
+	}
+
+	conf.GeneratorKeys = nil
+	forged := append(umi.Block{}, block...)
+	forged.SetMerkleRootHash(umi.Hash{})
+
+	if err := VerifyBlock(conf, forged); err == nil {
+		t.Error("корень Меркла изменен, must return error")
+	}
+}
+
+func TestBlockchain_ScanUntrustedBlock(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.IndexSize = 14 * 16
+	conf.ChunkSize = 1 << 16
+
+	blockchain := NewBlockchain(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blockchain.AppendBlock(GenesisBlock(conf.Network)); err != nil {
+		t.Fatal(err)
+	}
+
+	blockchain.Close()
+
+	// Блок на диске подписан ключом, которого больше нет в списке доверенных.
+	conf.GeneratorKeys = []string{"4652d6097f24434facbc182894fafa4fc5ade78db3e01705a6324d35d8d34a69"}
+	blockchain = NewBlockchain(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Close()
+
+	err := blockchain.Scan(ledger.NewConfirmerLegacy(ledger.NewLedger(conf)))
+	if !errors.Is(err, ErrBlockVerification) {
+		t.Errorf("ожидаем '%v', получили '%v'", ErrBlockVerification, err)
+	}
+}
+
+func TestBlockchain_ScanTornTail(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.IndexSize = 14 * 16
+	conf.ChunkSize = 1 << 16
+
+	blockchain := NewBlockchain(conf)
+
+	if err := blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := GenesisBlock(conf.Network)
+
+	if err := blockchain.AppendBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	blockchain.Close()
+
+	// Запись индекса блока 2 ссылается на незаписанный блок, блок 3 не записан,
+	// а запись блока 4 — мусор.
+	indexName := path.Join(conf.DataDir, conf.Network, "index")
+
+	index, err := os.OpenFile(indexName, os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := make([]byte, 14)
+	binary.BigEndian.PutUint32(entry[2:6], uint32(len(genesis)))
+	binary.BigEndian.PutUint32(entry[6:10], uint32(len(genesis)))
+	binary.BigEndian.PutUint32(entry[10:14], 0xDEADBEEF)
+
+	_, _ = index.WriteAt(entry, 14)
+	_, _ = index.WriteAt([]byte{0xFF, 0xFF, 0xFF}, 14*3)
+	_ = index.Close()
+
+	blockchain = NewBlockchain(conf)
+
+	if err = blockchain.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Close()
+
+	if err = blockchain.Scan(ledger.NewConfirmerLegacy(ledger.NewLedger(conf))); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if blockchain.Height() != 1 {
+		t.Errorf("height must be 1, got %d", blockchain.Height())
+	}
+
+	data, err := os.ReadFile(indexName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, b := range data[14:] {
+		if b != 0 {
+			t.Fatalf("запись индекса %d должна быть обнулена", i/14+2)
+		}
+	}
+}
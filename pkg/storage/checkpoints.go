@@ -0,0 +1,108 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+var ErrCheckpoints = errors.New("checkpoints")
+
+// checkpoints — встроенные контрольные точки: высота блока и его хэш. Точки после GENESIS-блока
+// берутся с синхронизированного доверенного узла командой umid verify -print-checkpoints N
+// и добавляются сюда перед каждым релизом. Пока здесь только GENESIS-блок, свежие точки
+// указываются в файле config.CheckpointsFile (UMI_CHECKPOINTS).
+var checkpoints = map[string]map[uint32]string{
+	"mainnet": {
+		1: "3dd589382bdb31ce26fcb5bd24b9ea1cf211df3915e6856b92be8968632274b6",
+	},
+	"testnet": {
+		1: "d7e93ab295edbde972c6c852ddc446572cc9a9424722a427f119ecd1c38874e9",
+	},
+}
+
+// LoadCheckpoints возвращает встроенные контрольные точки сети, дополненные точками из файла
+// config.CheckpointsFile. Файл содержит JSON-массив вида [{"height": 1, "hash": "..."}].
+func LoadCheckpoints(conf *config.Config) (map[uint32]umi.Hash, error) {
+	result := make(map[uint32]umi.Hash)
+
+	for height, hexHash := range checkpoints[conf.Network] {
+		if err := addCheckpoint(result, height, hexHash); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.CheckpointsFile == "" {
+		return result, nil
+	}
+
+	data, err := os.ReadFile(conf.CheckpointsFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	items := make([]struct {
+		Height uint32 `json:"height"`
+		Hash   string `json:"hash"`
+	}, 0)
+
+	if err = json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCheckpoints, conf.CheckpointsFile, err)
+	}
+
+	for _, item := range items {
+		if err = addCheckpoint(result, item.Height, item.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func addCheckpoint(checkpoints map[uint32]umi.Hash, height uint32, hexHash string) error {
+	var hash umi.Hash
+
+	if height == 0 {
+		return fmt.Errorf("%w: недопустимая высота 0", ErrCheckpoints)
+	}
+
+	b, err := hex.DecodeString(hexHash)
+	if err != nil || len(b) != len(hash) {
+		return fmt.Errorf("%w: блок %d: некорректный хэш '%s'", ErrCheckpoints, height, hexHash)
+	}
+
+	copy(hash[:], b)
+
+	if old, ok := checkpoints[height]; ok && old != hash {
+		return fmt.Errorf("%w: блок %d: противоречивые контрольные точки", ErrCheckpoints, height)
+	}
+
+	checkpoints[height] = hash
+
+	return nil
+}
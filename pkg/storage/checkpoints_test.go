@@ -0,0 +1,45 @@
+package storage_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	. "gitlab.com/umitop/umid/pkg/storage"
+)
+
+func TestLoadCheckpoints(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+
+	checkpoints, err := LoadCheckpoints(conf)
+	if err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if checkpoints[1] != GenesisBlock(conf.Network).Hash() {
+		t.Error("первая контрольная точка должна совпадать с genesis-блоком")
+	}
+
+	conf.CheckpointsFile = path.Join(t.TempDir(), "checkpoints.json")
+
+	data := `[{"height": 10, "hash": "0000000000000000000000000000000000000000000000000000000000000001"}]`
+	if err = os.WriteFile(conf.CheckpointsFile, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoints, err = LoadCheckpoints(conf); err != nil || len(checkpoints) != 2 {
+		t.Errorf("ожидаем 2 контрольные точки, получили %d и '%v'", len(checkpoints), err)
+	}
+
+	data = `[{"height": 1, "hash": "0000000000000000000000000000000000000000000000000000000000000001"}]`
+	if err = os.WriteFile(conf.CheckpointsFile, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadCheckpoints(conf); err == nil {
+		t.Error("контрольная точка противоречит встроенной, ожидаем ошибку")
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	hashTableHeaderSize = 16
+	hashTableCapacity   = 1 << 16
+	hashTableBatch      = 64
+
+	slotEmpty   = 0
+	slotUsed    = 1
+	slotDeleted = 2
+)
+
+var ErrHashTable = errors.New("hash table")
+
+// hashTable — хэш-таблица с открытой адресацией и ключами и значениями фиксированной длины,
+// хранящаяся в файле. Заголовок файла: емкость (uint64) и количество занятых слотов (uint64).
+// Слот: признак занятости (1 байт), ключ, значение. Доступ к таблице должен синхронизироваться снаружи.
+type hashTable struct {
+	name      string
+	file      *os.File
+	keySize   int
+	valueSize int
+	capacity  uint64
+	used      uint64
+}
+
+func newHashTable(name string, keySize, valueSize int) *hashTable {
+	return &hashTable{
+		name:      name,
+		keySize:   keySize,
+		valueSize: valueSize,
+	}
+}
+
+func (table *hashTable) OpenOrCreate() (err error) {
+	table.file, err = os.OpenFile(table.name, os.O_RDWR, 0o644)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return table.create(hashTableCapacity)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	header := make([]byte, hashTableHeaderSize)
+
+	if _, err = table.file.ReadAt(header, 0); err != nil {
+		_ = table.file.Close()
+
+		return fmt.Errorf("%w: %s: %v", ErrHashTable, table.name, err)
+	}
+
+	table.capacity = binary.BigEndian.Uint64(header[0:8])
+	table.used = binary.BigEndian.Uint64(header[8:16])
+
+	if table.capacity == 0 || table.capacity&(table.capacity-1) != 0 {
+		_ = table.file.Close()
+
+		return fmt.Errorf("%w: %s: wrong capacity", ErrHashTable, table.name)
+	}
+
+	return nil
+}
+
+func (table *hashTable) Close() {
+	if table.file != nil {
+		_ = table.file.Close()
+		table.file = nil
+	}
+}
+
+// Reset удаляет все записи.
+func (table *hashTable) Reset() error {
+	table.Close()
+
+	return table.create(hashTableCapacity)
+}
+
+// Get возвращает значение по ключу.
+func (table *hashTable) Get(key []byte) (value []byte, ok bool, err error) {
+	slot, state, err := table.find(key)
+	if err != nil || state != slotUsed {
+		return nil, false, err
+	}
+
+	value = make([]byte, table.valueSize)
+
+	if _, err = table.file.ReadAt(value, table.offset(slot)+1+int64(table.keySize)); err != nil {
+		return nil, false, fmt.Errorf("%w", err)
+	}
+
+	return value, true, nil
+}
+
+// Put добавляет или заменяет значение по ключу.
+func (table *hashTable) Put(key, value []byte) error {
+	slot, state, err := table.find(key)
+	if err != nil {
+		return err
+	}
+
+	if state == slotUsed {
+		if _, err = table.file.WriteAt(value, table.offset(slot)+1+int64(table.keySize)); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		return nil
+	}
+
+	if (table.used+1)*4 > table.capacity*3 {
+		if err = table.grow(); err != nil {
+			return err
+		}
+	}
+
+	if err = table.insert(key, value); err != nil {
+		return err
+	}
+
+	return table.writeHeader()
+}
+
+// Delete помечает слот ключа как удаленный.
+func (table *hashTable) Delete(key []byte) error {
+	slot, state, err := table.find(key)
+	if err != nil || state != slotUsed {
+		return err
+	}
+
+	if _, err = table.file.WriteAt([]byte{slotDeleted}, table.offset(slot)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func (table *hashTable) create(capacity uint64) (err error) {
+	if table.file, err = os.Create(table.name); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	table.capacity = capacity
+	table.used = 0
+
+	if err = table.file.Truncate(table.offset(capacity)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return table.writeHeader()
+}
+
+func (table *hashTable) writeHeader() error {
+	header := make([]byte, hashTableHeaderSize)
+	binary.BigEndian.PutUint64(header[0:8], table.capacity)
+	binary.BigEndian.PutUint64(header[8:16], table.used)
+
+	if _, err := table.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func (table *hashTable) slotSize() int {
+	return 1 + table.keySize + table.valueSize
+}
+
+func (table *hashTable) offset(slot uint64) int64 {
+	return hashTableHeaderSize + int64(slot)*int64(table.slotSize())
+}
+
+func (table *hashTable) hash(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+
+	return h.Sum64()
+}
+
+// find ищет слот с ключом. Если ключ не найден, возвращает первый слот, пригодный для вставки,
+// и его состояние.
+func (table *hashTable) find(key []byte) (slot uint64, state byte, err error) {
+	slotSize := table.slotSize()
+	buf := make([]byte, hashTableBatch*slotSize)
+	mask := table.capacity - 1
+	start := table.hash(key) & mask
+	free, hasFree := uint64(0), false
+
+	for probed := uint64(0); probed < table.capacity; {
+		// Читаем слоты пачками, не выходя за конец таблицы.
+		first := (start + probed) & mask
+		n := table.capacity - first
+
+		if n > hashTableBatch {
+			n = hashTableBatch
+		}
+
+		batch := buf[:int(n)*slotSize]
+
+		if _, err = table.file.ReadAt(batch, table.offset(first)); err != nil {
+			return 0, 0, fmt.Errorf("%w", err)
+		}
+
+		for i := uint64(0); i < n; i++ {
+			s := batch[int(i)*slotSize : int(i+1)*slotSize]
+
+			switch s[0] {
+			case slotEmpty:
+				if hasFree {
+					return free, slotDeleted, nil
+				}
+
+				return first + i, slotEmpty, nil
+
+			case slotDeleted:
+				if !hasFree {
+					free, hasFree = first+i, true
+				}
+
+			default:
+				if string(s[1:1+table.keySize]) == string(key) {
+					return first + i, slotUsed, nil
+				}
+			}
+		}
+
+		probed += n
+	}
+
+	if hasFree {
+		return free, slotDeleted, nil
+	}
+
+	return 0, 0, fmt.Errorf("%w: %s: table is full", ErrHashTable, table.name)
+}
+
+// insert записывает новый ключ, не проверяя заполненность таблицы и не обновляя заголовок.
+func (table *hashTable) insert(key, value []byte) error {
+	slot, state, err := table.find(key)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 0, table.slotSize())
+	data = append(data, slotUsed)
+	data = append(data, key...)
+	data = append(data, value...)
+
+	if _, err = table.file.WriteAt(data, table.offset(slot)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	// Повторно используемый удаленный слот уже учтен.
+	if state == slotEmpty {
+		table.used++
+	}
+
+	return nil
+}
+
+// grow переносит записи в таблицу вдвое большего размера. Новая таблица пишется во временный файл,
+// который затем заменяет старый, поэтому прерванное расширение не повреждает данные.
+func (table *hashTable) grow() error {
+	bigger := newHashTable(table.name+".tmp", table.keySize, table.valueSize)
+
+	if err := bigger.create(table.capacity * 2); err != nil {
+		return err
+	}
+	defer bigger.Close()
+
+	slotSize := table.slotSize()
+	buf := make([]byte, 4096*slotSize)
+
+	for first := uint64(0); first < table.capacity; first += 4096 {
+		n, err := table.file.ReadAt(buf, table.offset(first))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w", err)
+		}
+
+		for i := 0; i+slotSize <= n; i += slotSize {
+			if buf[i] != slotUsed {
+				continue
+			}
+
+			key := buf[i+1 : i+1+table.keySize]
+			value := buf[i+1+table.keySize : i+slotSize]
+
+			if err := bigger.insert(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := bigger.writeHeader(); err != nil {
+		return err
+	}
+
+	if err := bigger.file.Sync(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := os.Rename(bigger.name, table.name); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	table.Close()
+	table.file, bigger.file = bigger.file, nil
+	table.capacity = bigger.capacity
+	table.used = bigger.used
+
+	return nil
+}
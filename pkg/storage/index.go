@@ -22,34 +22,275 @@ package storage
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
 	"sync"
 
+	"gitlab.com/umitop/umid/pkg/config"
 	"gitlab.com/umitop/umid/pkg/umi"
 )
 
+const (
+	postingsHeaderSize = 64
+	postingsPerPage    = 32
+	postingsPageSize   = 8 + postingsPerPage*8
+	addressValueSize   = 12
+	txValueSize        = 8
+	indexVersion       = 1
+)
+
+type iIndexBlockchain interface {
+	Height() int
+	Block(uint32) (umi.Block, error)
+}
+
+// Index хранит для каждого адреса список его транзакций (высота блока << 16 | номер транзакции).
+// Списки хранятся в файле postings страницами по 32 записи, страницы одного адреса связаны
+// ссылкой на предыдущую. Хэш-таблица addresses хранит для адреса смещение последней страницы
+// и количество записей. В заголовке файла postings хранятся высота и хэш последнего
+// проиндексированного блока, что позволяет продолжить индексацию после перезапуска.
+// Хэш-таблица transactions хранит положение транзакции в блокчейне по ее хэшу.
+// Хэш-таблица nfts устроена так же, как addresses, и хранит списки транзакций выпуска
+// и передачи каждого NFT.
 type Index struct {
 	sync.RWMutex
+	config    *config.Config
 	blocks    chan umi.Block
-	addresses map[umi.Address]*[]uint64
+	rollbacks chan umi.Block
+
+	addresses    *hashTable
+	transactions *hashTable
+	nfts         *hashTable
+	postings     *os.File
+	postingsSize int64
+
+	height uint32
+	hash   umi.Hash
+	dirty  bool
 }
 
-func NewIndex() *Index {
+func NewIndex(conf *config.Config) *Index {
 	return &Index{
+		config:    conf,
 		blocks:    make(chan umi.Block, 64),
-		addresses: make(map[umi.Address]*[]uint64),
+		rollbacks: make(chan umi.Block),
+	}
+}
+
+func (index *Index) OpenOrCreate() (err error) {
+	cfg := index.config
+	dir := path.Join(cfg.DataDir, cfg.Network)
+
+	if err = CheckOrCreateDir(NewFSx(), dir); err != nil {
+		return err
+	}
+
+	index.addresses = newHashTable(path.Join(dir, "addresses"), umi.AddrLength, addressValueSize)
+
+	if err = index.addresses.OpenOrCreate(); err != nil {
+		return err
+	}
+
+	index.transactions = newHashTable(path.Join(dir, "transactions"), len(umi.Hash{}), txValueSize)
+
+	if err = index.transactions.OpenOrCreate(); err != nil {
+		return err
+	}
+
+	index.nfts = newHashTable(path.Join(dir, "nfts"), len(umi.Hash{}), addressValueSize)
+
+	if err = index.nfts.OpenOrCreate(); err != nil {
+		return err
+	}
+
+	if index.postings, err = os.OpenFile(path.Join(dir, "postings"), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	fileInfo, err := index.postings.Stat()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if fileInfo.Size() < postingsHeaderSize {
+		return index.reset()
+	}
+
+	header := make([]byte, postingsHeaderSize)
+
+	if _, err = index.postings.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	index.height = binary.BigEndian.Uint32(header[0:4])
+	copy(index.hash[:], header[4:36])
+
+	// Индекс, созданный без таблицы транзакций или предыдущей версией, строится заново.
+	if index.height > 0 && (index.transactions.used == 0 || binary.BigEndian.Uint32(header[36:40]) != indexVersion) {
+		return index.reset()
+	}
+
+	// Последняя страница может быть записана не полностью, поэтому размер округляется до целой страницы.
+	pages := (fileInfo.Size() - postingsHeaderSize + postingsPageSize - 1) / postingsPageSize
+	index.postingsSize = postingsHeaderSize + pages*postingsPageSize
+
+	// Если процесс был прерван во время индексации блока, в списках могли остаться его транзакции.
+	index.dirty = true
+
+	return nil
+}
+
+func (index *Index) Close() {
+	index.Lock()
+	defer index.Unlock()
+
+	if index.addresses != nil {
+		index.addresses.Close()
+	}
+
+	if index.transactions != nil {
+		index.transactions.Close()
+	}
+
+	if index.nfts != nil {
+		index.nfts.Close()
+	}
+
+	if index.postings != nil {
+		_ = index.postings.Close()
+	}
+}
+
+// Height возвращает высоту последнего проиндексированного блока.
+func (index *Index) Height() uint32 {
+	index.RLock()
+	defer index.RUnlock()
+
+	return index.height
+}
+
+func (index *Index) TransactionsByAddress(address umi.Address) (*[]uint64, bool) {
+	index.RLock()
+	defer index.RUnlock()
+
+	txs, err := index.readList(index.addresses, address[:])
+	if err != nil {
+		log.Printf("index: %v", err)
+
+		return nil, false
+	}
+
+	if len(txs) == 0 {
+		return nil, false
 	}
+
+	return &txs, true
 }
 
-func (index *Index) TransactionsByAddress(address umi.Address) (txs *[]uint64, ok bool) {
+// TransactionsByNft возвращает транзакции выпуска и передачи NFT в порядке подтверждения.
+func (index *Index) TransactionsByNft(hash umi.Hash) (*[]uint64, bool) {
 	index.RLock()
-	txs, ok = index.addresses[address]
-	index.RUnlock()
+	defer index.RUnlock()
+
+	txs, err := index.readList(index.nfts, hash[:])
+	if err != nil {
+		log.Printf("index: %v", err)
+
+		return nil, false
+	}
+
+	if len(txs) == 0 {
+		return nil, false
+	}
+
+	return &txs, true
+}
+
+// Transaction возвращает высоту блока и номер в блоке подтвержденной транзакции.
+func (index *Index) Transaction(hash umi.Hash) (blockHeight uint32, txIndex uint16, ok bool) {
+	index.RLock()
+	defer index.RUnlock()
+
+	value, ok, err := index.transactions.Get(hash[:])
+	if err != nil {
+		log.Printf("index: %v", err)
+
+		return 0, 0, false
+	}
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	tx := binary.BigEndian.Uint64(value)
+
+	return uint32(tx >> 16), uint16(tx & 0xFFFF), true
+}
+
+// Sync индексирует блоки, которые были добавлены в блокчейн, пока индекс не работал.
+// Если индекс опережает блокчейн или построен для другой цепочки, он строится заново.
+func (index *Index) Sync(blockchain iIndexBlockchain) error {
+	height := uint32(blockchain.Height())
+
+	if err := index.validate(blockchain, height); err != nil {
+		return err
+	}
+
+	for h := index.Height() + 1; h <= height; h++ {
+		block, err := blockchain.Block(h)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		index.Lock()
+		err = index.appendBlock(block)
+		index.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	return txs, ok
+func (index *Index) validate(blockchain iIndexBlockchain, height uint32) error {
+	index.Lock()
+	defer index.Unlock()
+
+	if index.height > 0 {
+		valid := index.height <= height
+
+		if valid {
+			block, err := blockchain.Block(index.height)
+			if err != nil {
+				return fmt.Errorf("%w", err)
+			}
+
+			valid = block.Hash() == index.hash
+		}
+
+		if !valid {
+			log.Printf("index: индекс не соответствует блокчейну, строим заново")
+
+			return index.reset()
+		}
+	}
+
+	return nil
 }
 
 func (index *Index) SubscribeTo(subscriber iSubscriber) {
 	subscriber.Subscribe(index.blocks)
+
+	if rollbackSubscriber, ok := subscriber.(iRollbackSubscriber); ok {
+		rollbackSubscriber.SubscribeRollback(index.rollbacks)
+	}
 }
 
 func (index *Index) Worker(ctx context.Context) {
@@ -58,6 +299,9 @@ func (index *Index) Worker(ctx context.Context) {
 		case block := <-index.blocks:
 			index.processBlock(block)
 
+		case block := <-index.rollbacks:
+			index.processRollback(block)
+
 		case <-ctx.Done():
 			return
 		}
@@ -68,41 +312,313 @@ func (index *Index) processBlock(block umi.Block) {
 	index.Lock()
 	defer index.Unlock()
 
+	if err := index.appendBlock(block); err != nil {
+		log.Printf("index: %v", err)
+	}
+}
+
+// processRollback удаляет из индекса транзакции блока, удаленного при откате.
+// Транзакции удаленного блока всегда находятся в конце списков.
+func (index *Index) processRollback(block umi.Block) {
+	index.Lock()
+	defer index.Unlock()
+
+	height := block.Transaction(0).BlockHeight()
+	if height != index.height {
+		return
+	}
+
+	if err := index.removeBlock(block, height); err != nil {
+		log.Printf("index: %v", err)
+
+		return
+	}
+
+	if err := index.writeState(height-1, block.PreviousBlockHash()); err != nil {
+		log.Printf("index: %v", err)
+	}
+}
+
+func (index *Index) appendBlock(block umi.Block) error {
+	height := block.Transaction(0).BlockHeight()
+
+	if height <= index.height {
+		return nil
+	}
+
+	if height != index.height+1 {
+		return fmt.Errorf("%w: expected %d, got %d", ErrBlockSequence, index.height+1, height)
+	}
+
+	if index.dirty {
+		if err := index.removeBlock(block, height); err != nil {
+			return err
+		}
+
+		index.dirty = false
+	}
+
 	for i, j := 0, block.TransactionCount(); i < j; i++ {
 		transaction := block.Transaction(i)
-		sender := transaction.Sender()
 		tx := uint64(transaction.BlockHeight())<<16 | uint64(transaction.BlockTransactionIndex())
 
-		senderTxs, ok := index.addresses[sender]
-		if !ok {
-			senderTxs = new([]uint64)
-			index.addresses[sender] = senderTxs
+		if err := index.putTransaction(transaction.Hash(), tx); err != nil {
+			return err
 		}
 
-		*senderTxs = append(*senderTxs, tx)
+		sender := transaction.Sender()
+
+		if err := index.push(index.addresses, sender[:], tx); err != nil {
+			return err
+		}
 
 		if transaction.HasRecipient() {
 			recipient := transaction.Recipient()
 
-			recipientTxs, ok := index.addresses[recipient]
-			if !ok {
-				recipientTxs = new([]uint64)
-				index.addresses[recipient] = recipientTxs
+			if err := index.push(index.addresses, recipient[:], tx); err != nil {
+				return err
 			}
+		}
+
+		if transaction.HasFee() {
+			feeAddress := transaction.FeeAddress()
+
+			if err := index.push(index.addresses, feeAddress[:], tx); err != nil {
+				return err
+			}
+		}
+
+		if nftHash, ok := nftOf(transaction); ok {
+			if err := index.push(index.nfts, nftHash[:], tx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return index.writeState(height, block.Hash())
+}
+
+// removeBlock удаляет транзакции блока из таблицы транзакций, а из списков адресов блока —
+// транзакции с высотой height и выше.
+func (index *Index) removeBlock(block umi.Block, height uint32) error {
+	for i := block.TransactionCount() - 1; i >= 0; i-- {
+		transaction := block.Transaction(i)
+		hash := transaction.Hash()
+
+		if err := index.transactions.Delete(hash[:]); err != nil {
+			return err
+		}
 
-			*recipientTxs = append(*recipientTxs, tx)
+		if nftHash, ok := nftOf(transaction); ok {
+			if err := index.truncate(index.nfts, nftHash[:], height); err != nil {
+				return err
+			}
 		}
 
 		if transaction.HasFee() {
-			fee := transaction.FeeAddress()
+			feeAddress := transaction.FeeAddress()
+
+			if err := index.truncate(index.addresses, feeAddress[:], height); err != nil {
+				return err
+			}
+		}
+
+		if transaction.HasRecipient() {
+			recipient := transaction.Recipient()
+
+			if err := index.truncate(index.addresses, recipient[:], height); err != nil {
+				return err
+			}
+		}
+
+		sender := transaction.Sender()
+
+		if err := index.truncate(index.addresses, sender[:], height); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nftOf возвращает хэш NFT, если транзакция его выпускает или передает.
+func nftOf(transaction umi.Transaction) (umi.Hash, bool) {
+	switch transaction.Version() {
+	case umi.TxV18MintNftWitness:
+		return transaction.Hash(), true
+
+	case umi.TxV19TransferNft:
+		return transaction.NftHash(), true
+	}
+
+	return umi.Hash{}, false
+}
+
+func (index *Index) push(table *hashTable, key []byte, tx uint64) error {
+	tail, count, err := index.list(table, key)
+	if err != nil {
+		return err
+	}
+
+	if pos := count % postingsPerPage; pos == 0 {
+		// Текущая страница заполнена, начинаем новую.
+		page := make([]byte, 16)
+		binary.BigEndian.PutUint64(page[0:8], tail)
+		binary.BigEndian.PutUint64(page[8:16], tx)
+
+		tail = uint64(index.postingsSize)
+		index.postingsSize += postingsPageSize
+
+		if _, err = index.postings.WriteAt(page, int64(tail)); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	} else {
+		entry := make([]byte, 8)
+		binary.BigEndian.PutUint64(entry, tx)
+
+		if _, err = index.postings.WriteAt(entry, int64(tail)+8+int64(pos)*8); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return index.setList(table, key, tail, count+1)
+}
+
+// truncate удаляет с конца списка транзакции с высотой height и выше.
+func (index *Index) truncate(table *hashTable, key []byte, height uint32) error {
+	tail, count, err := index.list(table, key)
+	if err != nil || count == 0 {
+		return err
+	}
+
+	buf := make([]byte, 8)
+	length := count
+
+	for length > 0 {
+		pos := (length - 1) % postingsPerPage
 
-			feeTxs, ok := index.addresses[fee]
-			if !ok {
-				feeTxs = new([]uint64)
-				index.addresses[fee] = feeTxs
+		if _, err = index.postings.ReadAt(buf, int64(tail)+8+int64(pos)*8); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		if binary.BigEndian.Uint64(buf)>>16 < uint64(height) {
+			break
+		}
+
+		length--
+
+		if pos == 0 {
+			if _, err = index.postings.ReadAt(buf, int64(tail)); err != nil {
+				return fmt.Errorf("%w", err)
 			}
 
-			*feeTxs = append(*feeTxs, tx)
+			tail = binary.BigEndian.Uint64(buf)
 		}
 	}
+
+	if length == count {
+		return nil
+	}
+
+	if length == 0 {
+		return table.Delete(key)
+	}
+
+	return index.setList(table, key, tail, length)
+}
+
+// readList читает список транзакций, начиная с последней страницы.
+func (index *Index) readList(table *hashTable, key []byte) ([]uint64, error) {
+	tail, count, err := index.list(table, key)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	txs := make([]uint64, count)
+	page := make([]byte, postingsPageSize)
+	n := int(count-1)%postingsPerPage + 1
+
+	for end := int(count); end > 0; n = postingsPerPage {
+		if _, err = index.postings.ReadAt(page[:8+n*8], int64(tail)); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		for i := 0; i < n; i++ {
+			txs[end-n+i] = binary.BigEndian.Uint64(page[8+i*8:])
+		}
+
+		end -= n
+		tail = binary.BigEndian.Uint64(page[0:8])
+	}
+
+	return txs, nil
+}
+
+func (index *Index) list(table *hashTable, key []byte) (tail uint64, count uint32, err error) {
+	value, ok, err := table.Get(key)
+	if err != nil || !ok {
+		return 0, 0, err
+	}
+
+	return binary.BigEndian.Uint64(value[0:8]), binary.BigEndian.Uint32(value[8:12]), nil
+}
+
+func (index *Index) setList(table *hashTable, key []byte, tail uint64, count uint32) error {
+	value := make([]byte, addressValueSize)
+	binary.BigEndian.PutUint64(value[0:8], tail)
+	binary.BigEndian.PutUint32(value[8:12], count)
+
+	return table.Put(key, value)
+}
+
+func (index *Index) putTransaction(hash umi.Hash, tx uint64) error {
+	value := make([]byte, txValueSize)
+	binary.BigEndian.PutUint64(value, tx)
+
+	return index.transactions.Put(hash[:], value)
+}
+
+func (index *Index) writeState(height uint32, hash umi.Hash) error {
+	header := make([]byte, 40)
+	binary.BigEndian.PutUint32(header[0:4], height)
+	copy(header[4:36], hash[:])
+	binary.BigEndian.PutUint32(header[36:40], indexVersion)
+
+	if _, err := index.postings.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	index.height = height
+	index.hash = hash
+
+	return nil
+}
+
+func (index *Index) reset() error {
+	if err := index.addresses.Reset(); err != nil {
+		return err
+	}
+
+	if err := index.transactions.Reset(); err != nil {
+		return err
+	}
+
+	if err := index.nfts.Reset(); err != nil {
+		return err
+	}
+
+	if err := index.postings.Truncate(0); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if _, err := index.postings.WriteAt(make([]byte, postingsHeaderSize), 0); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	index.postingsSize = postingsHeaderSize
+	index.height = 0
+	index.hash = umi.Hash{}
+	index.dirty = false
+
+	return nil
 }
@@ -0,0 +1,183 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/umitop/umid/pkg/config"
+	. "gitlab.com/umitop/umid/pkg/storage"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+func TestIndex_Sync(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	blockchain := NewBlockchainMemory(conf)
+	blocks := newTestChain(40)
+
+	for _, block := range blocks {
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := NewIndex(conf)
+
+	if err := index.OpenOrCreate(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if err := index.Sync(blockchain); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	index.Close()
+
+	// После перезапуска индекс должен продолжить с сохраненной высоты.
+	index = NewIndex(conf)
+
+	if err := index.OpenOrCreate(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+	defer index.Close()
+
+	if index.Height() != 40 {
+		t.Fatalf("height must be 40, got %d", index.Height())
+	}
+
+	if err := index.Sync(blockchain); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	// Отправитель и получатель тестовых транзакций совпадают, поэтому каждая транзакция учтена дважды.
+	checkIndex(t, index, 80)
+
+	if height, idx, ok := index.Transaction(blocks[34].Transaction(0).Hash()); !ok || height != 35 || idx != 0 {
+		t.Errorf("ожидаем транзакцию 35:0, получили %d:%d", height, idx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	index.SubscribeTo(blockchain)
+
+	go index.Worker(ctx)
+
+	if err := blockchain.Truncate(30); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	for deadline := time.Now().Add(time.Second); index.Height() != 30; {
+		if time.Now().After(deadline) {
+			t.Fatalf("height must be 30, got %d", index.Height())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	checkIndex(t, index, 60)
+
+	if _, _, ok := index.Transaction(blocks[34].Transaction(0).Hash()); ok {
+		t.Error("транзакция удаленного блока не должна находиться")
+	}
+}
+
+func TestIndex_TransactionsByNft(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	nftHash := umi.Hash{1, 2, 3}
+
+	mint := make(umi.Transaction, umi.TxConfirmedLength)
+	mint.SetVersion(umi.TxV18MintNftWitness)
+	mint.SetHash(nftHash)
+
+	transfer := make(umi.Transaction, umi.TxConfirmedLength)
+	transfer.SetVersion(umi.TxV19TransferNft)
+	transfer.SetNftHash(nftHash)
+
+	blockchain := NewBlockchainMemory(conf)
+	prevHash := umi.Hash{}
+
+	for i, transaction := range []umi.Transaction{mint, transfer, transfer} {
+		transaction.SetBlockHeight(uint32(i + 1))
+		transaction.SetNonce(uint32(i))
+
+		block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+		block.SetPreviousBlockHash(prevHash)
+		block = append(block, transaction...)
+
+		if err := blockchain.AppendBlock(block); err != nil {
+			t.Fatal(err)
+		}
+
+		prevHash = block.Hash()
+	}
+
+	index := NewIndex(conf)
+
+	if err := index.OpenOrCreate(); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+	defer index.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	index.SubscribeTo(blockchain)
+
+	go index.Worker(ctx)
+
+	if err := index.Sync(blockchain); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	if txs, ok := index.TransactionsByNft(nftHash); !ok || len(*txs) != 3 || (*txs)[0] != 1<<16 {
+		t.Fatalf("ожидаем 3 транзакции NFT, получили %v", txs)
+	}
+
+	if err := blockchain.Truncate(2); err != nil {
+		t.Fatalf("ожидаем 'nil', получили '%v'", err)
+	}
+
+	for deadline := time.Now().Add(time.Second); index.Height() != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("height must be 2, got %d", index.Height())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if txs, ok := index.TransactionsByNft(nftHash); !ok || len(*txs) != 2 || (*txs)[1] != 2<<16 {
+		t.Fatalf("ожидаем 2 транзакции NFT, получили %v", txs)
+	}
+
+	if _, ok := index.TransactionsByNft(umi.Hash{}); ok {
+		t.Error("NFT без транзакций не должен находиться")
+	}
+}
+
+func checkIndex(t *testing.T, index *Index, length int) {
+	t.Helper()
+
+	txs, ok := index.TransactionsByAddress(umi.Address{})
+	if !ok {
+		t.Fatal("адрес должен быть в индексе")
+	}
+
+	if len(*txs) != length {
+		t.Fatalf("ожидаем %d транзакций, получили %d", length, len(*txs))
+	}
+
+	for i, tx := range *txs {
+		if height := uint64(i/2 + 1); tx>>16 != height {
+			t.Fatalf("транзакция %d: ожидаем высоту %d, получили %d", i, height, tx>>16)
+		}
+	}
+}
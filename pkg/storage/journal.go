@@ -0,0 +1,226 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"gitlab.com/umitop/umid/pkg/config"
+)
+
+const journalHdrLen = 8
+
+var ErrJournal = errors.New("journal")
+
+// Journal хранит принятые в мемпул транзакции, чтобы они переживали перезапуск ноды.
+// Записи дописываются в конец файла: длина (4 байта), crc32 (4 байта), данные.
+type Journal struct {
+	sync.Mutex
+	config  *config.Config
+	name    string
+	file    *os.File
+	records int
+}
+
+func NewJournal(conf *config.Config, name string) *Journal {
+	return &Journal{
+		config: conf,
+		name:   name,
+	}
+}
+
+func (journal *Journal) OpenOrCreate() error {
+	cfg := journal.config
+	dir := path.Join(cfg.DataDir, cfg.Network)
+
+	if err := CheckOrCreateDir(NewFSx(), dir); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(journal.path(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	journal.Lock()
+	defer journal.Unlock()
+
+	journal.file = file
+
+	return nil
+}
+
+func (journal *Journal) Close() {
+	journal.Lock()
+	defer journal.Unlock()
+
+	if journal.file != nil {
+		_ = journal.file.Close()
+		journal.file = nil
+	}
+}
+
+// Load читает записи журнала. Поврежденный хвост, оставшийся после аварийной остановки,
+// отрезается.
+func (journal *Journal) Load() (records [][]byte, err error) {
+	journal.Lock()
+	defer journal.Unlock()
+
+	if _, err = journal.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	data, err := io.ReadAll(journal.file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	records = make([][]byte, 0)
+	offset := 0
+
+	for offset+journalHdrLen <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		checksum := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+
+		if offset+journalHdrLen+length > len(data) {
+			break
+		}
+
+		record := data[offset+journalHdrLen : offset+journalHdrLen+length]
+
+		if crc32.ChecksumIEEE(record) != checksum {
+			break
+		}
+
+		records = append(records, record)
+		offset += journalHdrLen + length
+	}
+
+	if offset < len(data) {
+		if err = journal.file.Truncate(int64(offset)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrJournal, err)
+		}
+	}
+
+	journal.records = len(records)
+
+	return records, nil
+}
+
+// Append дописывает запись в конец журнала.
+func (journal *Journal) Append(record []byte) error {
+	journal.Lock()
+	defer journal.Unlock()
+
+	if _, err := journal.file.Write(encodeJournalRecord(record)); err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	journal.records++
+
+	return nil
+}
+
+// Records возвращает количество записей в журнале, включая устаревшие.
+func (journal *Journal) Records() int {
+	journal.Lock()
+	defer journal.Unlock()
+
+	return journal.records
+}
+
+// Compact перезаписывает журнал, оставляя только переданные записи. Новый журнал
+// пишется во временный файл и заменяет старый после синхронизации с диском.
+func (journal *Journal) Compact(records [][]byte) error {
+	journal.Lock()
+	defer journal.Unlock()
+
+	data := make([]byte, 0)
+
+	for _, record := range records {
+		data = append(data, encodeJournalRecord(record)...)
+	}
+
+	name := journal.path()
+	tmpName := name + ".tmp"
+
+	if err := writeFileSync(tmpName, data); err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	_ = journal.file.Close()
+
+	if err := os.Rename(tmpName, name); err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJournal, err)
+	}
+
+	journal.file = file
+	journal.records = len(records)
+
+	return nil
+}
+
+func (journal *Journal) path() string {
+	return path.Join(journal.config.DataDir, journal.config.Network, journal.name)
+}
+
+func encodeJournalRecord(record []byte) []byte {
+	data := make([]byte, journalHdrLen+len(record))
+
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(record))
+	copy(data[journalHdrLen:], record)
+
+	return data
+}
+
+func writeFileSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
@@ -21,9 +21,11 @@
 package storage
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -35,24 +37,55 @@ type iSubscriber interface {
 	Subscribe(chan umi.Block)
 }
 
+type iRollbackSubscriber interface {
+	SubscribeRollback(chan umi.Block)
+}
+
 type iLedger interface {
 	Account(address umi.Address) (account *ledger.Account, ok bool)
 	Structure(prefix umi.Prefix) (structure *ledger.Structure, ok bool)
 	HasTransaction(hash umi.Hash) bool
+	NftByHeight(height uint64) (hash umi.Hash, ok bool)
+	NftOwner(hash umi.Hash) (owner umi.Address, ok bool)
 }
 
 type state struct {
-	balance      int64
+	sent         int
+	spent        uint64
+	received     uint64
 	transactions map[umi.Hash]*umi.Transaction
 }
 
+// entry хранит служебные данные неподтвержденной транзакции. Транзакция, отправитель которой
+// расходует неподтвержденные входящие средства, зависит от транзакций, которые эти средства зачисляют.
+type entry struct {
+	charge
+	hash      umi.Hash
+	timestamp uint32
+	seq       uint64
+	index     int
+	parents   map[umi.Hash]struct{}
+	children  map[umi.Hash]struct{}
+}
+
 type Mempool struct {
 	sync.RWMutex
 	ledger        iLedger
 	blocks        chan umi.Block
+	rollbacks     chan umi.Block
 	addresses     map[umi.Address]*state
 	transactions  map[umi.Hash]*umi.Transaction
+	entries       map[umi.Hash]*entry
+	queue         evictionQueue
+	seq           uint64
+	bytes         int
+	maxCount      int
+	maxBytes      int
+	senderQuota   int
+	evicted       uint64
+	refused       uint64
 	subscriptions []chan *umi.Transaction
+	MempoolJournal
 }
 
 var ErrMempool = errors.New("mempool")
@@ -60,8 +93,10 @@ var ErrMempool = errors.New("mempool")
 func NewMempool() *Mempool {
 	return &Mempool{
 		blocks:        make(chan umi.Block, 64),
+		rollbacks:     make(chan umi.Block),
 		addresses:     make(map[umi.Address]*state),
 		transactions:  make(map[umi.Hash]*umi.Transaction),
+		entries:       make(map[umi.Hash]*entry),
 		subscriptions: make([]chan *umi.Transaction, 0, 2),
 	}
 }
@@ -76,6 +111,10 @@ func (mempool *Mempool) Subscribe(ch chan *umi.Transaction) {
 
 func (mempool *Mempool) SubscribeTo(subscriber iSubscriber) {
 	subscriber.Subscribe(mempool.blocks)
+
+	if rollbackSubscriber, ok := subscriber.(iRollbackSubscriber); ok {
+		rollbackSubscriber.SubscribeRollback(mempool.rollbacks)
+	}
 }
 
 func (mempool *Mempool) Worker(ctx context.Context) {
@@ -87,6 +126,9 @@ func (mempool *Mempool) Worker(ctx context.Context) {
 		case block := <-mempool.blocks:
 			mempool.ParseBlock(block)
 
+		case block := <-mempool.rollbacks:
+			mempool.RestoreBlock(block)
+
 		case <-ticker.C:
 			mempool.cleanup()
 
@@ -97,11 +139,42 @@ func (mempool *Mempool) Worker(ctx context.Context) {
 }
 
 func (mempool *Mempool) Push(transaction umi.Transaction) error {
-	hash := transaction.Hash()
+	mempool.Lock()
+	defer mempool.Unlock()
+
+	if err := mempool.push(transaction); err != nil {
+		return err
+	}
+
+	mempool.AppendJournal(transaction)
 
+	return nil
+}
+
+// Restore загружает транзакции из журнала после перезапуска ноды. Транзакции проходят
+// обычную проверку, просроченные и невалидные отбрасываются, после чего журнал сжимается.
+func (mempool *Mempool) Restore() error {
 	mempool.Lock()
 	defer mempool.Unlock()
 
+	timestamp := func(record []byte) (uint32, bool) {
+		if len(record) != umi.TxLength {
+			return 0, false
+		}
+
+		return umi.Transaction(record).Timestamp(), true
+	}
+
+	push := func(record []byte) error {
+		return mempool.push(record)
+	}
+
+	return mempool.RestoreJournal(timestamp, push, mempool.journalRecords)
+}
+
+func (mempool *Mempool) push(transaction umi.Transaction) error {
+	hash := transaction.Hash()
+
 	if _, ok := mempool.transactions[hash]; ok {
 		return fmt.Errorf("%w: tranasction in mempool", ErrMempool)
 	}
@@ -115,13 +188,82 @@ func (mempool *Mempool) Push(transaction umi.Transaction) error {
 		return fmt.Errorf("%w: sender account not found", ErrMempool)
 	}
 
-	if transaction.Version() != umi.TxV16Issue {
-		if senderAccount.BalanceAt(uint32(time.Now().Unix())) < transaction.Amount() {
-			return fmt.Errorf("%w: insufficient funds", ErrMempool)
+	txCharge, err := mempool.chargeOf(transaction)
+	if err != nil {
+		return err
+	}
+
+	var parents []umi.Hash
+
+	// Списание проверяется с учетом неподтвержденных транзакций отправителя. Если подтвержденного
+	// баланса не хватает, транзакция расходует неподтвержденные входящие средства и зависит
+	// от транзакций, которые их зачисляют.
+	if txCharge.debit > 0 {
+		sender := transaction.Sender()
+		balance := senderAccount.BalanceAt(uint32(time.Now().Unix()))
+		spent, received := mempool.pending(sender)
+
+		if balance+received < spent+txCharge.debit {
+			return fmt.Errorf("%w: insufficient funds: balance %d, pending spent %d, pending received %d, required %d",
+				ErrMempool, balance, spent, received, txCharge.debit)
+		}
+
+		if balance < spent+txCharge.debit {
+			parents = mempool.funding(sender)
+		}
+	}
+
+	if err := mempool.reserve(transaction, parents); err != nil {
+		mempool.refused++
+
+		return err
+	}
+
+	mempool.insert(hash, &transaction, txCharge, parents)
+
+	return nil
+}
+
+// pending возвращает суммы, которые спишут и зачислят неподтвержденные транзакции адреса.
+func (mempool *Mempool) pending(address umi.Address) (spent, received uint64) {
+	if addressState, ok := mempool.addresses[address]; ok {
+		return addressState.spent, addressState.received
+	}
+
+	return 0, 0
+}
+
+// funding возвращает неподтвержденные транзакции, которые зачисляют средства на адрес.
+func (mempool *Mempool) funding(address umi.Address) (hashes []umi.Hash) {
+	addressState, ok := mempool.addresses[address]
+	if !ok {
+		return hashes
+	}
+
+	for hash, transaction := range addressState.transactions {
+		txEntry := mempool.entries[hash]
+
+		credited := transaction.HasRecipient() && transaction.Recipient() == address && txEntry.credit > 0
+		feeCredited := txEntry.fee > 0 && txEntry.feeAddress == address
+
+		if credited || feeCredited {
+			hashes = append(hashes, hash)
 		}
 	}
 
-	mempool.insert(hash, &transaction)
+	return hashes
+}
+
+// verifyNftOwner проверяет, что отправитель транзакции передачи NFT является его владельцем.
+func (mempool *Mempool) verifyNftOwner(transaction umi.Transaction) error {
+	hash, ok := mempool.ledger.NftByHeight(transaction.NftHeight())
+	if !ok {
+		return fmt.Errorf("%w: nft not found", ErrMempool)
+	}
+
+	if owner, _ := mempool.ledger.NftOwner(hash); owner != transaction.Sender() {
+		return fmt.Errorf("%w: sender is not the nft owner", ErrMempool)
+	}
 
 	return nil
 }
@@ -131,7 +273,7 @@ func (mempool *Mempool) UnconfirmedBalance(address umi.Address) int64 {
 	defer mempool.RUnlock()
 
 	if addressState, ok := mempool.addresses[address]; ok {
-		return addressState.balance
+		return int64(addressState.received) - int64(addressState.spent)
 	}
 
 	return 0
@@ -152,14 +294,61 @@ func (mempool *Mempool) Transactions(address umi.Address) (transactions []*umi.T
 	return transactions
 }
 
+// Transaction возвращает неподтвержденную транзакцию по хэшу.
+func (mempool *Mempool) Transaction(hash umi.Hash) (transaction *umi.Transaction, ok bool) {
+	mempool.RLock()
+	transaction, ok = mempool.transactions[hash]
+	mempool.RUnlock()
+
+	return transaction, ok
+}
+
+// Mempool возвращает неподтвержденные транзакции в порядке поступления, поэтому транзакции,
+// от которых зависят другие, всегда идут раньше зависимых.
 func (mempool *Mempool) Mempool() (transactions []*umi.Transaction) {
 	mempool.RLock()
 	defer mempool.RUnlock()
 
-	transactions = make([]*umi.Transaction, 0, len(mempool.transactions))
+	return mempool.ordered()
+}
+
+// Parents возвращает хэши неподтвержденных транзакций, от которых зависит транзакция.
+func (mempool *Mempool) Parents(hash umi.Hash) (parents []umi.Hash) {
+	mempool.RLock()
+	defer mempool.RUnlock()
+
+	parents = make([]umi.Hash, 0)
+
+	if txEntry, ok := mempool.entries[hash]; ok {
+		for parent := range txEntry.parents {
+			parents = append(parents, parent)
+		}
+	}
 
-	for _, transaction := range mempool.transactions {
-		transactions = append(transactions, transaction)
+	return parents
+}
+
+func (mempool *Mempool) ordered() (transactions []*umi.Transaction) {
+	type seqTransaction struct {
+		seq         uint64
+		transaction *umi.Transaction
+	}
+
+	// Порядковый номер берем по ключу карты один раз, чтобы не считать хэш при каждом сравнении.
+	items := make([]seqTransaction, 0, len(mempool.transactions))
+
+	for hash, transaction := range mempool.transactions {
+		items = append(items, seqTransaction{seq: mempool.entries[hash].seq, transaction: transaction})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].seq < items[j].seq
+	})
+
+	transactions = make([]*umi.Transaction, 0, len(items))
+
+	for _, item := range items {
+		transactions = append(transactions, item.transaction)
 	}
 
 	return transactions
@@ -175,70 +364,167 @@ func (mempool *Mempool) ParseBlock(block umi.Block) {
 
 		mempool.remove(hash)
 	}
-}
 
-func (mempool *Mempool) insert(hash umi.Hash, transaction *umi.Transaction) {
-	mempool.transactions[hash] = transaction
+	mempool.CompactJournal(len(mempool.transactions), mempool.journalRecords)
+}
 
-	amount := int64(transaction.Amount())
-	sender := transaction.Sender()
+func (mempool *Mempool) journalRecords() [][]byte {
+	records := make([][]byte, 0, len(mempool.transactions))
 
-	senderState, ok := mempool.addresses[sender]
-	if !ok {
-		senderState = &state{transactions: make(map[umi.Hash]*umi.Transaction)}
-		mempool.addresses[sender] = senderState
+	// Порядок поступления сохраняется, чтобы при восстановлении зависимые транзакции
+	// проверялись после тех, от которых они зависят.
+	for _, transaction := range mempool.ordered() {
+		records = append(records, *transaction)
 	}
 
-	senderState.balance -= amount
-	senderState.transactions[hash] = transaction
+	return records
+}
 
-	if transaction.HasRecipient() {
-		recipient := transaction.Recipient()
+// RestoreBlock возвращает в мемпул транзакции из блока, удаленного при откате блокчейна.
+// Транзакции проходят обычную проверку, невалидные отбрасываются.
+func (mempool *Mempool) RestoreBlock(block umi.Block) {
+	for i, txCount := 0, block.TransactionCount(); i < txCount; i++ {
+		transaction := make(umi.Transaction, umi.TxLength)
+		copy(transaction, block.Transaction(i))
 
-		recipientState, ok := mempool.addresses[recipient]
-		if !ok {
-			recipientState = &state{transactions: make(map[umi.Hash]*umi.Transaction)}
-			mempool.addresses[recipient] = recipientState
+		switch transaction.Version() {
+		case umi.TxV0Genesis, umi.TxV18MintNftWitness:
+			continue
 		}
 
-		recipientState.balance += amount
-		recipientState.transactions[hash] = transaction
+		_ = mempool.Push(transaction)
+	}
+}
+
+func (mempool *Mempool) insert(hash umi.Hash, transaction *umi.Transaction, txCharge charge, parents []umi.Hash) {
+	mempool.seq++
+
+	txEntry := &entry{
+		charge:    txCharge,
+		hash:      hash,
+		timestamp: transaction.Timestamp(),
+		seq:       mempool.seq,
+		parents:   make(map[umi.Hash]struct{}),
+		children:  make(map[umi.Hash]struct{}),
+	}
+
+	for _, parent := range parents {
+		txEntry.parents[parent] = struct{}{}
+		mempool.entries[parent].children[hash] = struct{}{}
+	}
+
+	mempool.transactions[hash] = transaction
+	mempool.entries[hash] = txEntry
+	mempool.bytes += len(*transaction)
+
+	heap.Push(&mempool.queue, txEntry)
+
+	mempool.attach(transaction.Sender(), hash, transaction, txCharge.debit, 0)
+	mempool.addresses[transaction.Sender()].sent++
+
+	if transaction.HasRecipient() {
+		mempool.attach(transaction.Recipient(), hash, transaction, 0, txCharge.credit)
+	}
+
+	if txCharge.fee > 0 {
+		mempool.attach(txCharge.feeAddress, hash, transaction, 0, txCharge.fee)
 	}
 
 	mempool.notify(transaction)
 }
 
+// remove удаляет транзакцию из мемпула. Зависимые транзакции остаются: если транзакция
+// подтверждена, средства уже зачислены на баланс.
 func (mempool *Mempool) remove(hash umi.Hash) {
 	transaction, ok := mempool.transactions[hash]
 	if !ok {
 		return
 	}
 
+	txEntry := mempool.entries[hash]
+
 	delete(mempool.transactions, hash)
+	delete(mempool.entries, hash)
 
-	amount := int64(transaction.Amount())
-	sender := transaction.Sender()
+	mempool.bytes -= len(*transaction)
 
-	senderState := mempool.addresses[sender]
-	senderState.balance += amount
+	heap.Remove(&mempool.queue, txEntry.index)
 
-	delete(senderState.transactions, hash)
+	for parent := range txEntry.parents {
+		if parentEntry, ok := mempool.entries[parent]; ok {
+			delete(parentEntry.children, hash)
+		}
+	}
 
-	if len(senderState.transactions) == 0 {
-		delete(mempool.addresses, sender)
+	for child := range txEntry.children {
+		if childEntry, ok := mempool.entries[child]; ok {
+			delete(childEntry.parents, hash)
+		}
+	}
+
+	if senderState, ok := mempool.addresses[transaction.Sender()]; ok {
+		senderState.sent--
 	}
 
+	mempool.detach(transaction.Sender(), hash, txEntry.debit, 0)
+
 	if transaction.HasRecipient() {
-		recipient := transaction.Recipient()
+		mempool.detach(transaction.Recipient(), hash, 0, txEntry.credit)
+	}
+
+	if txEntry.fee > 0 {
+		mempool.detach(txEntry.feeAddress, hash, 0, txEntry.fee)
+	}
+}
 
-		recipientState := mempool.addresses[recipient]
-		recipientState.balance += amount
+// evict удаляет невалидную транзакцию вместе с зависимыми транзакциями, которые без нее
+// больше не обеспечены средствами.
+func (mempool *Mempool) evict(hash umi.Hash) {
+	txEntry, ok := mempool.entries[hash]
+	if !ok {
+		return
+	}
 
-		delete(recipientState.transactions, hash)
+	children := make([]umi.Hash, 0, len(txEntry.children))
 
-		if len(recipientState.transactions) == 0 {
-			delete(mempool.addresses, recipient)
-		}
+	for child := range txEntry.children {
+		children = append(children, child)
+	}
+
+	mempool.remove(hash)
+
+	for _, child := range children {
+		mempool.evict(child)
+	}
+}
+
+func (mempool *Mempool) attach(address umi.Address, hash umi.Hash, transaction *umi.Transaction, spent, received uint64) {
+	addressState, ok := mempool.addresses[address]
+	if !ok {
+		addressState = &state{transactions: make(map[umi.Hash]*umi.Transaction)}
+		mempool.addresses[address] = addressState
+	}
+
+	addressState.spent += spent
+	addressState.received += received
+	addressState.transactions[hash] = transaction
+}
+
+// detach отменяет изменения, внесенные attach. Если отправитель и получатель совпадают,
+// состояние адреса может быть удалено раньше, повторный вызов ничего не делает.
+func (mempool *Mempool) detach(address umi.Address, hash umi.Hash, spent, received uint64) {
+	addressState, ok := mempool.addresses[address]
+	if !ok {
+		return
+	}
+
+	addressState.spent -= spent
+	addressState.received -= received
+
+	delete(addressState.transactions, hash)
+
+	if len(addressState.transactions) == 0 {
+		delete(mempool.addresses, address)
 	}
 }
 
@@ -262,14 +548,14 @@ func (mempool *Mempool) cleanup() {
 
 		// Транзакция из будущего.
 		if txTimestamp > timestamp {
-			mempool.remove(hash)
+			mempool.evict(hash)
 
 			continue
 		}
 
 		// Просроченная транзакция.
 		if timestamp-txTimestamp > 3600 {
-			mempool.remove(hash)
+			mempool.evict(hash)
 
 			continue
 		}
@@ -277,21 +563,24 @@ func (mempool *Mempool) cleanup() {
 		// Баланс отправителя не существует.
 		account, ok := mempool.ledger.Account(transaction.Sender())
 		if !ok {
-			mempool.remove(hash)
+			mempool.evict(hash)
 
 			continue
 		}
 
-		// На балансе недостаточно монет.
-		if account.BalanceAt(timestamp) < transaction.Amount() {
-			mempool.remove(hash)
+		// На балансе недостаточно монет. Транзакции, которые расходуют неподтвержденные входящие
+		// средства, остаются, пока в мемпуле есть транзакции, от которых они зависят.
+		txEntry := mempool.entries[hash]
+		if len(txEntry.parents) == 0 && account.BalanceAt(timestamp) < txEntry.debit {
+			mempool.evict(hash)
 
 			continue
 		}
 
-		// Структура получателя не существует.
-		if _, ok := mempool.ledger.Structure(transaction.Recipient().Prefix()); !ok {
-			mempool.remove(hash)
+		// Транзакция больше не проходит проверку своего типа: структура получателя не существует,
+		// структура уже создана, сменился владелец структуры или NFT.
+		if _, err := mempool.chargeOf(*transaction); err != nil {
+			mempool.evict(hash)
 
 			continue
 		}
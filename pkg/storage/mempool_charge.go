@@ -0,0 +1,131 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"fmt"
+
+	"gitlab.com/umitop/umid/pkg/ledger"
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// charge описывает изменения балансов, которые произойдут после подтверждения транзакции.
+type charge struct {
+	debit      uint64
+	credit     uint64
+	fee        uint64
+	feeAddress umi.Address
+}
+
+// chargeOf проверяет транзакцию по правилам ее типа так же, как это сделает конфирмер,
+// и рассчитывает списание с отправителя, зачисление получателю и комиссию структуры.
+func (mempool *Mempool) chargeOf(transaction umi.Transaction) (txCharge charge, err error) {
+	switch transaction.Type() {
+	case umi.TxSend:
+		return mempool.chargeSend(transaction)
+
+	case umi.TxCreateStructure:
+		prefix := transaction.Prefix()
+
+		if _, ok := mempool.ledger.Structure(prefix); ok {
+			return txCharge, fmt.Errorf("%w: structure %s already exists", ErrMempool, prefix.String())
+		}
+
+		txCharge.debit = transaction.Amount()
+
+	case umi.TxUpdateStructure, umi.TxChangeProfitAddress, umi.TxChangeFeeAddress,
+		umi.TxActivateTransit, umi.TxDeactivateTransit:
+		// Конфирмер списывает сумму этих транзакций с владельца структуры.
+		err = mempool.verifyStructureOwner(transaction)
+		txCharge.debit = transaction.Amount()
+
+	case umi.TxIssue:
+		// Выпуск монет ничего не списывает с отправителя.
+		err = mempool.verifyStructureOwner(transaction)
+		txCharge.credit = transaction.Amount()
+
+	case umi.TxBurn:
+		txCharge.debit = transaction.Amount()
+
+	case umi.TxTransferNft:
+		err = mempool.verifyNftOwner(transaction)
+
+	default:
+		err = fmt.Errorf("%w: %s transactions are not accepted", ErrMempool, transaction.Type())
+	}
+
+	return txCharge, err
+}
+
+// chargeSend рассчитывает комиссию перевода той же функцией, что и конфирмер.
+func (mempool *Mempool) chargeSend(transaction umi.Transaction) (txCharge charge, err error) {
+	recipient := transaction.Recipient()
+	amount := transaction.Amount()
+
+	structure, ok := mempool.ledger.Structure(recipient.Prefix())
+	if !ok {
+		return txCharge, fmt.Errorf("%w: recipient structure %s not found", ErrMempool, recipient.Prefix().String())
+	}
+
+	txCharge.debit = amount
+	txCharge.credit = amount
+
+	senderType := mempool.accountType(transaction.Sender())
+	recipientType := mempool.accountType(recipient)
+
+	if fee, feeAddress, ok := ledger.CalculateFee(senderType, recipientType, structure, amount); ok {
+		txCharge.fee = fee
+		txCharge.feeAddress = feeAddress
+		txCharge.credit -= fee
+	}
+
+	return txCharge, nil
+}
+
+// accountType возвращает тип аккаунта. Аккаунт, которого еще нет в леджере, получит тип
+// по умолчанию своей структуры, как при подтверждении транзакции.
+func (mempool *Mempool) accountType(address umi.Address) umi.AccountType {
+	if account, ok := mempool.ledger.Account(address); ok {
+		return account.Type
+	}
+
+	if structure, ok := mempool.ledger.Structure(address.Prefix()); ok {
+		return structure.AccountType()
+	}
+
+	return umi.Genesis
+}
+
+// verifyStructureOwner проверяет, что структура существует и отправитель является ее владельцем.
+func (mempool *Mempool) verifyStructureOwner(transaction umi.Transaction) error {
+	prefix := transaction.Prefix()
+
+	structure, ok := mempool.ledger.Structure(prefix)
+	if !ok {
+		return fmt.Errorf("%w: structure %s not found", ErrMempool, prefix.String())
+	}
+
+	if !structure.IsOwner(transaction.Sender()) {
+		return fmt.Errorf("%w: sender is not the owner of structure %s", ErrMempool, prefix.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// MempoolJournal ведет журнал транзакций мемпула. Встраивается в мемпул, методы вызываются
+// под блокировкой мемпула.
+type MempoolJournal struct {
+	journal *Journal
+}
+
+// SetJournal задает журнал, в который записываются принятые в мемпул транзакции.
+func (mj *MempoolJournal) SetJournal(journal *Journal) {
+	mj.journal = journal
+}
+
+// AppendJournal записывает принятую в мемпул транзакцию. Ошибка записи не отменяет прием.
+func (mj *MempoolJournal) AppendJournal(record []byte) {
+	if mj.journal == nil {
+		return
+	}
+
+	if err := mj.journal.Append(record); err != nil {
+		log.Printf("mempool: %v", err)
+	}
+}
+
+// RestoreJournal загружает транзакции из журнала после перезапуска ноды и передает в push
+// непросроченные, после чего журнал сжимается до records. timestamp возвращает метку времени
+// транзакции или false для поврежденной записи.
+func (mj *MempoolJournal) RestoreJournal(timestamp func(record []byte) (uint32, bool),
+	push func(record []byte) error, records func() [][]byte) error {
+	if mj.journal == nil {
+		return nil
+	}
+
+	loaded, err := mj.journal.Load()
+	if err != nil {
+		return err
+	}
+
+	now := uint32(time.Now().Unix())
+
+	for _, record := range loaded {
+		txTimestamp, ok := timestamp(record)
+		if !ok || expired(txTimestamp, now) {
+			continue
+		}
+
+		_ = push(record)
+	}
+
+	return mj.journal.Compact(records())
+}
+
+// CompactJournal сжимает журнал, когда устаревших записей в нем становится больше, чем
+// актуальных (count).
+func (mj *MempoolJournal) CompactJournal(count int, records func() [][]byte) {
+	if mj.journal == nil || mj.journal.Records() <= 2*count {
+		return
+	}
+
+	if err := mj.journal.Compact(records()); err != nil {
+		log.Printf("mempool: %v", err)
+	}
+}
+
+// expired возвращает true для транзакций из будущего и транзакций старше часа.
+func expired(txTimestamp, timestamp uint32) bool {
+	return txTimestamp > timestamp || timestamp-txTimestamp > 3600
+}
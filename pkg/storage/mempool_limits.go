@@ -0,0 +1,185 @@
+// Copyright (c) 2021 UMI
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package storage
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+
+	"gitlab.com/umitop/umid/pkg/umi"
+)
+
+// ErrMempoolFull возвращается, когда транзакция не принята из-за ограничений размера мемпула.
+var ErrMempoolFull = errors.New("mempool is full")
+
+// MempoolMetrics — текущий размер мемпула и счетчики вытесненных и отклоненных транзакций.
+type MempoolMetrics struct {
+	Count   int
+	Bytes   int
+	Evicted uint64
+	Refused uint64
+}
+
+// SetLimits задает максимальное количество транзакций, их суммарный размер в байтах
+// и количество неподтвержденных транзакций одного отправителя. Нулевое значение снимает ограничение.
+func (mempool *Mempool) SetLimits(maxCount, maxBytes, senderQuota int) {
+	mempool.Lock()
+	defer mempool.Unlock()
+
+	mempool.maxCount = maxCount
+	mempool.maxBytes = maxBytes
+	mempool.senderQuota = senderQuota
+}
+
+func (mempool *Mempool) Metrics() MempoolMetrics {
+	mempool.RLock()
+	defer mempool.RUnlock()
+
+	return MempoolMetrics{
+		Count:   len(mempool.transactions),
+		Bytes:   mempool.bytes,
+		Evicted: mempool.evicted,
+		Refused: mempool.refused,
+	}
+}
+
+// reserve освобождает место под новую транзакцию, вытесняя транзакции с самой старой меткой времени.
+// Транзакции, от которых зависит новая транзакция, не вытесняются. Если новая транзакция старше
+// всех, которые можно вытеснить, она не принимается.
+func (mempool *Mempool) reserve(transaction umi.Transaction, parents []umi.Hash) error {
+	if mempool.senderQuota > 0 {
+		if senderState, ok := mempool.addresses[transaction.Sender()]; ok && senderState.sent >= mempool.senderQuota {
+			return fmt.Errorf("%w: sender has %d pending transactions", ErrMempoolFull, senderState.sent)
+		}
+	}
+
+	protected := mempool.ancestors(parents)
+
+	for mempool.overflows(len(transaction)) {
+		victim, ok := mempool.oldest(protected)
+		if !ok || mempool.transactions[victim].Timestamp() >= transaction.Timestamp() {
+			return fmt.Errorf("%w: %d transactions, %d bytes", ErrMempoolFull, len(mempool.transactions), mempool.bytes)
+		}
+
+		count := len(mempool.transactions)
+
+		mempool.evict(victim)
+		mempool.evicted += uint64(count - len(mempool.transactions))
+	}
+
+	return nil
+}
+
+func (mempool *Mempool) overflows(size int) bool {
+	if mempool.maxCount > 0 && len(mempool.transactions)+1 > mempool.maxCount {
+		return true
+	}
+
+	return mempool.maxBytes > 0 && mempool.bytes+size > mempool.maxBytes
+}
+
+// oldest возвращает транзакцию с самой старой меткой времени, при равенстве — поступившую раньше.
+// Защищенные транзакции временно снимаются с вершины кучи и возвращаются обратно.
+func (mempool *Mempool) oldest(protected map[umi.Hash]struct{}) (hash umi.Hash, ok bool) {
+	skipped := make([]*entry, 0)
+
+	for mempool.queue.Len() > 0 {
+		txEntry := mempool.queue[0]
+
+		if _, skip := protected[txEntry.hash]; !skip {
+			hash, ok = txEntry.hash, true
+
+			break
+		}
+
+		skipped = append(skipped, heap.Pop(&mempool.queue).(*entry))
+	}
+
+	for _, txEntry := range skipped {
+		heap.Push(&mempool.queue, txEntry)
+	}
+
+	return hash, ok
+}
+
+// evictionQueue — куча транзакций в порядке вытеснения: на вершине транзакция с самой старой
+// меткой времени, при равенстве — поступившая раньше.
+type evictionQueue []*entry
+
+func (queue evictionQueue) Len() int {
+	return len(queue)
+}
+
+func (queue evictionQueue) Less(i, j int) bool {
+	if queue[i].timestamp != queue[j].timestamp {
+		return queue[i].timestamp < queue[j].timestamp
+	}
+
+	return queue[i].seq < queue[j].seq
+}
+
+func (queue evictionQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+
+func (queue *evictionQueue) Push(x interface{}) {
+	txEntry := x.(*entry)
+	txEntry.index = len(*queue)
+	*queue = append(*queue, txEntry)
+}
+
+func (queue *evictionQueue) Pop() interface{} {
+	old := *queue
+	n := len(old)
+	txEntry := old[n-1]
+	old[n-1] = nil
+	*queue = old[:n-1]
+
+	return txEntry
+}
+
+// ancestors возвращает транзакции, от которых прямо или через цепочку зависят переданные.
+func (mempool *Mempool) ancestors(parents []umi.Hash) map[umi.Hash]struct{} {
+	hashes := make(map[umi.Hash]struct{})
+	queue := append(make([]umi.Hash, 0, len(parents)), parents...)
+
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		if _, ok := hashes[hash]; ok {
+			continue
+		}
+
+		hashes[hash] = struct{}{}
+
+		if txEntry, ok := mempool.entries[hash]; ok {
+			for parent := range txEntry.parents {
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return hashes
+}
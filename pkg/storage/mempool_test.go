@@ -23,9 +23,11 @@ package storage_test
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"testing"
 	"time"
 
+	"gitlab.com/umitop/umid/pkg/config"
 	"gitlab.com/umitop/umid/pkg/ledger"
 	. "gitlab.com/umitop/umid/pkg/storage"
 	"gitlab.com/umitop/umid/pkg/umi"
@@ -53,6 +55,8 @@ func (bc *BlockchainMock) Notify(block umi.Block) {
 
 type mockLedger struct {
 	Transactions map[umi.Hash]struct{}
+	NftHeights   map[uint64]umi.Hash
+	NftOwners    map[umi.Hash]umi.Address
 }
 
 func NewLedgerMock() *mockLedger {
@@ -62,7 +66,7 @@ func NewLedgerMock() *mockLedger {
 }
 
 func (mock *mockLedger) Structure(prefix umi.Prefix) (structure *ledger.Structure, ok bool) {
-	panic("implement me")
+	return &ledger.Structure{Prefix: prefix}, true
 }
 
 func (mock *mockLedger) Account(address umi.Address) (account *ledger.Account, ok bool) {
@@ -81,6 +85,18 @@ func (mock *mockLedger) HasTransaction(hash umi.Hash) bool {
 	return ok
 }
 
+func (mock *mockLedger) NftByHeight(height uint64) (hash umi.Hash, ok bool) {
+	hash, ok = mock.NftHeights[height]
+
+	return hash, ok
+}
+
+func (mock *mockLedger) NftOwner(hash umi.Hash) (owner umi.Address, ok bool) {
+	owner, ok = mock.NftOwners[hash]
+
+	return owner, ok
+}
+
 func TestMempool_Subscribe(t *testing.T) {
 	t.Parallel()
 
@@ -136,6 +152,48 @@ func TestMempool_Push(t *testing.T) {
 	}
 }
 
+func TestMempool_PushTransferNft(t *testing.T) {
+	t.Parallel()
+
+	owner := umi.Address{}
+	owner.SetPrefix(umi.PfxVerNft)
+	owner[2] = 1
+
+	recipient := owner
+	recipient[2] = 2
+
+	stranger := owner
+	stranger[2] = 3
+
+	hash := umi.Hash{1}
+	mock := &mockLedger{
+		NftHeights: map[uint64]umi.Hash{7: hash},
+		NftOwners:  map[umi.Hash]umi.Address{hash: owner},
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(mock)
+
+	tests := []struct {
+		sender umi.Address
+		height uint64
+		ok     bool
+	}{
+		{stranger, 7, false},
+		{owner, 8, false},
+		{owner, 7, true},
+	}
+
+	for i, test := range tests {
+		transaction := umi.NewTransaction().SetVersion(umi.TxV19TransferNft).SetSender(test.sender).SetRecipient(recipient)
+		transaction.SetNftHeight(test.height)
+
+		if err := mempool.Push(transaction); (err == nil) != test.ok {
+			t.Errorf("%d: получили '%v'", i, err)
+		}
+	}
+}
+
 func TestMempool_Mempool(t *testing.T) {
 	t.Parallel()
 
@@ -254,3 +312,474 @@ func TestMempool_Transactions(t *testing.T) {
 		t.Errorf("expected %d, got %d", 1, len(transactions))
 	}
 }
+
+func TestMempool_Restore(t *testing.T) {
+	t.Parallel()
+
+	conf := config.DefaultConfig()
+	conf.DataDir = t.TempDir()
+
+	sender := umi.Address{}
+	recipient := umi.Address{}
+
+	_, _ = rand.Read(sender[:])
+	_, _ = rand.Read(recipient[:])
+
+	timestamp := uint32(time.Now().Unix())
+
+	transaction1 := umi.NewTransaction()
+	transaction1.SetVersion(umi.TxV1Send).SetSender(sender).SetRecipient(recipient).SetAmount(1).SetTimestamp(timestamp)
+
+	transaction2 := umi.NewTransaction()
+	transaction2.SetVersion(umi.TxV1Send).SetSender(sender).SetRecipient(recipient).SetAmount(2).SetTimestamp(timestamp)
+
+	expiredTransaction := umi.NewTransaction()
+	expiredTransaction.SetVersion(umi.TxV1Send).SetSender(sender).SetRecipient(recipient).SetAmount(3).
+		SetTimestamp(timestamp - 3601)
+
+	journal := NewJournal(conf, "mempool")
+
+	if err := journal.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(NewLedgerMock())
+	mempool.SetJournal(journal)
+
+	for _, transaction := range []umi.Transaction{transaction1, transaction2, expiredTransaction} {
+		if err := mempool.Push(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	journal.Close()
+
+	journal = NewJournal(conf, "mempool")
+
+	if err := journal.OpenOrCreate(); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	ledgerMock := NewLedgerMock()
+	ledgerMock.Transactions[transaction2.Hash()] = struct{}{}
+
+	mempool = NewMempool()
+	mempool.SetLedger(ledgerMock)
+	mempool.SetJournal(journal)
+
+	if err := mempool.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := mempool.Transaction(transaction1.Hash()); !ok {
+		t.Error("transaction must be restored")
+	}
+
+	if count := len(mempool.Mempool()); count != 1 {
+		t.Errorf("expected %d, got %d", 1, count)
+	}
+
+	if records := journal.Records(); records != 1 {
+		t.Errorf("expected %d records, got %d", 1, records)
+	}
+
+	block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+	block = append(block, transaction1...)
+	block = append(block, make([]byte, 118)...)
+
+	mempool.ParseBlock(block)
+
+	if records := journal.Records(); records != 0 {
+		t.Errorf("expected %d records, got %d", 0, records)
+	}
+}
+
+type accountsLedgerMock struct {
+	accounts   map[umi.Address]*ledger.Account
+	structures map[umi.Prefix]*ledger.Structure
+}
+
+func (mock *accountsLedgerMock) Structure(prefix umi.Prefix) (structure *ledger.Structure, ok bool) {
+	structure, ok = mock.structures[prefix]
+
+	return structure, ok
+}
+
+func (mock *accountsLedgerMock) Account(address umi.Address) (account *ledger.Account, ok bool) {
+	account, ok = mock.accounts[address]
+
+	return account, ok
+}
+
+func (mock *accountsLedgerMock) HasTransaction(hash umi.Hash) bool {
+	return false
+}
+
+func (mock *accountsLedgerMock) NftByHeight(uint64) (umi.Hash, bool) {
+	return umi.Hash{}, false
+}
+
+func (mock *accountsLedgerMock) NftOwner(umi.Hash) (umi.Address, bool) {
+	return umi.Address{}, false
+}
+
+func TestMempool_PushPendingSpends(t *testing.T) {
+	t.Parallel()
+
+	owner := umi.Address{}
+	sender := umi.Address{}
+	deposit := umi.Address{}
+
+	_, _ = rand.Read(owner[:])
+	_, _ = rand.Read(sender[:])
+	_, _ = rand.Read(deposit[:])
+
+	owner.SetPrefix(umi.ParsePrefix("umi"))
+	sender.SetPrefix(umi.ParsePrefix("umi"))
+
+	prefix := umi.ParsePrefix("aaa")
+	deposit.SetPrefix(prefix)
+
+	structure := ledger.NewStructure("umi", prefix, owner)
+	structure.FeePercent = 10_00
+
+	ledgerMock := &accountsLedgerMock{
+		accounts: map[umi.Address]*ledger.Account{
+			owner:   {Type: umi.Umi, Balance: 50_000_00},
+			sender:  {Type: umi.Umi, Balance: 100},
+			deposit: {Type: umi.Deposit},
+		},
+		structures: map[umi.Prefix]*ledger.Structure{
+			umi.ParsePrefix("umi"): ledger.NewStructure("umi", umi.ParsePrefix("umi"), owner),
+			prefix:                 structure,
+		},
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(ledgerMock)
+
+	send1 := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).SetRecipient(deposit).SetAmount(60)
+	send2 := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).SetRecipient(deposit).SetAmount(50)
+	burn := umi.NewTransaction().SetVersion(umi.TxV15Burn).SetSender(sender).SetAmount(40)
+
+	if err := mempool.Push(send1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mempool.Push(send2); err == nil {
+		t.Error("must return error: pending spends exceed balance")
+	}
+
+	if err := mempool.Push(burn); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance := mempool.UnconfirmedBalance(sender); balance != -100 {
+		t.Errorf("expected %d, got %d", -100, balance)
+	}
+
+	if balance := mempool.UnconfirmedBalance(deposit); balance != 54 {
+		t.Errorf("expected %d, got %d", 54, balance)
+	}
+
+	if balance := mempool.UnconfirmedBalance(structure.FeeAddress); balance != 6 {
+		t.Errorf("expected %d, got %d", 6, balance)
+	}
+
+	create := umi.NewTransaction().SetVersion(umi.TxV9CreateStructure).SetSender(owner).SetPrefix(prefix)
+	if err := mempool.Push(create); err == nil {
+		t.Error("must return error: structure exists")
+	}
+
+	update := umi.NewTransaction().SetVersion(umi.TxV10UpdateStructure).SetSender(sender).SetPrefix(prefix)
+	if err := mempool.Push(update); err == nil {
+		t.Error("must return error: sender is not the owner")
+	}
+
+	issue := umi.NewTransaction().SetVersion(umi.TxV16Issue).SetSender(owner).SetPrefix(prefix).
+		SetRecipient(sender).SetAmount(1_000_000)
+	if err := mempool.Push(issue); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance := mempool.UnconfirmedBalance(owner); balance != 0 {
+		t.Errorf("expected %d, got %d", 0, balance)
+	}
+
+	// Поле суммы транзакций управления структурой заполнено, но конфирмер списывает
+	// с владельца transaction.Amount(), поэтому мемпул должен списать столько же.
+	update = umi.NewTransaction().SetVersion(umi.TxV10UpdateStructure).SetSender(owner).SetPrefix(prefix).
+		SetAmount(100_000_00)
+	if err := mempool.Push(update); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance := mempool.UnconfirmedBalance(owner); balance != -int64(update.Amount()) {
+		t.Errorf("expected %d, got %d", -int64(update.Amount()), balance)
+	}
+
+	block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+	block = append(block, send1...)
+	block = append(block, make([]byte, 118)...)
+
+	mempool.ParseBlock(block)
+
+	if balance := mempool.UnconfirmedBalance(deposit); balance != 0 {
+		t.Errorf("expected %d, got %d", 0, balance)
+	}
+
+	if balance := mempool.UnconfirmedBalance(structure.FeeAddress); balance != 0 {
+		t.Errorf("expected %d, got %d", 0, balance)
+	}
+}
+
+func TestMempool_PushNewRecipientFee(t *testing.T) {
+	t.Parallel()
+
+	owner := umi.Address{}
+	sender := umi.Address{}
+	deposit := umi.Address{}
+
+	_, _ = rand.Read(owner[:])
+	_, _ = rand.Read(sender[:])
+	_, _ = rand.Read(deposit[:])
+
+	owner.SetPrefix(umi.ParsePrefix("umi"))
+	sender.SetPrefix(umi.ParsePrefix("umi"))
+
+	prefix := umi.ParsePrefix("aaa")
+	deposit.SetPrefix(prefix)
+
+	structure := ledger.NewStructure("umi", prefix, owner)
+	structure.FeePercent = 10_00
+
+	// Аккаунта получателя еще нет: конфирмер создаст его с типом по умолчанию структуры
+	// и спишет комиссию, мемпул должен учесть ее так же.
+	ledgerMock := &accountsLedgerMock{
+		accounts: map[umi.Address]*ledger.Account{
+			sender: {Type: umi.Umi, Balance: 100},
+		},
+		structures: map[umi.Prefix]*ledger.Structure{
+			umi.ParsePrefix("umi"): ledger.NewStructure("umi", umi.ParsePrefix("umi"), owner),
+			prefix:                 structure,
+		},
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(ledgerMock)
+
+	send := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).SetRecipient(deposit).SetAmount(60)
+
+	if err := mempool.Push(send); err != nil {
+		t.Fatal(err)
+	}
+
+	fee, _, ok := ledger.CalculateFee(umi.Umi, structure.AccountType(), structure, 60)
+	if !ok || fee != 6 {
+		t.Fatalf("expected %d, got %d", 6, fee)
+	}
+
+	if balance := mempool.UnconfirmedBalance(deposit); balance != 54 {
+		t.Errorf("expected %d, got %d", 54, balance)
+	}
+
+	if balance := mempool.UnconfirmedBalance(structure.FeeAddress); balance != 6 {
+		t.Errorf("expected %d, got %d", 6, balance)
+	}
+}
+
+func TestMempool_PushDependencyChain(t *testing.T) {
+	t.Parallel()
+
+	alice := umi.Address{}
+	bob := umi.Address{}
+	carol := umi.Address{}
+
+	_, _ = rand.Read(alice[:])
+	_, _ = rand.Read(bob[:])
+	_, _ = rand.Read(carol[:])
+
+	alice.SetPrefix(umi.ParsePrefix("umi"))
+	bob.SetPrefix(umi.ParsePrefix("umi"))
+	carol.SetPrefix(umi.ParsePrefix("umi"))
+
+	ledgerMock := &accountsLedgerMock{
+		accounts: map[umi.Address]*ledger.Account{
+			alice: {Type: umi.Umi, Balance: 100},
+			bob:   {Type: umi.Umi},
+		},
+		structures: map[umi.Prefix]*ledger.Structure{
+			umi.ParsePrefix("umi"): ledger.NewStructure("umi", umi.ParsePrefix("umi"), alice),
+		},
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(ledgerMock)
+
+	parent := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(alice).SetRecipient(bob).SetAmount(80)
+	child := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(bob).SetRecipient(carol).SetAmount(50)
+	overspend := umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(bob).SetRecipient(carol).SetAmount(40)
+
+	if err := mempool.Push(child); err == nil {
+		t.Error("must return error: sender has no funds")
+	}
+
+	if err := mempool.Push(parent); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mempool.Push(child); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mempool.Push(overspend); err == nil {
+		t.Error("must return error: chain is not funded")
+	}
+
+	if parents := mempool.Parents(child.Hash()); len(parents) != 1 || parents[0] != parent.Hash() {
+		t.Errorf("expected parent %x, got %x", parent.Hash(), parents)
+	}
+
+	transactions := mempool.Mempool()
+
+	if len(transactions) != 2 || transactions[0].Hash() != parent.Hash() {
+		t.Error("parent must be ordered before child")
+	}
+
+	block := umi.NewBlock().SetVersion(1).SetTransactionCount(1)
+	block = append(block, parent...)
+	block = append(block, make([]byte, 118)...)
+
+	mempool.ParseBlock(block)
+
+	if _, ok := mempool.Transaction(child.Hash()); !ok {
+		t.Error("child must stay in mempool after parent is confirmed")
+	}
+
+	if parents := mempool.Parents(child.Hash()); len(parents) != 0 {
+		t.Errorf("expected no parents, got %d", len(parents))
+	}
+}
+
+func TestMempool_Limits(t *testing.T) {
+	t.Parallel()
+
+	sender1 := umi.Address{}
+	sender2 := umi.Address{}
+	recipient := umi.Address{}
+
+	_, _ = rand.Read(sender1[:])
+	_, _ = rand.Read(sender2[:])
+	_, _ = rand.Read(recipient[:])
+
+	timestamp := uint32(time.Now().Unix())
+
+	newTx := func(sender umi.Address, age uint32) umi.Transaction {
+		return umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).SetRecipient(recipient).
+			SetAmount(1).SetTimestamp(timestamp - age)
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(NewLedgerMock())
+	mempool.SetLimits(2, 0, 2)
+
+	oldest := newTx(sender1, 30)
+	older := newTx(sender1, 20)
+	newest := newTx(sender2, 10)
+
+	for _, transaction := range []umi.Transaction{oldest, older, newest} {
+		if err := mempool.Push(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := mempool.Transaction(oldest.Hash()); ok {
+		t.Error("oldest transaction must be evicted")
+	}
+
+	if err := mempool.Push(newTx(sender2, 40)); !errors.Is(err, ErrMempoolFull) {
+		t.Errorf("expected %v, got %v", ErrMempoolFull, err)
+	}
+
+	mempool.SetLimits(0, 0, 1)
+
+	if err := mempool.Push(newTx(sender1, 5)); !errors.Is(err, ErrMempoolFull) {
+		t.Errorf("expected %v, got %v", ErrMempoolFull, err)
+	}
+
+	metrics := mempool.Metrics()
+
+	if metrics.Count != 2 || metrics.Bytes != 2*umi.TxLength || metrics.Evicted != 1 || metrics.Refused != 2 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+func TestMempool_LimitsKeepParents(t *testing.T) {
+	t.Parallel()
+
+	alice := umi.Address{}
+	bob := umi.Address{}
+	carol := umi.Address{}
+	dave := umi.Address{}
+
+	for _, address := range []*umi.Address{&alice, &bob, &carol, &dave} {
+		_, _ = rand.Read(address[:])
+		address.SetPrefix(umi.ParsePrefix("umi"))
+	}
+
+	ledgerMock := &accountsLedgerMock{
+		accounts: map[umi.Address]*ledger.Account{
+			alice: {Type: umi.Umi, Balance: 100},
+			bob:   {Type: umi.Umi},
+			dave:  {Type: umi.Umi, Balance: 100},
+		},
+		structures: map[umi.Prefix]*ledger.Structure{
+			umi.ParsePrefix("umi"): ledger.NewStructure("umi", umi.ParsePrefix("umi"), alice),
+		},
+	}
+
+	timestamp := uint32(time.Now().Unix())
+
+	newTx := func(sender, recipient umi.Address, amount uint64, age uint32) umi.Transaction {
+		return umi.NewTransaction().SetVersion(umi.TxV8Send).SetSender(sender).SetRecipient(recipient).
+			SetAmount(amount).SetTimestamp(timestamp - age)
+	}
+
+	mempool := NewMempool()
+	mempool.SetLedger(ledgerMock)
+	mempool.SetLimits(2, 0, 0)
+
+	parent := newTx(alice, bob, 80, 30)
+	other := newTx(dave, carol, 10, 20)
+	child := newTx(bob, carol, 50, 10)
+
+	for _, transaction := range []umi.Transaction{parent, other, child} {
+		if err := mempool.Push(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Самая старая транзакция — родительская для новой, поэтому вытесняется следующая по возрасту.
+	if _, ok := mempool.Transaction(other.Hash()); ok {
+		t.Error("other transaction must be evicted")
+	}
+
+	if _, ok := mempool.Transaction(parent.Hash()); !ok {
+		t.Error("parent transaction must stay in mempool")
+	}
+
+	// Защищенная транзакция возвращена в очередь и вытесняется вместе с зависимой.
+	if err := mempool.Push(newTx(dave, carol, 10, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := mempool.Transaction(child.Hash()); ok {
+		t.Error("child transaction must be evicted with its parent")
+	}
+
+	if metrics := mempool.Metrics(); metrics.Count != 1 || metrics.Evicted != 3 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
@@ -122,6 +122,16 @@ func (block Block) Verify() error {
 	return nil
 }
 
+// VerifySignature проверяет подпись заголовка блока ключом генератора.
+func (block Block) VerifySignature() error {
+	return verifyBlockSignature(block[:HdrLength])
+}
+
+// VerifyMerkleRoot сверяет корень Меркла в заголовке с транзакциями блока.
+func (block Block) VerifyMerkleRoot() error {
+	return block.Legacy().VerifyMerkleRoot()
+}
+
 func (block Block) Legacy() BlockLegacy {
 	legacyBlock := make(BlockLegacy, HdrLength, HdrLength+(TxLength*block.TransactionCount()))
 
@@ -21,6 +21,7 @@
 package umi
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
@@ -92,3 +93,25 @@ func (block BlockLegacy) Verify() error {
 
 	return nil
 }
+
+// VerifySignature проверяет подпись заголовка блока ключом генератора.
+func (block BlockLegacy) VerifySignature() error {
+	return verifyBlockSignature(block[:HdrLength])
+}
+
+// VerifyMerkleRoot сверяет корень Меркла в заголовке с транзакциями блока.
+func (block BlockLegacy) VerifyMerkleRoot() error {
+	if MerkleRoot(block[HdrLength:]) != block.MerkleRootHash() {
+		return fmt.Errorf("%w: invalid merkle root", ErrBlock)
+	}
+
+	return nil
+}
+
+func verifyBlockSignature(header []byte) error {
+	if !ed25519.Verify((ed25519.PublicKey)(header[71:103]), header[0:103], header[103:167]) {
+		return fmt.Errorf("%w: invalid signature", ErrBlock)
+	}
+
+	return nil
+}
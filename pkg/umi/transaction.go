@@ -53,6 +53,7 @@ const (
 	TxV16Issue
 	TxV17MintNft
 	TxV18MintNftWitness
+	TxV19TransferNft
 )
 
 const (
@@ -68,6 +69,7 @@ const (
 	TxIssue               = "issue"
 	TxMintNft             = "mintNft"
 	TxMintNftWitness      = "mintNftWitness"
+	TxTransferNft         = "transferNft"
 	txUnknown             = "unknown"
 )
 
@@ -121,6 +123,8 @@ func (transaction Transaction) Type() string { //nolint:revive,cyclop // Easy to
 		return TxMintNft
 	case TxV18MintNftWitness:
 		return TxMintNftWitness
+	case TxV19TransferNft:
+		return TxTransferNft
 	default:
 		return txUnknown
 	}
@@ -179,6 +183,20 @@ func (transaction Transaction) SetAmount(amount uint64) Transaction {
 	return transaction
 }
 
+// NftHeight возвращает высоту транзакции, которой был выпущен передаваемый NFT. В теле транзакции
+// после адреса получателя остается 8 байт, поэтому вместо хеша NFT (32 байта) передается высота
+// транзакции выпуска, которая однозначно определяет хеш. Хеш записывается в подтвержденную
+// транзакцию (NftHash).
+func (transaction Transaction) NftHeight() uint64 {
+	return binary.BigEndian.Uint64(transaction[69:77])
+}
+
+func (transaction Transaction) SetNftHeight(height uint64) Transaction {
+	binary.BigEndian.PutUint64(transaction[69:77], height)
+
+	return transaction
+}
+
 func (transaction Transaction) Timestamp() uint32 {
 	return binary.BigEndian.Uint32(transaction[77:81])
 }
@@ -404,6 +422,20 @@ func (transaction Transaction) SetFeeAccountTransactionCount(height uint64) {
 	binary.BigEndian.PutUint64(transaction[260:268], height)
 }
 
+// meta - nft
+
+// NftHash возвращает хеш передаваемого NFT. Занимает место адреса комиссии, поэтому
+// первый байт всегда нулевой и HasFee возвращает false.
+func (transaction Transaction) NftHash() (hash Hash) {
+	copy(hash[0:32], transaction[218:250])
+
+	return hash
+}
+
+func (transaction Transaction) SetNftHash(hash Hash) {
+	copy(transaction[218:250], hash[0:32])
+}
+
 // extra
 
 func (transaction Transaction) HasRecipient() bool {
@@ -508,6 +540,9 @@ func (transaction Transaction) MarshalJSON() ([]byte, error) {
 		ProfitPercent *uint16 `json:"profitPercent,omitempty"`
 		FeePercent    *uint16 `json:"feePercent,omitempty"`
 
+		NftHeight *uint64 `json:"nftHeight,omitempty"`
+		NftHash   *string `json:"nftHash,omitempty"`
+
 		Timestamp *string `json:"timestamp,omitempty"`
 	}{
 		Hash:          transaction.Hash().String(),
@@ -598,6 +633,15 @@ func (transaction Transaction) MarshalJSON() ([]byte, error) {
 
 		data.FeePercent = new(uint16)
 		*data.FeePercent = transaction.FeePercent()
+
+	case TxTransferNft:
+		data.NftHeight = new(uint64)
+		*data.NftHeight = transaction.NftHeight()
+
+		if len(transaction) == TxConfirmedLength {
+			data.NftHash = new(string)
+			*data.NftHash = transaction.NftHash().String()
+		}
 	}
 
 	if transaction.Version() >= TxV8Send {
@@ -55,6 +55,9 @@ func (transaction Transaction) Verify() error {
 
 	case TxMintNftWitness:
 		return verifyMintNftWitness(transaction)
+
+	case TxTransferNft:
+		return verifyTransferNft(transaction)
 	}
 
 	return nil
@@ -255,3 +258,30 @@ func verifyMintNftWitness(transaction Transaction) error {
 
 	return nil
 }
+
+func verifyTransferNft(transaction Transaction) error {
+	sender := transaction.Sender()
+	recipient := transaction.Recipient()
+
+	if sender.Prefix() != PfxVerNft {
+		return fmt.Errorf("%w: sender must be 'nft'", ErrVerify)
+	}
+
+	if recipient.Prefix() != PfxVerNft {
+		return fmt.Errorf("%w: recipient must be 'nft'", ErrVerify)
+	}
+
+	if sender == recipient {
+		return fmt.Errorf("%w: sender and recipient must not be equal", ErrVerify)
+	}
+
+	if transaction.NftHeight() == 0 {
+		return fmt.Errorf("%w: nft height must not be 0", ErrVerify)
+	}
+
+	if !verifySignature(transaction) {
+		return fmt.Errorf("%w: invalid signature", ErrVerify)
+	}
+
+	return nil
+}